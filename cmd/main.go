@@ -25,6 +25,7 @@ var defaultProperties = &config.ServerProperties{
 	AppendOnly:     false,
 	AppendFilename: "",
 	MaxClients:     1000,
+	TCPKeepalive:   300,
 	RunID:          utils.RandString(40),
 }
 
@@ -53,9 +54,28 @@ func main() {
 	}
 
 	// Start the server
-	err := transport.ListenAndServeWithSignal(&transport.Config{
-		Address: fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port),
-	}, transport.NewHandler())
+	props := config.Properties
+	serverConfig := &transport.Config{}
+	// like Redis, port 0 disables plain TCP when TLS or a Unix socket is served
+	if props.Port != 0 || (props.TLSPort == 0 && props.UnixSocket == "") {
+		serverConfig.Address = fmt.Sprintf("%s:%d", props.Bind, props.Port)
+	}
+	if props.TLSPort != 0 {
+		tlsConfig, err := transport.NewTLSConfig(props)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		serverConfig.TLSAddress = fmt.Sprintf("%s:%d", props.Bind, props.TLSPort)
+		serverConfig.TLSConfig = tlsConfig
+	}
+	if props.UnixSocket != "" {
+		perm, err := props.UnixSocketMode()
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		serverConfig.UnixSocket, serverConfig.UnixSocketPerm = props.UnixSocket, perm
+	}
+	err := transport.ListenAndServeWithSignal(serverConfig, transport.NewHandler(props))
 	if err != nil {
 		logger.Errorf("failed to start server: %v", err)
 	}
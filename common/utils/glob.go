@@ -0,0 +1,81 @@
+package utils
+
+// GlobMatch reports whether s matches a glob-style pattern the way Redis does:
+// * matches any sequence, ? any single byte, [abc], [^abc] and [a-z] a set of
+// bytes, and \ escapes the next byte. Matching is case sensitive.
+func GlobMatch(pattern, s string) bool {
+	p, i := 0, 0
+	// where to resume after the last *, -1 before any
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if p == len(pattern) {
+				return true
+			}
+			starP, starI = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if width, ok := matchByte(pattern[p:], s[i]); ok {
+				p += width
+				i++
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// let the last * absorb one more byte
+		starI++
+		p, i = starP, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchByte matches c against the token at the start of pattern and returns the width of the token
+func matchByte(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '\\':
+		if len(pattern) >= 2 {
+			return 2, pattern[1] == c
+		}
+		return 1, c == '\\'
+	case '[':
+		i := 1
+		not := i < len(pattern) && pattern[i] == '^'
+		if not {
+			i++
+		}
+		match := false
+		// an unterminated set extends to the end of the pattern
+		for ; i < len(pattern) && pattern[i] != ']'; i++ {
+			switch {
+			case pattern[i] == '\\' && i+1 < len(pattern):
+				i++
+				match = match || pattern[i] == c
+			case i+2 < len(pattern) && pattern[i+1] == '-':
+				start, end := pattern[i], pattern[i+2]
+				if start > end {
+					start, end = end, start
+				}
+				match = match || (start <= c && c <= end)
+				i += 2
+			default:
+				match = match || pattern[i] == c
+			}
+		}
+		if i < len(pattern) {
+			i++ // the closing ]
+		}
+		return i, match != not
+	}
+	return 1, pattern[0] == c
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "news.sport", true},
+		{"news.*", "news.sport", true},
+		{"news.*", "weather", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*.log", "a.log.log", true},
+		{"[abc", "b", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, c := range cases {
+		if got := GlobMatch(c.pattern, c.s); got != c.match {
+			t.Errorf("GlobMatch(%q, %q) = %v, expected %v", c.pattern, c.s, got, c.match)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package acl implements users and the permissions they grant on commands, keys and
+// Pub/Sub channels, managed with the ACL command and persisted to the ACL file
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultUser is the user of new connections, and of clients authenticating with a password only
+const DefaultUser = "default"
+
+// Categories are the command categories of Redis, some have no command in this server
+var Categories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap",
+	"hyperloglog", "geo", "stream", "pubsub", "admin", "fast", "slow", "blocking",
+	"dangerous", "connection", "transaction", "scripting",
+}
+
+// Catalog lists the commands rules may refer to, with their categories
+type Catalog struct {
+	categories map[string][]string // command name to categories
+}
+
+// NewCatalog creates a catalog from command names mapped to their categories
+func NewCatalog(commands map[string][]string) *Catalog {
+	return &Catalog{categories: commands}
+}
+
+func (c *Catalog) Has(command string) bool {
+	_, ok := c.categories[command]
+	return ok
+}
+
+// Commands returns the names of every command, sorted
+func (c *Catalog) Commands() []string {
+	names := make([]string, 0, len(c.categories))
+	for name := range c.categories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CategoryCommands returns the sorted commands of a category, known is false for an unknown category
+func (c *Catalog) CategoryCommands(category string) (commands []string, known bool) {
+	if !slices.Contains(Categories, category) {
+		return nil, false
+	}
+	for name, categories := range c.categories {
+		if slices.Contains(categories, category) {
+			commands = append(commands, name)
+		}
+	}
+	slices.Sort(commands)
+	return commands, true
+}
+
+// ACL holds the users. It is not safe for concurrent use, the command goroutine owns it.
+type ACL struct {
+	catalog *Catalog
+	users   map[string]*User
+	log     []*LogEntry // newest first
+	lastID  int64
+}
+
+// New creates an ACL with the default user only. With an empty password the default
+// user accepts any password, like when require-pass is not set.
+func New(catalog *Catalog, defaultPassword string) *ACL {
+	a := &ACL{catalog: catalog, users: make(map[string]*User)}
+	a.users[DefaultUser] = a.defaultUser(defaultPassword)
+	return a
+}
+
+func (a *ACL) defaultUser(password string) *User {
+	u := newUser(DefaultUser)
+	rules := []string{"on", "nopass", "allkeys", "allchannels", "+@all"}
+	if password != "" {
+		rules[1] = ">" + password
+	}
+	for _, rule := range rules {
+		_ = u.applyRule(rule, a.catalog)
+	}
+	return u
+}
+
+// User returns a user by name, nil if there is none
+func (a *ACL) User(name string) *User {
+	return a.users[name]
+}
+
+// Authenticate returns the user if it is enabled and password is one of its passwords
+func (a *ACL) Authenticate(name string, password []byte) (*User, bool) {
+	u, ok := a.users[name]
+	// the password is checked even for unknown users, so that the time taken does not tell them apart
+	if !ok {
+		newUser(name).checkPassword(password)
+		return nil, false
+	}
+	if !u.checkPassword(password) || !u.enabled {
+		return nil, false
+	}
+	return u, true
+}
+
+// SetUser creates or modifies a user by applying rules in order. Nothing changes
+// if one of them is invalid.
+func (a *ACL) SetUser(name string, rules []string) error {
+	u, ok := a.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if rule == "" {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': Syntax error", rule)
+		}
+		if err := u.applyRule(rule, a.catalog); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, capitalize(err.Error()))
+		}
+	}
+	a.users[name] = u
+	return nil
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// DelUser deletes a user, it returns false if there was none. The default user cannot be deleted.
+func (a *ACL) DelUser(name string) (bool, error) {
+	if name == DefaultUser {
+		return false, errors.New("The 'default' user cannot be removed")
+	}
+	if _, ok := a.users[name]; !ok {
+		return false, nil
+	}
+	delete(a.users, name)
+	return true, nil
+}
+
+// Users returns the users sorted by name
+func (a *ACL) Users() []*User {
+	users := make([]*User, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(x, y *User) int { return strings.Compare(x.name, y.name) })
+	return users
+}
+
+// Load replaces the users with the ones of the ACL file, each line of which is
+// "user <name> <rule> ...". The users are unchanged if the file has an error.
+func (a *ACL) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded := &ACL{catalog: a.catalog, users: make(map[string]*User)}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user keyword", path, lineNum)
+		}
+		if _, ok := loaded.users[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineNum, fields[1])
+		}
+		if err := loaded.SetUser(fields[1], fields[2:]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// like Redis, the default user is created if the file does not define it
+	if _, ok := loaded.users[DefaultUser]; !ok {
+		loaded.users[DefaultUser] = a.defaultUser("")
+	}
+	a.users = loaded.users
+	return nil
+}
+
+// Save writes the users to the ACL file, replacing it atomically
+func (a *ACL) Save(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*.acl")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+	w := bufio.NewWriter(file)
+	for _, u := range a.Users() {
+		_, _ = fmt.Fprintln(w, u.Describe())
+	}
+	if err = w.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,107 @@
+package acl
+
+import (
+	"strings"
+	"testing"
+)
+
+var testCatalog = NewCatalog(map[string][]string{
+	"get":     {"read", "string", "fast"},
+	"set":     {"write", "string", "slow"},
+	"publish": {"pubsub", "fast"},
+})
+
+func TestCheck(t *testing.T) {
+	a := New(testCatalog, "")
+	if err := a.SetUser("u", []string{"on", "nopass", "+@string", "-set", "%R~r:*", "%W~w:*", "&chan.*"}); err != nil {
+		t.Fatal(err)
+	}
+	u := a.User("u")
+	for _, tc := range []struct {
+		r      Request
+		reason string
+	}{
+		{Request{Command: "get", Keys: []string{"r:1"}, Read: true}, ""},
+		{Request{Command: "get", Keys: []string{"w:1"}, Read: true}, ReasonKey},
+		{Request{Command: "set", Keys: []string{"w:1"}, Write: true}, ReasonCommand},
+		{Request{Command: "publish", Channels: []string{"chan.1"}}, ReasonCommand},
+	} {
+		if reason, _ := u.Check(tc.r); reason != tc.reason {
+			t.Errorf("%+v: expected %q, got %q", tc.r, tc.reason, reason)
+		}
+	}
+
+	if err := a.SetUser("u", []string{"+publish"}); err != nil {
+		t.Fatal(err)
+	}
+	u = a.User("u")
+	if reason, _ := u.Check(Request{Command: "publish", Channels: []string{"chan.1"}}); reason != "" {
+		t.Errorf("expected a matching channel to be allowed, got %q", reason)
+	}
+	if reason, _ := u.Check(Request{Command: "publish", Channels: []string{"chan.*"}, Patterns: true}); reason != "" {
+		t.Errorf("expected the user's own pattern to be allowed, got %q", reason)
+	}
+	if reason, _ := u.Check(Request{Command: "publish", Channels: []string{"*"}, Patterns: true}); reason != ReasonChannel {
+		t.Errorf("expected a wider pattern to be denied, got %q", reason)
+	}
+	if want := "user u on nopass %R~r:* %W~w:* &chan.* -@all +@string -set +publish"; u.Describe() != want {
+		t.Errorf("expected %q, got %q", want, u.Describe())
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := New(testCatalog, "secret")
+	if _, ok := a.Authenticate(DefaultUser, []byte("wrong")); ok {
+		t.Error("expected a wrong password to be refused")
+	}
+	if _, ok := a.Authenticate(DefaultUser, []byte("secret")); !ok {
+		t.Error("expected the password to be accepted")
+	}
+	_ = a.SetUser("off", []string{"off", ">pw"})
+	if _, ok := a.Authenticate("off", []byte("pw")); ok {
+		t.Error("expected a disabled user to be refused")
+	}
+}
+
+func TestPasswordHashRules(t *testing.T) {
+	hash := hashPassword([]byte("pw"))
+	for _, tc := range []struct {
+		rule string
+		ok   bool
+	}{
+		{"#" + hash, true},
+		{"#" + strings.ToUpper(hash), false},
+		{"#" + hash[:63], false},
+		{"#" + hash + "0", false},
+		{"#" + hash[:63] + "g", false},
+		{"!" + strings.ToUpper(hash), false},
+	} {
+		if err := New(testCatalog, "").SetUser("u", []string{tc.rule}); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok %v, got %v", tc.rule, tc.ok, err)
+		}
+	}
+
+	a := New(testCatalog, "")
+	_ = a.SetUser("u", []string{"on", "#" + hash})
+	if _, ok := a.Authenticate("u", []byte("pw")); !ok {
+		t.Error("expected the password of the hash to be accepted")
+	}
+	if err := a.SetUser("u", []string{"!" + hash}); err != nil {
+		t.Errorf("expected the hash to be removed, got %v", err)
+	}
+}
+
+func TestLogGrouping(t *testing.T) {
+	a := New(testCatalog, "")
+	a.LogDenial(ReasonKey, "k", "u", "id=1")
+	a.LogDenial(ReasonKey, "k", "u", "id=2")
+	a.LogDenial(ReasonCommand, "set", "u", "id=1")
+	entries := a.Log(10)
+	if len(entries) != 2 || entries[0].Object != "set" || entries[1].Count != 2 || entries[1].ClientInfo != "id=2" {
+		t.Errorf("expected repeated denials to be grouped, got %+v", entries)
+	}
+	a.ResetLog()
+	if len(a.Log(10)) != 0 {
+		t.Error("expected the log to be empty after a reset")
+	}
+}
@@ -0,0 +1,69 @@
+package acl
+
+import "time"
+
+const (
+	// maxLogEntries bounds the ACL LOG, like acllog-max-len
+	maxLogEntries = 128
+	// logGrouping is how long a denial is counted with a similar one rather than logged apart
+	logGrouping = time.Minute
+)
+
+// Denial reasons, as reported by ACL LOG
+const (
+	ReasonCommand = "command"
+	ReasonKey     = "key"
+	ReasonChannel = "channel"
+	ReasonAuth    = "auth"
+)
+
+// LogEntry is a denied command or a failed authentication, with the count of similar ones
+type LogEntry struct {
+	ID         int64
+	Count      int
+	Reason     string
+	Object     string // the command, key or channel denied, AUTH for failed authentications
+	Username   string
+	ClientInfo string
+	Created    time.Time
+	Updated    time.Time
+}
+
+// LogDenial adds an entry to the ACL LOG, or counts it with a similar recent entry
+func (a *ACL) LogDenial(reason, object, username, clientInfo string) {
+	now := time.Now()
+	for _, e := range a.log {
+		if e.Reason == reason && e.Object == object && e.Username == username && now.Sub(e.Updated) < logGrouping {
+			e.Count++
+			e.Updated = now
+			e.ClientInfo = clientInfo
+			return
+		}
+	}
+	a.lastID++
+	entry := &LogEntry{
+		ID:         a.lastID,
+		Count:      1,
+		Reason:     reason,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		Created:    now,
+		Updated:    now,
+	}
+	a.log = append([]*LogEntry{entry}, a.log[:min(len(a.log), maxLogEntries-1)]...)
+}
+
+// Log returns up to count entries of the ACL LOG, newest first
+func (a *ACL) Log(count int) []LogEntry {
+	entries := make([]LogEntry, 0, min(count, len(a.log)))
+	for _, e := range a.log[:min(count, len(a.log))] {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// ResetLog clears the ACL LOG
+func (a *ACL) ResetLog() {
+	a.log = nil
+}
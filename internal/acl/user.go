@@ -0,0 +1,394 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/mirage208/redis-go/common/utils"
+)
+
+// User is a set of credentials and the permissions granted with them
+type User struct {
+	name      string
+	enabled   bool
+	noPass    bool     // any password is accepted
+	passwords []string // hex SHA-256 of the passwords
+
+	commands     map[string]bool // allowed commands
+	commandRules []string        // the command rules applied since the last +@all or -@all, for display
+
+	keys        []keyPattern
+	channels    []string // glob-style patterns of the allowed Pub/Sub channels
+	allChannels bool
+}
+
+// keyPattern grants read and/or write access to the keys matching pattern
+type keyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+// newUser returns a user which is disabled and has no permission, like ACL SETUSER creates
+func newUser(name string) *User {
+	return &User{name: name, commands: make(map[string]bool), commandRules: []string{"-@all"}}
+}
+
+func (u *User) Name() string {
+	return u.name
+}
+
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// NoPass reports whether the user accepts any password
+func (u *User) NoPass() bool {
+	return u.noPass
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commands = make(map[string]bool, len(u.commands))
+	for name := range u.commands {
+		c.commands[name] = true
+	}
+	c.commandRules = slices.Clone(u.commandRules)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+// hashPassword returns the representation of passwords in rules and in the ACL file
+func hashPassword(password []byte) string {
+	sum := sha256.Sum256(password)
+	return hex.EncodeToString(sum[:])
+}
+
+// validHash reports whether hash is a SHA-256 as hashPassword formats it, 64 lowercase
+// hexadecimal characters
+func validHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range []byte(hash) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPassword compares password with the passwords of the user in constant time
+func (u *User) checkPassword(password []byte) bool {
+	if u.noPass {
+		return true
+	}
+	given := []byte(hashPassword(password))
+	ok := false
+	for _, hash := range u.passwords {
+		if subtle.ConstantTimeCompare(given, []byte(hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// applyRule applies one rule of ACL SETUSER. catalog resolves the command names and categories.
+func (u *User) applyRule(rule string, catalog *Catalog) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.noPass, u.passwords = true, nil
+		return nil
+	case "resetpass":
+		u.noPass, u.passwords = false, nil
+		return nil
+	case "allkeys":
+		u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.allChannels, u.channels = true, nil
+		return nil
+	case "resetchannels":
+		u.allChannels, u.channels = false, nil
+		return nil
+	case "allcommands":
+		return u.applyRule("+@all", catalog)
+	case "nocommands":
+		return u.applyRule("-@all", catalog)
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			_ = u.applyRule(r, catalog)
+		}
+		return nil
+	}
+
+	switch {
+	case rule[0] == '>':
+		hash := hashPassword([]byte(rule[1:]))
+		if !slices.Contains(u.passwords, hash) {
+			u.passwords = append(u.passwords, hash)
+		}
+		u.noPass = false
+	case rule[0] == '<':
+		hash := hashPassword([]byte(rule[1:]))
+		if !slices.Contains(u.passwords, hash) {
+			return errors.New("no such password")
+		}
+		u.passwords = slices.DeleteFunc(u.passwords, func(p string) bool { return p == hash })
+	case rule[0] == '#' || rule[0] == '!':
+		hash := rule[1:]
+		if !validHash(hash) {
+			return errors.New("the password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		if rule[0] == '#' {
+			if !slices.Contains(u.passwords, hash) {
+				u.passwords = append(u.passwords, hash)
+			}
+			u.noPass = false
+		} else {
+			if !slices.Contains(u.passwords, hash) {
+				return errors.New("no such password")
+			}
+			u.passwords = slices.DeleteFunc(u.passwords, func(p string) bool { return p == hash })
+		}
+	case rule[0] == '~':
+		u.addKeyPattern(keyPattern{pattern: rule[1:], read: true, write: true})
+	case rule[0] == '%':
+		permissions, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok || permissions == "" {
+			return errors.New("syntax error")
+		}
+		p := keyPattern{pattern: pattern}
+		for _, c := range strings.ToUpper(permissions) {
+			switch c {
+			case 'R':
+				p.read = true
+			case 'W':
+				p.write = true
+			default:
+				return errors.New("syntax error")
+			}
+		}
+		u.addKeyPattern(p)
+	case rule[0] == '&':
+		if !u.allChannels && !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+		if rule[1:] == "*" {
+			u.allChannels, u.channels = true, nil
+		}
+	case rule[0] == '+' || rule[0] == '-':
+		return u.applyCommandRule(lower, catalog)
+	default:
+		return errors.New("syntax error")
+	}
+	return nil
+}
+
+func (u *User) addKeyPattern(p keyPattern) {
+	for i, existing := range u.keys {
+		if existing.pattern == p.pattern {
+			u.keys[i].read = existing.read || p.read
+			u.keys[i].write = existing.write || p.write
+			return
+		}
+	}
+	u.keys = append(u.keys, p)
+}
+
+// applyCommandRule applies +command, -command, +@category or -@category
+func (u *User) applyCommandRule(rule string, catalog *Catalog) error {
+	allow := rule[0] == '+'
+	name := rule[1:]
+	var commands []string
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if category == "all" {
+			commands = catalog.Commands()
+			// the rule overrides every command rule before it
+			u.commandRules = nil
+		} else {
+			var known bool
+			commands, known = catalog.CategoryCommands(category)
+			if !known {
+				return errors.New("unknown command category")
+			}
+		}
+	} else {
+		if strings.Contains(name, "|") {
+			return errors.New("subcommand rules are not supported")
+		}
+		if !catalog.Has(name) {
+			return errors.New("unknown command")
+		}
+		commands = []string{name}
+	}
+	for _, command := range commands {
+		if allow {
+			u.commands[command] = true
+		} else {
+			delete(u.commands, command)
+		}
+	}
+	u.commandRules = append(u.commandRules, rule)
+	return nil
+}
+
+// AllKeys reports whether the user may read and write every key, keys need not be checked then
+func (u *User) AllKeys() bool {
+	for _, p := range u.keys {
+		if p.pattern == "*" && p.read && p.write {
+			return true
+		}
+	}
+	return false
+}
+
+// AllChannels reports whether the user may access every channel
+func (u *User) AllChannels() bool {
+	return u.allChannels
+}
+
+// Request describes what a command accesses, for permission checks
+type Request struct {
+	Command     string
+	Keys        []string
+	Read, Write bool // how the command accesses the keys
+	Channels    []string
+	Patterns    bool // Channels are patterns, as for PSUBSCRIBE
+}
+
+// Check returns why the user may not run a request, as one of the Reason constants along
+// with the command, key or channel denied. reason is empty if the request is allowed.
+func (u *User) Check(r Request) (reason, object string) {
+	if !u.commands[r.Command] {
+		return ReasonCommand, r.Command
+	}
+	for _, key := range r.Keys {
+		if !u.checkKey(key, r.Read, r.Write) {
+			return ReasonKey, key
+		}
+	}
+	for _, channel := range r.Channels {
+		if !u.checkChannel(channel, r.Patterns) {
+			return ReasonChannel, channel
+		}
+	}
+	return "", ""
+}
+
+// checkKey reports whether the user may read and/or write key
+func (u *User) checkKey(key string, read, write bool) bool {
+	for _, p := range u.keys {
+		if (!read || p.read) && (!write || p.write) && (p.pattern == "*" || utils.GlobMatch(p.pattern, key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkChannel reports whether the user may publish or subscribe to channel. A pattern
+// subscription is only allowed if it is one of the patterns of the user.
+func (u *User) checkChannel(channel string, isPattern bool) bool {
+	if u.allChannels {
+		return true
+	}
+	for _, p := range u.channels {
+		if isPattern && p == channel || !isPattern && utils.GlobMatch(p, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// flags returns the flags listed by ACL GETUSER
+func (u *User) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.noPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// keysRule describes the key patterns as rules, e.g. "~cache:* %R~config:*"
+func (u *User) keysRule() string {
+	rules := make([]string, len(u.keys))
+	for i, p := range u.keys {
+		switch {
+		case p.read && p.write:
+			rules[i] = "~" + p.pattern
+		case p.read:
+			rules[i] = "%R~" + p.pattern
+		default:
+			rules[i] = "%W~" + p.pattern
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// channelsRule describes the channel patterns as rules, e.g. "&news.*"
+func (u *User) channelsRule() string {
+	if u.allChannels {
+		return "&*"
+	}
+	rules := make([]string, len(u.channels))
+	for i, p := range u.channels {
+		rules[i] = "&" + p
+	}
+	return strings.Join(rules, " ")
+}
+
+func (u *User) commandsRule() string {
+	return strings.Join(u.commandRules, " ")
+}
+
+// Rules are the rules of a user grouped as ACL GETUSER lists them
+type Rules struct {
+	Flags     []string
+	Passwords []string // hex SHA-256 of the passwords
+	Commands  string
+	Keys      string
+	Channels  string
+}
+
+func (u *User) Rules() Rules {
+	return Rules{
+		Flags:     u.flags(),
+		Passwords: slices.Clone(u.passwords),
+		Commands:  u.commandsRule(),
+		Keys:      u.keysRule(),
+		Channels:  u.channelsRule(),
+	}
+}
+
+// Describe returns the rules which recreate the user, as listed by ACL LIST and saved to the ACL file
+func (u *User) Describe() string {
+	parts := []string{"user", u.name}
+	parts = append(parts, u.flags()...)
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	for _, rule := range []string{u.keysRule(), u.channelsRule(), u.commandsRule()} {
+		if rule != "" {
+			parts = append(parts, rule)
+		}
+	}
+	return strings.Join(parts, " ")
+}
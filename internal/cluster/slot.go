@@ -0,0 +1,36 @@
+// Package cluster holds what commands need to know about hash slots, which distribute
+// keys and shard channels among the nodes of a cluster
+package cluster
+
+import "strings"
+
+// SlotCount is the number of hash slots
+const SlotCount = 16384
+
+// KeySlot returns the hash slot of a key or shard channel. When the key holds a
+// non-empty hash tag between the first { and the next }, only the tag is hashed so
+// that related keys can be kept in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+// crc16 is CRC-16/XMODEM, polynomial 0x1021 with a zero initial value
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,27 @@
+package cluster
+
+import "testing"
+
+func TestKeySlot(t *testing.T) {
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("expected the XMODEM check value 0x31c3, got %#x", got)
+	}
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"{user1000}.following", KeySlot("user1000")},
+		{"foo{}{bar}", KeySlot("foo{}{bar}")},
+		{"foo{{bar}}", KeySlot("{bar")},
+		{"foo{bar}{zap}", KeySlot("bar")},
+	}
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.slot {
+			t.Errorf("KeySlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+	if KeySlot("foo{}{bar}") != int(crc16("foo{}{bar}")%SlotCount) {
+		t.Error("expected an empty hash tag to hash the whole key")
+	}
+}
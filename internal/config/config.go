@@ -2,18 +2,24 @@ package config
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mirage208/redis-go/common/utils"
+	"github.com/mirage208/redis-go/internal/resp"
 	"github.com/mirage208/redis-go/pkg/logger"
 )
 
+// RedisVersion is the Redis version this server is compatible with
+const RedisVersion = "7.0.0"
+
 // ServerProperties defines global config properties
 type ServerProperties struct {
 	// for Public configuration
@@ -26,16 +32,61 @@ type ServerProperties struct {
 	AppendFilename    string `cfg:"append-filename"`
 	AppendFsync       string `cfg:"append-fsync"`
 	AofUseRdbPreamble bool   `cfg:"aof-use-rdb-preamble"`
-	MaxClients        int    `cfg:"max-clients"`
+	MaxClients        int    `cfg:"max-clients"`      // connections beyond it are refused, unlimited if 0
+	Timeout           int    `cfg:"timeout"`          // seconds before an idle client is closed, never if 0
+	TCPKeepalive      int    `cfg:"tcp-keepalive"`    // seconds between keepalive probes, the system default if 0
+	ShutdownTimeout   int    `cfg:"shutdown-timeout"` // seconds shutting down may take, 10 if 0
 	RequirePass       string `cfg:"require-pass"`
+	AclFile           string `cfg:"aclfile"`
 	Databases         int    `cfg:"databases"`
 	RDBFilename       string `cfg:"db-filename"`
-	ReplTimeout       int    `cfg:"repl-timeout"`
+	Save              string `cfg:"save"` // pairs of <seconds> <changes>, e.g. "3600 1 300 100"
+	// StopWritesOnBgsaveError is yes by default: writes are refused while the last save failed
+	StopWritesOnBgsaveError string `cfg:"stop-writes-on-bgsave-error"`
+	ProtoMaxBulkLen         int    `cfg:"proto-max-bulk-len"` // bytes, memory units such as 512mb are accepted
+
+	// ReplicaOf is "<host> <port>" of the master this server replicates, it is a master if empty
+	ReplicaOf       string `cfg:"replicaof"`
+	MasterUser      string `cfg:"masteruser"`        // ACL user the replica authenticates as, with MasterAuth
+	MasterAuth      string `cfg:"masterauth"`        // password the replica authenticates with
+	ReplTimeout     int    `cfg:"repl-timeout"`      // seconds before a silent replication link is dropped, 60 if 0
+	ReplBacklogSize int    `cfg:"repl-backlog-size"` // bytes of the stream kept for partial resync, 1mb if 0
+
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"` // event classes published to Pub/Sub, e.g. "Ex"
+
+	// ClientOutputBufferLimit holds <class> <hard> <soft> <soft seconds> groups, e.g. "pubsub 32mb 8mb 60"
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+
+	// UnixSocket is the path of a Unix socket to listen on, its permissions are the octal UnixSocketPerm
+	UnixSocket     string `cfg:"unixsocket"`
+	UnixSocketPerm string `cfg:"unixsocketperm"`
+
+	// TLS is served on TLSPort, along with plain TCP on Port unless Port is 0
+	TLSPort        int    `cfg:"tls-port"`
+	TLSCertFile    string `cfg:"tls-cert-file"`
+	TLSKeyFile     string `cfg:"tls-key-file"`
+	TLSCACertFile  string `cfg:"tls-ca-cert-file"` // CA verifying client certificates
+	TLSAuthClients string `cfg:"tls-auth-clients"` // yes, no or optional, yes by default when a CA is set
 
 	// config file path
 	CfPath string `cfg:"cf,omitempty"`
 }
 
+// SaveParam is a snapshot rule: save if at least Changes keys changed within Seconds
+type SaveParam struct {
+	Seconds int
+	Changes int
+}
+
+// OutputBufferLimit is a class of client-output-buffer-limit: a client of the class is
+// disconnected once its pending output exceeds Hard bytes, or Soft bytes for longer
+// than SoftSeconds. A zero limit is disabled.
+type OutputBufferLimit struct {
+	Class       string // normal, replica or pubsub
+	Hard, Soft  int64
+	SoftSeconds int
+}
+
 type ServerInfo struct {
 	StartUpTime time.Time
 }
@@ -106,7 +157,7 @@ func parse(src io.Reader) *ServerProperties {
 				case reflect.String:
 					fieldVal.SetString(value)
 				case reflect.Int:
-					intValue, err := strconv.ParseInt(value, 10, 64)
+					intValue, err := parseMemory(value)
 					if err == nil {
 						fieldVal.SetInt(intValue)
 					}
@@ -127,6 +178,25 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// parseMemory parses an integer with an optional memory unit: 1k is 1000 bytes, 1kb is 1024 bytes and so on
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		bytes  int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	}
+	lower := strings.ToLower(value)
+	for _, unit := range units {
+		if digits, ok := strings.CutSuffix(lower, unit.suffix); ok {
+			n, err := strconv.ParseInt(digits, 10, 64)
+			return n * unit.bytes, err
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
@@ -150,6 +220,105 @@ func SetupConfig(configFilename string) {
 		Properties.Dir = "."
 	}
 }
+
+// UnixSocketMode parses unixsocketperm, which is octal like 700. It is 0 if unset.
+func (p *ServerProperties) UnixSocketMode() (os.FileMode, error) {
+	if p.UnixSocketPerm == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(p.UnixSocketPerm, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, fmt.Errorf("invalid unixsocketperm: %s", p.UnixSocketPerm)
+	}
+	return os.FileMode(perm), nil
+}
+
+// RDBPath returns the location of the snapshot file, defaults to dump.rdb under Dir
+func (p *ServerProperties) RDBPath() string {
+	filename := p.RDBFilename
+	if filename == "" {
+		filename = "dump.rdb"
+	}
+	dir := p.Dir
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, filename)
+}
+
+// AOFPath returns the location of the append only file, defaults to appendonly.aof under Dir
+func (p *ServerProperties) AOFPath() string {
+	filename := p.AppendFilename
+	if filename == "" {
+		filename = "appendonly.aof"
+	}
+	dir := p.Dir
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Join(dir, filename)
+}
+
+// ProtoLimits returns the limits of the RESP parser for client requests, which also apply
+// to the AOF and the replication stream that replay them. proto-max-bulk-len overrides
+// the default.
+func (p *ServerProperties) ProtoLimits() resp.Limits {
+	limits := resp.DefaultLimits
+	if p.ProtoMaxBulkLen > 0 {
+		limits.MaxBulkLen = int64(p.ProtoMaxBulkLen)
+	}
+	return limits
+}
+
+// StopWritesOnBgsaveErr reports whether stop-writes-on-bgsave-error is enabled, it is unless set to no
+func (p *ServerProperties) StopWritesOnBgsaveErr() bool {
+	return !strings.EqualFold(p.StopWritesOnBgsaveError, "no")
+}
+
+// SaveParams parses the save rules, invalid pairs are ignored and an empty value disables snapshotting
+func (p *ServerProperties) SaveParams() []SaveParam {
+	fields := strings.Fields(strings.Trim(p.Save, "\""))
+	params := make([]SaveParam, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			logger.Warnf("invalid save parameters: %s %s", fields[i], fields[i+1])
+			continue
+		}
+		params = append(params, SaveParam{Seconds: seconds, Changes: changes})
+	}
+	return params
+}
+
+// OutputBufferLimits returns the limits of the normal, replica and pubsub classes in this
+// order. Like Redis, only replica and pubsub clients are limited by default. Invalid
+// groups are ignored, slave is accepted for replica.
+func (p *ServerProperties) OutputBufferLimits() []OutputBufferLimit {
+	limits := []OutputBufferLimit{
+		{Class: "normal"},
+		{Class: "replica", Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60},
+		{Class: "pubsub", Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60},
+	}
+	fields := strings.Fields(strings.Trim(p.ClientOutputBufferLimit, "\""))
+	for i := 0; i+3 < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		hard, err1 := parseMemory(fields[i+1])
+		soft, err2 := parseMemory(fields[i+2])
+		seconds, err3 := strconv.Atoi(fields[i+3])
+		index := slices.IndexFunc(limits, func(limit OutputBufferLimit) bool { return limit.Class == class })
+		if index < 0 || err1 != nil || err2 != nil || err3 != nil || hard < 0 || soft < 0 || seconds < 0 {
+			logger.Warnf("invalid client-output-buffer-limit: %s", strings.Join(fields[i:i+4], " "))
+			continue
+		}
+		limits[index] = OutputBufferLimit{Class: class, Hard: hard, Soft: soft, SoftSeconds: seconds}
+	}
+	return limits
+}
+
 func GetTmpDir() string {
 	return Properties.Dir + "/tmp"
 }
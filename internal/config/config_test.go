@@ -3,6 +3,8 @@ package config
 import (
 	"strings"
 	"testing"
+
+	"github.com/mirage208/redis-go/internal/resp"
 )
 
 func TestParse(t *testing.T) {
@@ -24,3 +26,58 @@ func TestParse(t *testing.T) {
 		t.Error("bool parse failed")
 	}
 }
+
+func TestParseMemory(t *testing.T) {
+	p := parse(strings.NewReader("proto-max-bulk-len 2mb\nmax-clients 10k"))
+	if p.ProtoMaxBulkLen != 2<<20 {
+		t.Errorf("expected 2mb, got %d", p.ProtoMaxBulkLen)
+	}
+	if limits := p.ProtoLimits(); limits.MaxBulkLen != 2<<20 || limits.MaxMultiBulkLen != resp.DefaultLimits.MaxMultiBulkLen {
+		t.Errorf("expected proto-max-bulk-len in the parser limits, got %+v", limits)
+	}
+	if p.MaxClients != 10000 {
+		t.Errorf("expected 10k, got %d", p.MaxClients)
+	}
+}
+
+func TestSaveParams(t *testing.T) {
+	p := parse(strings.NewReader("save 3600 1 300 100 60 10000"))
+	params := p.SaveParams()
+	if len(params) != 3 {
+		t.Fatalf("expected 3 save params, got %d", len(params))
+	}
+	if params[1].Seconds != 300 || params[1].Changes != 100 {
+		t.Errorf("unexpected save param %+v", params[1])
+	}
+
+	p = parse(strings.NewReader("save \"\""))
+	if len(p.SaveParams()) != 0 {
+		t.Errorf("empty save should disable snapshotting")
+	}
+}
+
+func TestUnixSocketMode(t *testing.T) {
+	p := parse(strings.NewReader("unixsocket /tmp/redis.sock\nunixsocketperm 770"))
+	if mode, err := p.UnixSocketMode(); err != nil || mode != 0o770 {
+		t.Errorf("expected 0770, got %o %v", mode, err)
+	}
+	p.UnixSocketPerm = "999"
+	if _, err := p.UnixSocketMode(); err == nil {
+		t.Error("expected a non octal permission to be refused")
+	}
+}
+
+func TestOutputBufferLimits(t *testing.T) {
+	p := parse(strings.NewReader("client-output-buffer-limit normal 1mb 512kb 10 slave 0 0 0 bogus 1 1 1"))
+	limits := p.OutputBufferLimits()
+	want := []OutputBufferLimit{
+		{Class: "normal", Hard: 1 << 20, Soft: 512 << 10, SoftSeconds: 10},
+		{Class: "replica"},
+		{Class: "pubsub", Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60},
+	}
+	for i := range want {
+		if limits[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], limits[i])
+		}
+	}
+}
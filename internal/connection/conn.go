@@ -1,22 +1,101 @@
 package connection
 
 import (
+	"bufio"
+	"crypto/tls"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mirage208/redis-go/internal/resp"
 	"github.com/mirage208/redis-go/pkg/logger"
 	"github.com/mirage208/redis-go/pkg/sync/wait"
 )
 
+// outputBufferSize is the size of the buffer which coalesces the replies to pipelined requests
+const outputBufferSize = 16 << 10
+
 type Connection struct {
 	// TODO
 	conn net.Conn
 
 	// wait until finish sending data, used for graceful shutdown
 	sendingData wait.Wait
+
+	mu     sync.Mutex // guards writer
+	writer *bufio.Writer
+
+	id       uint64
+	created  time.Time
+	protocol atomic.Int32 // RESP version negotiated with HELLO
+	name     string       // set by HELLO SETNAME or CLIENT SETNAME, only accessed by the command goroutine
+
+	// the last command and when it was executed, only accessed by the command goroutine
+	lastCmd    string
+	lastActive time.Time
+
+	// set by CLIENT NO-EVICT and CLIENT REPLY, only accessed by the command goroutine
+	noEvict     bool
+	repliesOff  bool
+	skipReplies int
+
+	// replica is set once the client asked for the replication stream with PSYNC, only accessed by the command goroutine
+	replica bool
+
+	// closeAfterReply is set when the connection is closed once the pending replies are sent
+	closeAfterReply atomic.Bool
+
+	// authenticated is set once the client passed AUTH, or from the start when no password is required
+	authenticated atomic.Bool
+	user          string // ACL user set by AUTH, the default user if empty. Only accessed by the command goroutine.
+
+	// Pub/Sub subscriptions by kind, only accessed by the command goroutine
+	subs [subKinds]map[string]struct{}
+
+	// replies pushed by the command goroutine, such as Pub/Sub messages, which
+	// the goroutine serving the connection writes out
+	pushMu      sync.Mutex
+	pushed      []pushedReply
+	pushedBytes int64
+	pushReady   chan struct{}
+	keepalive   atomic.Bool // a newline is to be written before the pushed replies
+
+	// limits bound pushedBytes, set and read by the command goroutine
+	limits    *OutputLimits
+	softSince time.Time // when the soft limit was first exceeded
+	// overflowed is set once the client exceeded its output limit and was disconnected
+	overflowed atomic.Bool
+}
+
+// pushedReply is a pushed reply with the size it counts for in the output limits
+type pushedReply struct {
+	reply resp.Reply
+	size  int64
+}
+
+// Deferred is a pushed reply which may not be ready to be written yet, such as the
+// snapshot of a full resynchronization while it is being prepared. It holds back the
+// replies pushed after it until it is ready and Wake is called.
+type Deferred interface {
+	resp.Reply
+	Ready() bool
 }
 
+// SubKind is a namespace of Pub/Sub subscriptions
+type SubKind int
+
+const (
+	SubChannel SubKind = iota
+	SubPattern
+	SubShard // shard channels, see SSUBSCRIBE
+	subKinds
+)
+
+// nextID is the ID of the last connection, IDs are never reused
+var nextID atomic.Uint64
+
 var connPool = sync.Pool{
 	New: func() any {
 		return &Connection{}
@@ -29,15 +108,352 @@ func NewConn(conn net.Conn) *Connection {
 	c, ok := connPool.Get().(*Connection)
 	if !ok {
 		logger.Error("connection pool make wrong type")
-		return &Connection{
-			conn: conn,
-		}
+		c = &Connection{}
 	}
 	c.conn = conn
+	if c.writer == nil {
+		c.writer = bufio.NewWriterSize(conn, outputBufferSize)
+	} else {
+		c.writer.Reset(conn)
+	}
+	c.id = nextID.Add(1)
+	c.created = time.Now()
+	c.protocol.Store(resp.RESP2)
+	c.name = ""
+	c.lastCmd = ""
+	c.lastActive = c.created
+	c.noEvict = false
+	c.repliesOff = false
+	c.skipReplies = 0
+	c.replica = false
+	c.closeAfterReply.Store(false)
+	c.authenticated.Store(false)
+	c.user = ""
+	c.subs = [subKinds]map[string]struct{}{}
+	c.pushed = nil
+	c.pushedBytes = 0
+	c.pushReady = make(chan struct{}, 1)
+	c.keepalive.Store(false)
+	c.limits = nil
+	c.softSince = time.Time{}
+	c.overflowed.Store(false)
 	return c
 }
 
-// Write sends response to client over tcp client
+// ID returns the unique ID of the connection
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// Age returns how long the client has been connected
+func (c *Connection) Age() time.Duration {
+	return time.Since(c.created)
+}
+
+// LastCommand returns the name of the last command executed, empty if there was none
+func (c *Connection) LastCommand() string {
+	return c.lastCmd
+}
+
+// IdleTime returns how long ago the last command was executed, or the client connected
+func (c *Connection) IdleTime() time.Duration {
+	return time.Since(c.lastActive)
+}
+
+// CommandExecuted records the last command of the client
+func (c *Connection) CommandExecuted(name string) {
+	c.lastCmd = name
+	c.lastActive = time.Now()
+}
+
+// Protocol returns the RESP version of the connection, resp.RESP2 unless changed with HELLO
+func (c *Connection) Protocol() int {
+	return int(c.protocol.Load())
+}
+
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol.Store(int32(protocol))
+}
+
+// Authenticated reports whether the client may run commands which require authentication
+func (c *Connection) Authenticated() bool {
+	return c.authenticated.Load()
+}
+
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated.Store(authenticated)
+}
+
+// User returns the name of the ACL user the client authenticated as
+func (c *Connection) User() string {
+	if c.user == "" {
+		return "default"
+	}
+	return c.user
+}
+
+func (c *Connection) SetUser(user string) {
+	c.user = user
+}
+
+// ClientName returns the name given with HELLO SETNAME or CLIENT SETNAME
+func (c *Connection) ClientName() string {
+	return c.name
+}
+
+func (c *Connection) SetClientName(name string) {
+	c.name = name
+}
+
+// NoEvict reports whether the client is excluded from client eviction, see CLIENT NO-EVICT
+func (c *Connection) NoEvict() bool {
+	return c.noEvict
+}
+
+func (c *Connection) SetNoEvict(noEvict bool) {
+	c.noEvict = noEvict
+}
+
+// ReplyMode is set by CLIENT REPLY
+type ReplyMode int
+
+const (
+	ReplyOn ReplyMode = iota
+	ReplyOff
+	ReplySkip // skip the reply to the next command
+)
+
+// SetReplyMode applies CLIENT REPLY, which replies unless mode is ReplyOn
+func (c *Connection) SetReplyMode(mode ReplyMode) {
+	c.repliesOff = mode == ReplyOff
+	c.skipReplies = 0
+	if mode == ReplySkip {
+		// CLIENT REPLY SKIP itself and the command after it
+		c.skipReplies = 2
+	}
+}
+
+// Replying is called once a command is executed, it reports whether its reply is sent
+func (c *Connection) Replying() bool {
+	if c.skipReplies > 0 {
+		c.skipReplies--
+		return false
+	}
+	return !c.repliesOff
+}
+
+// Replica reports whether the client is a replica, which receives the replication stream
+func (c *Connection) Replica() bool {
+	return c.replica
+}
+
+func (c *Connection) SetReplica() {
+	c.replica = true
+}
+
+// CloseAfterReply has the connection closed once the replies to the commands executed so far are sent
+func (c *Connection) CloseAfterReply() {
+	c.closeAfterReply.Store(true)
+}
+
+// ClosingAfterReply reports whether CloseAfterReply was called
+func (c *Connection) ClosingAfterReply() bool {
+	return c.closeAfterReply.Load()
+}
+
+// Subscribe records a subscription, it returns false if it already existed
+func (c *Connection) Subscribe(kind SubKind, name string) bool {
+	if _, ok := c.subs[kind][name]; ok {
+		return false
+	}
+	if c.subs[kind] == nil {
+		c.subs[kind] = make(map[string]struct{})
+	}
+	c.subs[kind][name] = struct{}{}
+	return true
+}
+
+// Unsubscribe removes a subscription, it returns false if there was none
+func (c *Connection) Unsubscribe(kind SubKind, name string) bool {
+	if _, ok := c.subs[kind][name]; !ok {
+		return false
+	}
+	delete(c.subs[kind], name)
+	return true
+}
+
+// HasSubscription reports whether name is subscribed to in a namespace
+func (c *Connection) HasSubscription(kind SubKind, name string) bool {
+	_, ok := c.subs[kind][name]
+	return ok
+}
+
+// Subscriptions returns the names subscribed to in a namespace
+func (c *Connection) Subscriptions(kind SubKind) []string {
+	names := make([]string, 0, len(c.subs[kind]))
+	for name := range c.subs[kind] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// SubscriptionCount returns the number of subscriptions in the given namespaces
+func (c *Connection) SubscriptionCount(kinds ...SubKind) int {
+	n := 0
+	for _, kind := range kinds {
+		n += len(c.subs[kind])
+	}
+	return n
+}
+
+// Subscribed reports whether the connection has any subscription, RESP2 connections
+// are then restricted to the Pub/Sub commands
+func (c *Connection) Subscribed() bool {
+	for _, subs := range c.subs {
+		if len(subs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Push queues a reply which is not the reply to a request, such as a Pub/Sub message.
+// It never blocks: the goroutine serving the connection is woken up to write it. A
+// client whose queue exceeds its output limit is disconnected.
+func (c *Connection) Push(r resp.Reply) {
+	if c.overflowed.Load() {
+		return
+	}
+	r = resp.ForProtocol(r, c.Protocol())
+	c.PushSized(r, resp.EncodedSize(r, c.Protocol()))
+}
+
+// PushSized is Push for a reply already converted to the protocol of the connection, whose
+// size once encoded is known, so that a message pushed to many clients is only converted
+// and measured once
+func (c *Connection) PushSized(r resp.Reply, size int64) {
+	if c.overflowed.Load() {
+		return
+	}
+	c.pushMu.Lock()
+	c.pushed = append(c.pushed, pushedReply{reply: r, size: size})
+	c.pushedBytes += size
+	c.pushMu.Unlock()
+	if c.CheckOutputLimits(time.Now()) {
+		return
+	}
+	c.Wake()
+}
+
+// CheckOutputLimits disconnects the client if the pushed replies it did not read exceed
+// its output limit, and reports whether it did. Push checks the limit as replies are
+// added. The command goroutine also calls it periodically, so that a client stuck over
+// its soft limit is disconnected once the soft duration elapsed even though nothing more
+// is pushed, and one whose output drained below the soft limit starts counting anew.
+func (c *Connection) CheckOutputLimits(now time.Time) bool {
+	if c.limits == nil || c.overflowed.Load() {
+		return false
+	}
+	_, pending := c.PendingOutput()
+	if !c.limits.Classes[c.Class()].exceeded(pending, &c.softSince, now) {
+		return false
+	}
+	c.overflowed.Store(true)
+	c.limits.disconnections.Add(1)
+	// the client is going away, what it did not read is dropped
+	c.pushMu.Lock()
+	c.pushed = nil
+	c.pushedBytes = 0
+	c.pushMu.Unlock()
+	logger.Warnf("client %s closed for overcoming of output buffer limits", c.RemoteAddr())
+	_ = c.Disconnect()
+	return true
+}
+
+// Wake wakes up the goroutine serving the connection to write the pushed replies, which
+// is needed once a Deferred reply became ready
+func (c *Connection) Wake() {
+	select {
+	case c.pushReady <- struct{}{}:
+	default:
+	}
+}
+
+// Keepalive asks the goroutine serving the connection to write a newline ahead of the
+// pushed replies, even if a Deferred reply holds them back. A replica waiting for the
+// snapshot of its full resync reads it as a sign of life, like from Redis.
+func (c *Connection) Keepalive() {
+	c.keepalive.Store(true)
+	c.Wake()
+}
+
+// TakeKeepalive reports whether a newline was requested by Keepalive since the last call
+func (c *Connection) TakeKeepalive() bool {
+	return c.keepalive.Swap(false)
+}
+
+// SetOutputLimits sets the limits applied by Push
+func (c *Connection) SetOutputLimits(limits *OutputLimits) {
+	c.limits = limits
+}
+
+// Class returns the class of output limits which applies to the client
+func (c *Connection) Class() ClientClass {
+	if c.replica {
+		return ClassReplica
+	}
+	if c.Subscribed() {
+		return ClassPubSub
+	}
+	return ClassNormal
+}
+
+// PendingOutput returns the number and size of the pushed replies not written yet
+func (c *Connection) PendingOutput() (replies int, bytes int64) {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	return len(c.pushed), c.pushedBytes
+}
+
+// Overflowed reports whether the client was disconnected for exceeding its output limit
+func (c *Connection) Overflowed() bool {
+	return c.overflowed.Load()
+}
+
+// PushReady is signalled when replies were pushed
+func (c *Connection) PushReady() <-chan struct{} {
+	return c.pushReady
+}
+
+// TakePushed returns the pushed replies ready to be written and forgets them, it stops
+// at a Deferred reply which is not ready
+func (c *Connection) TakePushed() []resp.Reply {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	n := len(c.pushed)
+	for i, p := range c.pushed {
+		if d, ok := p.reply.(Deferred); ok && !d.Ready() {
+			n = i
+			break
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	taken := make([]resp.Reply, n)
+	for i, p := range c.pushed[:n] {
+		taken[i] = p.reply
+		c.pushedBytes -= p.size
+	}
+	if n == len(c.pushed) {
+		c.pushed = nil
+	} else {
+		c.pushed = slices.Clone(c.pushed[n:])
+	}
+	return taken
+}
+
+// Write sends response to client over tcp client, after the replies still buffered
 func (c *Connection) Write(b []byte) (int, error) {
 	if len(b) == 0 {
 		return 0, nil
@@ -47,10 +463,65 @@ func (c *Connection) Write(b []byte) (int, error) {
 		c.sendingData.Done()
 	}()
 
-	return c.conn.Write(b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writer.Flush()
 }
 
-// Close disconnect with the client
+// WriteReply buffers a reply, it is sent by the next Flush or as soon as the buffer is
+// full. The reply is written as it is: replies are converted to the protocol the client
+// had when they were produced, see resp.ForProtocol.
+func (c *Connection) WriteReply(r resp.Reply) error {
+	c.sendingData.Add(1)
+	defer c.sendingData.Done()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := r.WriteTo(c.writer)
+	return err
+}
+
+// Flush sends the buffered replies
+func (c *Connection) Flush() error {
+	c.sendingData.Add(1)
+	defer c.sendingData.Done()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writer.Flush()
+}
+
+// SetKeepAlive sends TCP keepalive probes every period, connections which are not TCP,
+// such as Unix sockets, are left as they are
+func (c *Connection) SetKeepAlive(period time.Duration) error {
+	conn := c.conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tcpConn.SetKeepAlivePeriod(period)
+}
+
+// Disconnect closes the network connection from another goroutine, the goroutine serving
+// the connection then fails to read and releases it with Close
+func (c *Connection) Disconnect() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Close disconnect with the client and releases the Connection, which must not be used afterwards
 func (c *Connection) Close() error {
 	c.sendingData.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
@@ -61,9 +532,25 @@ func (c *Connection) Close() error {
 
 // RemoteAddr returns the remote network address
 func (c *Connection) RemoteAddr() string {
+	if c.conn == nil {
+		return ""
+	}
 	return c.conn.RemoteAddr().String()
 }
 
+// LocalAddr returns the address the client connected to
+func (c *Connection) LocalAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.LocalAddr().String()
+}
+
+// UnixSocket reports whether the client connected through a Unix socket
+func (c *Connection) UnixSocket() bool {
+	return c.conn != nil && c.conn.LocalAddr().Network() == "unix"
+}
+
 func (c *Connection) Name() string {
 	if c.conn != nil {
 		return c.conn.RemoteAddr().String()
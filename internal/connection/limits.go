@@ -0,0 +1,52 @@
+package connection
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ClientClass selects the output buffer limit which applies to a client
+type ClientClass int
+
+const (
+	ClassNormal ClientClass = iota
+	ClassReplica
+	ClassPubSub
+	classCount
+)
+
+// OutputLimit bounds the replies pushed to a client and not written yet. The client is
+// disconnected over Hard bytes, or over Soft bytes for longer than SoftDuration.
+// A zero limit is disabled.
+type OutputLimit struct {
+	Hard, Soft   int64
+	SoftDuration time.Duration
+}
+
+// OutputLimits are the limits of each class, shared by the clients of a server
+type OutputLimits struct {
+	Classes [classCount]OutputLimit
+
+	disconnections atomic.Int64
+}
+
+// Disconnections returns how many clients were disconnected for exceeding their limit
+func (l *OutputLimits) Disconnections() int64 {
+	return l.disconnections.Load()
+}
+
+// exceeded reports whether pending bytes exceed limit, softSince is when the soft limit
+// was first exceeded and is updated for the next call
+func (limit *OutputLimit) exceeded(pending int64, softSince *time.Time, now time.Time) bool {
+	if limit.Hard > 0 && pending >= limit.Hard {
+		return true
+	}
+	if limit.Soft == 0 || pending < limit.Soft {
+		*softSince = time.Time{}
+		return false
+	}
+	if softSince.IsZero() {
+		*softSince = now
+	}
+	return now.Sub(*softSince) > limit.SoftDuration
+}
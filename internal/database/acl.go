@@ -0,0 +1,256 @@
+package database
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/acl"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+// loadACLFile loads the users of the aclfile, a missing file leaves the default user only
+func (db *SequentialDB) loadACLFile() {
+	if db.cfg.AclFile == "" {
+		return
+	}
+	err := db.acl.Load(db.cfg.AclFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warnf("ACL file %s does not exist, starting with the default user only", db.cfg.AclFile)
+		return
+	}
+	if err != nil {
+		// serving with the default user could grant more than the file does
+		logger.Fatal("failed to load the ACL file: " + err.Error())
+	}
+}
+
+// aclRequest returns what a command accesses, keys and channels are left out when user may access them all
+func aclRequest(user *acl.User, command *Command, args [][]byte) acl.Request {
+	r := acl.Request{
+		Command: strings.ToLower(command.name),
+		Read:    command.flags&flagReadonly != 0,
+		Write:   command.flags&flagWrite != 0,
+	}
+	if !user.AllKeys() {
+		r.Keys = command.keys.extract(args)
+	}
+	if !user.AllChannels() {
+		r.Channels, r.Patterns = channelsOf(r.Command, args)
+	}
+	return r
+}
+
+// channelsOf returns the channels a Pub/Sub command publishes or subscribes to
+func channelsOf(name string, args [][]byte) (channels []string, patterns bool) {
+	switch name {
+	case "publish", "spublish":
+		if len(args) > 0 {
+			return []string{string(args[0])}, false
+		}
+	case "subscribe", "ssubscribe":
+		return names(args), false
+	case "psubscribe":
+		return names(args), true
+	}
+	return nil, false
+}
+
+// denialMessage is the error replied when a user may not run a command
+func denialMessage(username, reason, object string) string {
+	switch reason {
+	case acl.ReasonKey:
+		return "No permissions to access a key"
+	case acl.ReasonChannel:
+		return "No permissions to access a channel"
+	}
+	return "User " + username + " has no permissions to run the '" + object + "' command"
+}
+
+// checkPermissions replies NOPERM if the user of client may not run the command, and logs it in the ACL LOG
+func (db *SequentialDB) checkPermissions(client *connection.Connection, command *Command, args [][]byte) resp.Reply {
+	username := client.User()
+	user := db.acl.User(username)
+	if user == nil {
+		// the user was deleted, its clients are being disconnected
+		return resp.MakeErrorReply("NOPERM " + denialMessage(username, acl.ReasonCommand, command.name))
+	}
+	reason, object := user.Check(aclRequest(user, command, args))
+	if reason == "" {
+		return nil
+	}
+	db.acl.LogDenial(reason, object, username, clientInfo(client))
+	return resp.MakeErrorReply("NOPERM " + denialMessage(username, reason, object))
+}
+
+// clientInfo describes a client in the ACL LOG
+func clientInfo(client *connection.Connection) string {
+	return "id=" + strconv.FormatUint(client.ID(), 10) + " addr=" + client.Name() +
+		" name=" + client.ClientName() + " user=" + client.User()
+}
+
+// aclExecuter implements the ACL subcommands
+func aclExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'acl' command")
+	}
+	subcommand := strings.ToLower(string(args[0]))
+	switch {
+	case subcommand == "setuser" && len(args) >= 2:
+		if err := db.acl.SetUser(string(args[1]), names(args[2:])); err != nil {
+			return resp.MakeErrorReply("ERR " + err.Error())
+		}
+		db.usersChanged()
+		return resp.MakeOkReply()
+	case subcommand == "getuser" && len(args) == 2:
+		return getUser(db.acl.User(string(args[1])))
+	case subcommand == "deluser" && len(args) >= 2:
+		return db.delUsers(names(args[1:]))
+	case (subcommand == "list" || subcommand == "users") && len(args) == 1:
+		var lines []string
+		for _, user := range db.acl.Users() {
+			if subcommand == "list" {
+				lines = append(lines, user.Describe())
+			} else {
+				lines = append(lines, user.Name())
+			}
+		}
+		return stringsReply(lines)
+	case subcommand == "whoami" && len(args) == 1:
+		if client == nil {
+			return resp.MakeBulkReply([]byte(acl.DefaultUser))
+		}
+		return resp.MakeBulkReply([]byte(client.User()))
+	case subcommand == "cat" && len(args) <= 2:
+		if len(args) == 1 {
+			return stringsReply(acl.Categories)
+		}
+		commands, known := aclCatalog().CategoryCommands(strings.ToLower(string(args[1])))
+		if !known {
+			return resp.MakeErrorReply("ERR Unknown category '" + string(args[1]) + "'")
+		}
+		return stringsReply(commands)
+	case subcommand == "dryrun" && len(args) >= 3:
+		return db.dryRun(string(args[1]), args[2:])
+	case subcommand == "log" && len(args) <= 2:
+		return db.aclLog(args[1:])
+	case subcommand == "load" && len(args) == 1:
+		if db.cfg.AclFile == "" {
+			return resp.MakeErrorReply("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := db.acl.Load(db.cfg.AclFile); err != nil {
+			return resp.MakeErrorReply("ERR " + err.Error())
+		}
+		db.usersChanged()
+		db.disconnectDeletedUsers()
+		return resp.MakeOkReply()
+	case subcommand == "save" && len(args) == 1:
+		if db.cfg.AclFile == "" {
+			return resp.MakeErrorReply("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := db.acl.Save(db.cfg.AclFile); err != nil {
+			return resp.MakeErrorReply("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return resp.MakeOkReply()
+	}
+	return resp.MakeErrorReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try ACL HELP.")
+}
+
+// getUser replies the rules of a user as a map, null if there is no such user
+func getUser(user *acl.User) resp.Reply {
+	if user == nil {
+		return resp.MakeNullBulkReply()
+	}
+	rules := user.Rules()
+	return resp.MakeMapReply([]resp.Reply{
+		resp.MakeBulkReply([]byte("flags")), stringsReply(rules.Flags),
+		resp.MakeBulkReply([]byte("passwords")), stringsReply(rules.Passwords),
+		resp.MakeBulkReply([]byte("commands")), resp.MakeBulkReply([]byte(rules.Commands)),
+		resp.MakeBulkReply([]byte("keys")), resp.MakeBulkReply([]byte(rules.Keys)),
+		resp.MakeBulkReply([]byte("channels")), resp.MakeBulkReply([]byte(rules.Channels)),
+		resp.MakeBulkReply([]byte("selectors")), resp.MakeEmptyMultiBulkReply(),
+	})
+}
+
+// delUsers deletes users and disconnects their clients, it replies the number of users deleted
+func (db *SequentialDB) delUsers(usernames []string) resp.Reply {
+	if slices.Contains(usernames, acl.DefaultUser) {
+		return resp.MakeErrorReply("ERR The 'default' user cannot be removed")
+	}
+	deleted := 0
+	for _, name := range usernames {
+		if ok, _ := db.acl.DelUser(name); ok {
+			deleted++
+		}
+	}
+	db.disconnectDeletedUsers()
+	return resp.MakeIntegerReply(int64(deleted))
+}
+
+// disconnectDeletedUsers disconnects the clients authenticated as users which no longer exist
+func (db *SequentialDB) disconnectDeletedUsers() {
+	for _, c := range db.clients {
+		if db.acl.User(c.User()) == nil {
+			_ = c.Disconnect()
+		}
+	}
+}
+
+// dryRun implements ACL DRYRUN username command [arg ...]
+func (db *SequentialDB) dryRun(username string, cmdLine [][]byte) resp.Reply {
+	user := db.acl.User(username)
+	if user == nil {
+		return resp.MakeErrorReply("ERR User '" + username + "' not found")
+	}
+	command, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok {
+		return resp.MakeErrorReply("ERR Command '" + string(cmdLine[0]) + "' not found")
+	}
+	if reason, object := user.Check(aclRequest(user, command, cmdLine[1:])); reason != "" {
+		return resp.MakeBulkReply([]byte(denialMessage(username, reason, object)))
+	}
+	return resp.MakeOkReply()
+}
+
+// aclLog implements ACL LOG [count | RESET]
+func (db *SequentialDB) aclLog(args [][]byte) resp.Reply {
+	count := 10
+	if len(args) == 1 {
+		if strings.ToLower(string(args[0])) == "reset" {
+			db.acl.ResetLog()
+			return resp.MakeOkReply()
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil || n < 0 {
+			return resp.MakeErrorReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	now := time.Now()
+	entries := db.acl.Log(count)
+	replies := make([]resp.Reply, len(entries))
+	for i, e := range entries {
+		replies[i] = resp.MakeMapReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("count")), resp.MakeIntegerReply(int64(e.Count)),
+			resp.MakeBulkReply([]byte("reason")), resp.MakeBulkReply([]byte(e.Reason)),
+			resp.MakeBulkReply([]byte("context")), resp.MakeBulkReply([]byte("toplevel")),
+			resp.MakeBulkReply([]byte("object")), resp.MakeBulkReply([]byte(e.Object)),
+			resp.MakeBulkReply([]byte("username")), resp.MakeBulkReply([]byte(e.Username)),
+			resp.MakeBulkReply([]byte("age-seconds")), resp.MakeDoubleReply(now.Sub(e.Created).Seconds()),
+			resp.MakeBulkReply([]byte("client-info")), resp.MakeBulkReply([]byte(e.ClientInfo)),
+			resp.MakeBulkReply([]byte("entry-id")), resp.MakeIntegerReply(e.ID),
+			resp.MakeBulkReply([]byte("timestamp-created")), resp.MakeIntegerReply(e.Created.UnixMilli()),
+			resp.MakeBulkReply([]byte("timestamp-last-updated")), resp.MakeIntegerReply(e.Updated.UnixMilli()),
+		})
+	}
+	return resp.MakeArrayReply(replies)
+}
+
+func init() {
+	registerCommand("acl", aclExecuter, flagAdmin, noKeys, "slow")
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+func TestACLPermissions(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	admin := connection.NewConn(nil)
+	if got := execClient(db, admin, "acl", "setuser", "alice", "on", ">pw", "+@read", "+set", "~cache:*", "%R~config:*", "&news.*"); got != "+OK\r\n" {
+		t.Fatalf("ACL SETUSER failed: %q", got)
+	}
+
+	alice := connection.NewConn(nil)
+	if got := execClient(db, alice, "auth", "alice", "pw"); got != "+OK\r\n" {
+		t.Fatalf("expected alice to authenticate, got %q", got)
+	}
+	if got := execClient(db, admin, "acl", "whoami"); got != "$7\r\ndefault\r\n" {
+		t.Errorf("expected ACL WHOAMI to return default, got %q", got)
+	}
+	for _, tc := range []struct {
+		cmdLine []string
+		want    string
+	}{
+		{[]string{"set", "cache:1", "v"}, "+OK\r\n"},
+		{[]string{"get", "cache:1"}, "$1\r\nv\r\n"},
+		{[]string{"get", "config:1"}, "$-1\r\n"},
+		{[]string{"set", "config:1", "v"}, "-NOPERM No permissions to access a key\r\n"},
+		{[]string{"get", "other"}, "-NOPERM No permissions to access a key\r\n"},
+		{[]string{"hset", "cache:1", "f", "v"}, "-NOPERM User alice has no permissions to run the 'hset' command\r\n"},
+		{[]string{"publish", "news.today", "hi"}, "-NOPERM User alice has no permissions to run the 'publish' command\r\n"},
+	} {
+		if got := execClient(db, alice, tc.cmdLine...); got != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.cmdLine, tc.want, got)
+		}
+	}
+
+	execClient(db, admin, "acl", "setuser", "alice", "+publish")
+	if got := execClient(db, alice, "publish", "news.today", "hi"); got != ":0\r\n" {
+		t.Errorf("expected PUBLISH to an allowed channel to succeed, got %q", got)
+	}
+	if got := execClient(db, alice, "publish", "sports", "hi"); got != "-NOPERM No permissions to access a channel\r\n" {
+		t.Errorf("expected PUBLISH to another channel to be denied, got %q", got)
+	}
+
+	if got := execClient(db, admin, "acl", "dryrun", "alice", "hset", "cache:1", "f", "v"); !strings.Contains(got, "no permissions to run the 'hset' command") {
+		t.Errorf("expected ACL DRYRUN to deny HSET, got %q", got)
+	}
+	if got := execClient(db, admin, "acl", "dryrun", "alice", "get", "cache:1"); got != "+OK\r\n" {
+		t.Errorf("expected ACL DRYRUN to allow GET, got %q", got)
+	}
+
+	log := execClient(db, admin, "acl", "log")
+	if !strings.HasPrefix(log, "*5\r\n") || !strings.Contains(log, "sports") || !strings.Contains(log, "user=alice") {
+		t.Errorf("expected 5 ACL LOG entries, got %q", log)
+	}
+	if got := execClient(db, admin, "acl", "log", "reset"); got != "+OK\r\n" {
+		t.Errorf("ACL LOG RESET failed: %q", got)
+	}
+	if got := execClient(db, admin, "acl", "log"); got != "*0\r\n" {
+		t.Errorf("expected the ACL LOG to be empty, got %q", got)
+	}
+
+	if got := execClient(db, admin, "acl", "deluser", "alice", "bob"); got != ":1\r\n" {
+		t.Errorf("expected ACL DELUSER to delete 1 user, got %q", got)
+	}
+	if got := execClient(db, alice, "get", "cache:1"); !strings.HasPrefix(got, "-NOPERM") {
+		t.Errorf("expected the clients of a deleted user to be denied, got %q", got)
+	}
+	if got := execClient(db, admin, "acl", "deluser", "default"); got[0] != '-' {
+		t.Errorf("expected the default user not to be deletable, got %q", got)
+	}
+}
+
+func TestACLSetUserErrors(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+
+	for _, rule := range []string{"+nosuchcommand", "+@nosuchcategory", "bogus", "%X~k*"} {
+		if got := execClient(db, client, "acl", "setuser", "bob", "on", rule); !strings.HasPrefix(got, "-ERR Error in ACL SETUSER modifier '"+rule+"'") {
+			t.Errorf("%s: expected an error, got %q", rule, got)
+		}
+	}
+	// a failed SETUSER has no effect
+	if got := execClient(db, client, "acl", "getuser", "bob"); got != "$-1\r\n" {
+		t.Errorf("expected bob not to exist, got %q", got)
+	}
+	if got := execClient(db, client, "acl", "cat", "nosuchcategory"); got[0] != '-' {
+		t.Errorf("expected ACL CAT of an unknown category to fail, got %q", got)
+	}
+	if got := execClient(db, client, "acl", "cat", "pubsub"); !strings.Contains(got, "publish") {
+		t.Errorf("expected the pubsub category to contain PUBLISH, got %q", got)
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	cfg := &config.ServerProperties{Dir: t.TempDir(), AclFile: path}
+	db := NewSequentialDB(cfg)
+	client := connection.NewConn(nil)
+	execClient(db, client, "acl", "setuser", "alice", "on", ">pw", "~k*", "+get")
+	execClient(db, client, "acl", "setuser", "default", "off")
+	if got := execClient(db, client, "acl", "save"); got != "+OK\r\n" {
+		t.Fatalf("ACL SAVE failed: %q", got)
+	}
+	list := execClient(db, client, "acl", "list")
+	db.Close()
+
+	db = NewSequentialDB(cfg)
+	defer db.Close()
+	if got := execClient(db, client, "acl", "list"); got != list {
+		t.Errorf("expected the users to be loaded from the ACL file:\n%q\ngot\n%q", list, got)
+	}
+	if !db.AuthRequired() {
+		t.Error("expected authentication to be required while the default user is off")
+	}
+	other := connection.NewConn(nil)
+	if got := execClient(db, other, "auth", "alice", "pw"); got != "+OK\r\n" {
+		t.Errorf("expected alice to authenticate, got %q", got)
+	}
+}
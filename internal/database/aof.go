@@ -0,0 +1,65 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/persister"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+// loadAOF replays the append only file, if any, before the DB starts serving
+func (db *SequentialDB) loadAOF() {
+	filename := db.cfg.AOFPath()
+	start := time.Now()
+	err := persister.LoadAOF(filename, db.cache, db.cfg.ProtoLimits(), func(cmdLine [][]byte) {
+		db.executeCommand(strings.ToLower(string(cmdLine[0])), cmdLine[1:])
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logger.Errorf("failed to load AOF file %s: %v", filename, err)
+		return
+	}
+	logger.Infof("DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
+}
+
+// openAOF starts appending write commands to the AOF file
+func (db *SequentialDB) openAOF() {
+	p, err := persister.NewPersister(db.cfg.AOFPath(), db.cfg.AppendFsync)
+	if err != nil {
+		logger.Errorf("failed to open AOF file, append only mode is disabled: %v", err)
+		return
+	}
+	db.persister = p
+}
+
+// aofWriteError returns the AOF write error that makes the DB refuse writes
+func (db *SequentialDB) aofWriteError() error {
+	if db.persister == nil {
+		return nil
+	}
+	return db.persister.LastWriteError()
+}
+
+func (db *SequentialDB) aofInfo() []string {
+	status := "ok"
+	if db.aofWriteError() != nil {
+		status = "err"
+	}
+	lines := []string{
+		"aof_enabled:" + boolInfo(db.persister != nil),
+		"aof_last_write_status:" + status,
+	}
+	if db.persister != nil {
+		lines = append(lines,
+			"aof_current_size:"+strconv.FormatInt(db.persister.CurrentSize(), 10),
+			"aof_delayed_fsync:"+strconv.FormatInt(db.persister.DelayedFsync(), 10),
+		)
+	}
+	return lines
+}
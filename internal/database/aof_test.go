@@ -0,0 +1,165 @@
+package database
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/persister"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func TestAOFReplay(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		AppendOnly:  true,
+		AppendFsync: persister.FsyncAlways,
+	}
+	db := NewSequentialDB(cfg)
+	execLine(db, "set", "a", "1")
+	execLine(db, "set", "a", "2")
+	execLine(db, "get", "a")
+	execLine(db, "set", "b", "3")
+	db.Close()
+
+	db = NewSequentialDB(cfg)
+	defer db.Close()
+	for key, want := range map[string]string{"a": "2", "b": "3"} {
+		if got := string(execLine(db, "get", key).ToBytes()); got != "$1\r\n"+want+"\r\n" {
+			t.Errorf("expected %s for %s, got %q", want, key, got)
+		}
+	}
+	if size := db.persister.CurrentSize(); size == 0 {
+		t.Error("expected commands in the AOF file")
+	}
+}
+
+func TestAOFSkipsSave(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		AppendOnly:  true,
+		AppendFsync: persister.FsyncAlways,
+	}
+	db := NewSequentialDB(cfg)
+	execLine(db, "set", "a", "1")
+	execLine(db, "save")
+	execLine(db, "set", "b", "2")
+	db.Close()
+	if err := os.Remove(cfg.RDBPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	// SAVE resets the dirty counter but is not a write, it must not be replayed
+	db = NewSequentialDB(cfg)
+	defer db.Close()
+	if _, err := os.Stat(cfg.RDBPath()); !os.IsNotExist(err) {
+		t.Errorf("expected SAVE not to be replayed from the AOF, got %v", err)
+	}
+	if got := string(execLine(db, "get", "b").ToBytes()); got != "$1\r\n2\r\n" {
+		t.Errorf("expected the writes to be replayed, got %q", got)
+	}
+}
+
+func TestAOFReplaysAbsoluteExpire(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		AppendOnly:  true,
+		AppendFsync: persister.FsyncAlways,
+	}
+	db := NewSequentialDB(cfg)
+	execLine(db, "set", "a", "1", "px", "200")
+	execLine(db, "set", "b", "2", "nx", "ex", "100")
+	db.Close()
+	_ = os.Remove(cfg.RDBPath())
+	time.Sleep(300 * time.Millisecond)
+
+	// the time to live runs from the first SET, not from the replay
+	db = NewSequentialDB(cfg)
+	defer db.Close()
+	if got := string(execLine(db, "get", "a").ToBytes()); got != "$-1\r\n" {
+		t.Errorf("expected a to be expired after the restart, got %q", got)
+	}
+	if got := string(execLine(db, "get", "b").ToBytes()); got != "$1\r\n2\r\n" {
+		t.Errorf("expected b to be replayed, got %q", got)
+	}
+}
+
+func TestMisconfOnAOFError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full to fail writes")
+	}
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		AppendOnly:  true,
+		AppendFsync: persister.FsyncAlways,
+	}
+	db := NewSequentialDB(cfg)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	execClient(db, client, "set", "a", "1")
+	expectInfo(t, persistenceInfo(db), "aof_last_write_status:ok", "aof_delayed_fsync:0")
+
+	// swaps the persister of the DB, the previous one is closed
+	usePersister := func(filename string) {
+		p, err := persister.NewPersister(filename, persister.FsyncAlways)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var previous *persister.Persister
+		onCommandGoroutine(db, func() {
+			previous, db.persister = db.persister, p
+		})
+		_ = previous.Close()
+	}
+	// writes to /dev/full fail like writes to a full disk
+	usePersister("/dev/full")
+	if got := execClient(db, client, "set", "a", "2"); !strings.HasPrefix(got, "-MISCONF") {
+		t.Errorf("expected the write which failed to be reported, got %q", got)
+	}
+	if got := execClient(db, client, "set", "a", "3"); !strings.HasPrefix(got, "-MISCONF Errors writing to the AOF file") {
+		t.Errorf("expected writes to be refused, got %q", got)
+	}
+	if got := execClient(db, client, "get", "a"); got != "$1\r\n2\r\n" {
+		t.Errorf("expected reads to be served, got %q", got)
+	}
+	expectInfo(t, persistenceInfo(db), "aof_last_write_status:err")
+
+	usePersister(cfg.AOFPath())
+	if got := execClient(db, client, "set", "a", "4"); got != "+OK\r\n" {
+		t.Errorf("expected writes once the AOF can be written, got %q", got)
+	}
+	expectInfo(t, persistenceInfo(db), "aof_last_write_status:ok")
+}
+
+func TestExecBatchAlwaysFsync(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		AppendOnly:  true,
+		AppendFsync: persister.FsyncAlways,
+	}
+	db := NewSequentialDB(cfg)
+	defer db.Close()
+	batch := [][][]byte{
+		{[]byte("SET"), []byte("a"), []byte("1")},
+		{[]byte("get"), []byte("a")},
+		{[]byte("set"), []byte("a"), []byte("2")},
+		{[]byte("multi")},
+		{[]byte("get"), []byte("a")},
+	}
+	want := []string{"+OK\r\n", "$1\r\n1\r\n", "+OK\r\n", "-ERR MULTI is only allowed to clients\r\n", "$1\r\n2\r\n"}
+	replies := db.ExecBatch(nil, batch)
+	for i, reply := range replies {
+		if got := string(reply.ToBytes()); got != want[i] {
+			t.Errorf("command %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+	// both writes are on disk once the replies are released
+	expected := resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("a"), []byte("1")}).ToBytes()
+	expected = append(expected, resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("a"), []byte("2")}).ToBytes()...)
+	if size := db.persister.CurrentSize(); size != int64(len(expected)) {
+		t.Errorf("expected %d bytes in the AOF file, got %d", len(expected), size)
+	}
+}
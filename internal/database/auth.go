@@ -0,0 +1,63 @@
+package database
+
+import (
+	"github.com/mirage208/redis-go/internal/acl"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// errWrongPass is replied to failed authentications, it does not tell whether the user exists
+const errWrongPass = "WRONGPASS invalid username-password pair or user is disabled."
+
+// AuthRequired reports whether new clients have to authenticate, that is unless the
+// default user is enabled and needs no password. It is safe for concurrent use.
+func (db *SequentialDB) AuthRequired() bool {
+	return db.authRequired.Load()
+}
+
+// usersChanged updates what depends on the ACL users after they were modified
+func (db *SequentialDB) usersChanged() {
+	defaultUser := db.acl.User(acl.DefaultUser)
+	db.authRequired.Store(!defaultUser.Enabled() || !defaultUser.NoPass())
+}
+
+// clientAuthRequired reports whether client has to authenticate before running commands
+func (db *SequentialDB) clientAuthRequired(client *connection.Connection) bool {
+	return !client.Authenticated() && db.AuthRequired()
+}
+
+// authenticate logs client in as username, failures are reported in the ACL LOG
+func (db *SequentialDB) authenticate(client *connection.Connection, username, password []byte) bool {
+	user, ok := db.acl.Authenticate(string(username), password)
+	if !ok {
+		db.acl.LogDenial(acl.ReasonAuth, "AUTH", string(username), clientInfo(client))
+		return false
+	}
+	client.SetUser(user.Name())
+	client.SetAuthenticated(true)
+	return true
+}
+
+// authExecuter implements AUTH [username] password
+func authExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'auth' command")
+	}
+	if client == nil {
+		return resp.MakeErrorReply("ERR AUTH requires a client connection")
+	}
+	username, password := []byte(acl.DefaultUser), args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if db.acl.User(acl.DefaultUser).NoPass() {
+		return resp.MakeErrorReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if !db.authenticate(client, username, password) {
+		return resp.MakeErrorReply(errWrongPass)
+	}
+	return resp.MakeOkReply()
+}
+
+func init() {
+	registerCommand("auth", authExecuter, flagNoAuth, noKeys, "connection", "fast")
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+func TestAuth(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), RequirePass: "secret"})
+	defer db.Close()
+	client := connection.NewConn(nil)
+
+	if got := execClient(db, client, "hello", "3"); got[:7] != "-NOAUTH" {
+		t.Errorf("expected HELLO without AUTH to be refused, got %q", got)
+	}
+	if got := execClient(db, client, "auth", "wrong"); got != "-"+errWrongPass+"\r\n" {
+		t.Errorf("expected a wrong password to be refused, got %q", got)
+	}
+	if got := execClient(db, client, "auth", "someone", "secret"); got != "-"+errWrongPass+"\r\n" {
+		t.Errorf("expected an unknown user to be refused, got %q", got)
+	}
+	if client.Authenticated() {
+		t.Fatal("expected the client not to be authenticated")
+	}
+	if got := execClient(db, client, "auth", "secret"); got != "+OK\r\n" || !client.Authenticated() {
+		t.Fatalf("expected AUTH to authenticate, got %q", got)
+	}
+
+	other := connection.NewConn(nil)
+	if got := execClient(db, other, "hello", "3", "auth", "default", "secret"); got[0] != '%' || !other.Authenticated() {
+		t.Errorf("expected HELLO AUTH to authenticate, got %q", got)
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+
+	if got := execClient(db, client, "auth", "secret"); got[0] != '-' {
+		t.Errorf("expected AUTH to fail when no password is configured, got %q", got)
+	}
+	if got := execClient(db, client, "auth", "default", "anything"); got != "+OK\r\n" {
+		t.Errorf("expected the default user to accept any password, got %q", got)
+	}
+}
@@ -0,0 +1,609 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/internal/tracking"
+)
+
+// protocol returns the RESP version of client, commands replayed from the AOF have no client
+func protocol(client *connection.Connection) int {
+	if client == nil {
+		return resp.RESP2
+	}
+	return client.Protocol()
+}
+
+// validClientName rejects names which would break the output of CLIENT LIST
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// helloExecuter implements HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if client == nil {
+		return resp.MakeErrorReply("ERR HELLO requires a client connection")
+	}
+	proto := client.Protocol()
+	var name, username, password []byte
+	if len(args) > 0 {
+		ver, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return resp.MakeErrorReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != resp.RESP2 && ver != resp.RESP3 {
+			return resp.MakeErrorReply("NOPROTO unsupported protocol version")
+		}
+		proto = int(ver)
+
+		for i := 1; i < len(args); i++ {
+			more := len(args) - i - 1
+			option := strings.ToLower(string(args[i]))
+			switch {
+			case option == "auth" && more >= 2:
+				username, password = args[i+1], args[i+2]
+				i += 2
+			case option == "setname" && more >= 1:
+				name = args[i+1]
+				if !validClientName(name) {
+					return resp.MakeErrorReply("ERR Client names cannot contain spaces, newlines or special characters.")
+				}
+				i++
+			default:
+				return resp.MakeErrorReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+			}
+		}
+	}
+
+	if username != nil {
+		if !db.authenticate(client, username, password) {
+			return resp.MakeErrorReply(errWrongPass)
+		}
+	} else if db.clientAuthRequired(client) {
+		return resp.MakeErrorReply("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	client.SetProtocol(proto)
+	if name != nil {
+		client.SetClientName(string(name))
+	}
+	return resp.MakeMapReply([]resp.Reply{
+		resp.MakeBulkReply([]byte("server")), resp.MakeBulkReply([]byte("redis")),
+		resp.MakeBulkReply([]byte("version")), resp.MakeBulkReply([]byte(config.RedisVersion)),
+		resp.MakeBulkReply([]byte("proto")), resp.MakeIntegerReply(int64(proto)),
+		resp.MakeBulkReply([]byte("id")), resp.MakeIntegerReply(int64(client.ID())),
+		resp.MakeBulkReply([]byte("mode")), resp.MakeBulkReply([]byte("standalone")),
+		resp.MakeBulkReply([]byte("role")), resp.MakeBulkReply([]byte(db.role())),
+		resp.MakeBulkReply([]byte("modules")), resp.MakeEmptyMultiBulkReply(),
+	})
+}
+
+// pingExecuter implements PING [message]. Subscribed RESP2 clients get an array, which
+// they can tell apart from the messages.
+func pingExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'ping' command")
+	}
+	if client != nil && client.Protocol() == resp.RESP2 && client.Subscribed() {
+		message := []byte{}
+		if len(args) == 1 {
+			message = args[0]
+		}
+		return resp.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+	}
+	if len(args) == 1 {
+		return resp.MakeBulkReply(args[0])
+	}
+	return resp.MakePongReply()
+}
+
+// clientExecuter implements the CLIENT subcommands
+func clientExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'client' command")
+	}
+	if client == nil {
+		return resp.MakeErrorReply("ERR CLIENT requires a client connection")
+	}
+	subcommand := strings.ToLower(string(args[0]))
+	switch {
+	case subcommand == "id" && len(args) == 1:
+		return resp.MakeIntegerReply(int64(client.ID()))
+	case subcommand == "info" && len(args) == 1:
+		return resp.MakeBulkReply([]byte(db.clientInfo(client) + "\n"))
+	case subcommand == "list":
+		return clientList(db, args[1:])
+	case subcommand == "setname" && len(args) == 2:
+		if !validClientName(args[1]) {
+			return resp.MakeErrorReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.SetClientName(string(args[1]))
+		return resp.MakeOkReply()
+	case subcommand == "getname" && len(args) == 1:
+		if client.ClientName() == "" {
+			return resp.MakeNullBulkReply()
+		}
+		return resp.MakeBulkReply([]byte(client.ClientName()))
+	case subcommand == "kill" && len(args) >= 2:
+		return clientKill(db, client, args[1:])
+	case subcommand == "pause" && (len(args) == 2 || len(args) == 3):
+		return clientPauseCommand(db, args[1:])
+	case subcommand == "unpause" && len(args) == 1:
+		db.unpause()
+		return resp.MakeOkReply()
+	case subcommand == "no-evict" && len(args) == 2:
+		switch strings.ToLower(string(args[1])) {
+		case "on":
+			client.SetNoEvict(true)
+		case "off":
+			client.SetNoEvict(false)
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+		return resp.MakeOkReply()
+	case subcommand == "reply" && len(args) == 2:
+		switch strings.ToLower(string(args[1])) {
+		case "on":
+			client.SetReplyMode(connection.ReplyOn)
+		case "off":
+			client.SetReplyMode(connection.ReplyOff)
+		case "skip":
+			client.SetReplyMode(connection.ReplySkip)
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+		return resp.MakeOkReply()
+	case subcommand == "tracking" && len(args) >= 2:
+		return clientTracking(db, client, args[1:])
+	case subcommand == "caching" && len(args) == 2:
+		var yes bool
+		switch strings.ToLower(string(args[1])) {
+		case "yes":
+			yes = true
+		case "no":
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+		if errReply := db.tracking.SetCaching(client, yes); errReply != nil {
+			return errReply
+		}
+		return resp.MakeOkReply()
+	case subcommand == "getredir" && len(args) == 1:
+		options, ok := db.tracking.Options(client)
+		if !ok {
+			return resp.MakeIntegerReply(-1)
+		}
+		return resp.MakeIntegerReply(int64(options.Redirect))
+	case subcommand == "trackinginfo" && len(args) == 1:
+		return trackingInfo(db, client)
+	case subcommand == "help" && len(args) == 1:
+		return stringsReply(clientHelp)
+	}
+	return resp.MakeErrorReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try CLIENT HELP.")
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CACHING (YES|NO)",
+	"GETNAME",
+	"GETREDIR",
+	"ID",
+	"INFO",
+	"KILL <ip:port>",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    ID <client-id>, TYPE (NORMAL|MASTER|REPLICA|PUBSUB), USER <username>,",
+	"    ADDR <ip:port>, LADDR <ip:port>, SKIPME (YES|NO), MAXAGE <maxage>",
+	"LIST [TYPE (NORMAL|MASTER|REPLICA|PUBSUB)] [ID <client-id> [<client-id> ...]]",
+	"UNPAUSE",
+	"PAUSE <timeout> [WRITE|ALL]",
+	"REPLY (ON|OFF|SKIP)",
+	"SETNAME <name>",
+	"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]] [OPTIN] [OPTOUT] [NOLOOP]",
+	"TRACKINGINFO",
+	"NO-EVICT (ON|OFF)",
+	"HELP",
+}
+
+// outputLimits returns the client-output-buffer-limit classes of cfg
+func outputLimits(cfg *config.ServerProperties) *connection.OutputLimits {
+	limits := &connection.OutputLimits{}
+	for _, limit := range cfg.OutputBufferLimits() {
+		class := connection.ClassNormal
+		switch limit.Class {
+		case "replica":
+			class = connection.ClassReplica
+		case "pubsub":
+			class = connection.ClassPubSub
+		}
+		limits.Classes[class] = connection.OutputLimit{
+			Hard:         limit.Hard,
+			Soft:         limit.Soft,
+			SoftDuration: time.Duration(limit.SoftSeconds) * time.Second,
+		}
+	}
+	return limits
+}
+
+// clientsCron checks the output limits of the clients, for those whose output is only
+// checked by Push as replies are added would otherwise keep a stale soft limit period
+func (db *SequentialDB) clientsCron(now time.Time) {
+	for _, c := range db.clients {
+		c.CheckOutputLimits(now)
+	}
+}
+
+// clientType returns the type of client for the TYPE filters, the link to the master
+// of a replica is not a client
+func clientType(client *connection.Connection) string {
+	if client.Replica() {
+		return "replica"
+	}
+	if client.Subscribed() {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// validClientType reports whether name is a type of the TYPE filters
+func validClientType(name string) bool {
+	switch name {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+	return false
+}
+
+// normalClientType returns the type a TYPE filter matches, slave is replica
+func normalClientType(name string) string {
+	if name == "slave" {
+		return "replica"
+	}
+	return name
+}
+
+// clientFlags returns the flags of client in CLIENT LIST, N if none is set
+func (db *SequentialDB) clientFlags(client *connection.Connection) string {
+	var flags []byte
+	if client.Replica() {
+		flags = append(flags, 'S')
+	}
+	if client.Subscribed() {
+		flags = append(flags, 'P')
+	}
+	if tx := db.txs[client]; tx != nil {
+		if tx.multi {
+			flags = append(flags, 'x')
+		}
+		if tx.dirty {
+			flags = append(flags, 'd')
+		}
+	}
+	if options, ok := db.tracking.Options(client); ok {
+		flags = append(flags, 't')
+		if db.tracking.RedirectBroken(client) {
+			flags = append(flags, 'R')
+		}
+		if options.BCast {
+			flags = append(flags, 'B')
+		}
+	}
+	if client.Overflowed() {
+		flags = append(flags, 'A')
+	}
+	if client.ClosingAfterReply() {
+		flags = append(flags, 'c')
+	}
+	if client.UnixSocket() {
+		flags = append(flags, 'U')
+	}
+	if client.NoEvict() {
+		flags = append(flags, 'e')
+	}
+	if len(flags) == 0 {
+		return "N"
+	}
+	return string(flags)
+}
+
+// clientInfo returns the line describing client in CLIENT LIST and CLIENT INFO
+func (db *SequentialDB) clientInfo(client *connection.Connection) string {
+	redirect := int64(-1)
+	if options, ok := db.tracking.Options(client); ok {
+		redirect = int64(options.Redirect)
+	}
+	lastCmd := client.LastCommand()
+	if lastCmd == "" {
+		lastCmd = "NULL"
+	}
+	pendingReplies, pendingBytes := client.PendingOutput()
+	fields := []string{
+		"id=" + strconv.FormatUint(client.ID(), 10),
+		"addr=" + client.RemoteAddr(),
+		"laddr=" + client.LocalAddr(),
+		"name=" + client.ClientName(),
+		"age=" + strconv.FormatInt(int64(client.Age().Seconds()), 10),
+		"idle=" + strconv.FormatInt(int64(client.IdleTime().Seconds()), 10),
+		"flags=" + db.clientFlags(client),
+		"db=0",
+		"sub=" + strconv.Itoa(client.SubscriptionCount(connection.SubChannel)),
+		"psub=" + strconv.Itoa(client.SubscriptionCount(connection.SubPattern)),
+		"ssub=" + strconv.Itoa(client.SubscriptionCount(connection.SubShard)),
+		"multi=-1",
+		"oll=" + strconv.Itoa(pendingReplies),
+		"omem=" + strconv.FormatInt(pendingBytes, 10),
+		"cmd=" + lastCmd,
+		"user=" + client.User(),
+		"redir=" + strconv.FormatInt(redirect, 10),
+		"resp=" + strconv.Itoa(client.Protocol()),
+	}
+	return strings.Join(fields, " ")
+}
+
+// sortedClients returns the clients in the order they connected
+func (db *SequentialDB) sortedClients() []*connection.Connection {
+	ids := slices.Sorted(maps.Keys(db.clients))
+	clients := make([]*connection.Connection, len(ids))
+	for i, id := range ids {
+		clients[i] = db.clients[id]
+	}
+	return clients
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id [id ...]]
+func clientList(db *SequentialDB, args [][]byte) resp.Reply {
+	var typ string
+	var ids map[uint64]bool
+	if len(args) > 0 {
+		switch strings.ToLower(string(args[0])) {
+		case "type":
+			if len(args) != 2 {
+				return resp.MakeErrorReply("ERR syntax error")
+			}
+			typ = strings.ToLower(string(args[1]))
+			if !validClientType(typ) {
+				return resp.MakeErrorReply("ERR Unknown client type '" + string(args[1]) + "'")
+			}
+			typ = normalClientType(typ)
+		case "id":
+			if len(args) < 2 {
+				return resp.MakeErrorReply("ERR syntax error")
+			}
+			ids = make(map[uint64]bool, len(args)-1)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseUint(string(arg), 10, 64)
+				if err != nil || id == 0 {
+					return resp.MakeErrorReply("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+	}
+
+	var sb strings.Builder
+	for _, c := range db.sortedClients() {
+		if typ != "" && clientType(c) != typ || ids != nil && !ids[c.ID()] {
+			continue
+		}
+		sb.WriteString(db.clientInfo(c))
+		sb.WriteByte('\n')
+	}
+	return resp.MakeBulkReply([]byte(sb.String()))
+}
+
+// killFilter selects the clients of CLIENT KILL, the zero value of a field matches every client
+type killFilter struct {
+	id     uint64
+	typ    string
+	user   string
+	addr   string
+	laddr  string
+	skipMe bool
+	maxAge int64 // seconds
+}
+
+func (f *killFilter) match(c, self *connection.Connection) bool {
+	switch {
+	case f.id != 0 && c.ID() != f.id,
+		f.typ != "" && clientType(c) != f.typ,
+		f.user != "" && c.User() != f.user,
+		f.addr != "" && c.RemoteAddr() != f.addr,
+		f.laddr != "" && c.LocalAddr() != f.laddr,
+		f.skipMe && c == self,
+		f.maxAge > 0 && int64(c.Age().Seconds()) < f.maxAge:
+		return false
+	}
+	return true
+}
+
+// clientKill implements CLIENT KILL addr, which replies OK or an error, and CLIENT KILL
+// option value [option value ...], which replies the number of clients killed
+func clientKill(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 1 {
+		killed := db.killClients(client, &killFilter{addr: string(args[0])})
+		if killed == 0 {
+			return resp.MakeErrorReply("ERR No such client")
+		}
+		return resp.MakeOkReply()
+	}
+	if len(args)%2 != 0 {
+		return resp.MakeErrorReply("ERR syntax error")
+	}
+	filter := &killFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 {
+				return resp.MakeErrorReply("ERR client-id should be greater than 0")
+			}
+			filter.id = id
+		case "type":
+			filter.typ = strings.ToLower(value)
+			if !validClientType(filter.typ) {
+				return resp.MakeErrorReply("ERR Unknown client type '" + value + "'")
+			}
+			filter.typ = normalClientType(filter.typ)
+		case "user":
+			if db.acl.User(value) == nil {
+				return resp.MakeErrorReply("ERR No such user '" + value + "'")
+			}
+			filter.user = value
+		case "addr":
+			filter.addr = value
+		case "laddr":
+			filter.laddr = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return resp.MakeErrorReply("ERR syntax error")
+			}
+		case "maxage":
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return resp.MakeErrorReply("ERR value is not an integer or out of range")
+			}
+			filter.maxAge = maxAge
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+	}
+	return resp.MakeIntegerReply(int64(db.killClients(client, filter)))
+}
+
+// killClients disconnects the clients matched by filter and returns how many there were.
+// The calling client is closed once it got the reply.
+func (db *SequentialDB) killClients(self *connection.Connection, filter *killFilter) int {
+	killed := 0
+	for _, c := range db.clients {
+		if !filter.match(c, self) {
+			continue
+		}
+		if c == self {
+			c.CloseAfterReply()
+		} else {
+			_ = c.Disconnect()
+		}
+		killed++
+	}
+	return killed
+}
+
+// clientPauseCommand implements CLIENT PAUSE timeout [WRITE|ALL], the timeout is in milliseconds
+func clientPauseCommand(db *SequentialDB, args [][]byte) resp.Reply {
+	timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorReply("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return resp.MakeErrorReply("ERR timeout is negative")
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "write":
+			all = false
+		case "all":
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+	}
+	db.pause(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+	return resp.MakeOkReply()
+}
+
+// clientTracking implements CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTracking(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	var options tracking.Options
+	for i := 1; i < len(args); i++ {
+		more := len(args) - i - 1
+		switch option := strings.ToLower(string(args[i])); {
+		case option == "redirect" && more >= 1:
+			id, err := strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrorReply("ERR value is not an integer or out of range")
+			}
+			options.Redirect = id
+			i++
+		case option == "prefix" && more >= 1:
+			options.Prefixes = append(options.Prefixes, string(args[i+1]))
+			i++
+		case option == "bcast":
+			options.BCast = true
+		case option == "optin":
+			options.OptIn = true
+		case option == "optout":
+			options.OptOut = true
+		case option == "noloop":
+			options.NoLoop = true
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+		if errReply := db.tracking.Enable(client, options); errReply != nil {
+			return errReply
+		}
+	case "off":
+		db.tracking.Disable(client)
+	default:
+		return resp.MakeErrorReply("ERR syntax error")
+	}
+	return resp.MakeOkReply()
+}
+
+// trackingInfo replies the tracking flags, redirect target and prefixes of client
+func trackingInfo(db *SequentialDB, client *connection.Connection) resp.Reply {
+	options, ok := db.tracking.Options(client)
+	flags := []string{"off"}
+	redirect := int64(-1)
+	if ok {
+		flags = []string{"on"}
+		for _, flag := range []struct {
+			set  bool
+			name string
+		}{
+			{options.BCast, "bcast"},
+			{options.OptIn, "optin"},
+			{options.OptOut, "optout"},
+			{options.OptIn && db.tracking.Caching(client), "caching-yes"},
+			{options.OptOut && db.tracking.Caching(client), "caching-no"},
+			{options.NoLoop, "noloop"},
+			{db.tracking.RedirectBroken(client), "broken_redirect"},
+		} {
+			if flag.set {
+				flags = append(flags, flag.name)
+			}
+		}
+		redirect = int64(options.Redirect)
+	}
+	return resp.MakeMapReply([]resp.Reply{
+		resp.MakeBulkReply([]byte("flags")), stringsReply(flags),
+		resp.MakeBulkReply([]byte("redirect")), resp.MakeIntegerReply(redirect),
+		resp.MakeBulkReply([]byte("prefixes")), stringsReply(options.Prefixes),
+	})
+}
+
+func init() {
+	registerCommand("hello", helloExecuter, flagNoAuth, noKeys, "connection", "fast")
+	registerCommand("ping", pingExecuter, flagSubscribed, noKeys, "connection", "fast")
+	registerCommand("client", clientExecuter, 0, noKeys, "connection", "slow")
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func execClient(db *SequentialDB, client *connection.Connection, args ...string) string {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return string(resp.ForProtocol(db.Exec(client, cmdLine), client.Protocol()).ToBytes())
+}
+
+func TestHelloSwitchesProtocol(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	execClient(db, client, "hset", "h", "f", "v")
+	execClient(db, client, "zadd", "z", "1.5", "m")
+
+	tests := []struct {
+		args  []string
+		resp2 string
+		resp3 string
+	}{
+		{[]string{"hgetall", "h"}, "*2\r\n$1\r\nf\r\n$1\r\nv\r\n", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"zscore", "z", "m"}, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{[]string{"zscore", "z", "missing"}, "$-1\r\n", "_\r\n"},
+		{[]string{"zrange", "z", "0", "-1", "withscores"}, "*2\r\n$1\r\nm\r\n$3\r\n1.5\r\n", "*1\r\n*2\r\n$1\r\nm\r\n,1.5\r\n"},
+	}
+	for _, tt := range tests {
+		if got := execClient(db, client, tt.args...); got != tt.resp2 {
+			t.Errorf("%v over RESP2: expected %q, got %q", tt.args, tt.resp2, got)
+		}
+	}
+
+	if got := execClient(db, client, "hello", "4"); got != "-NOPROTO unsupported protocol version\r\n" {
+		t.Errorf("unexpected reply to HELLO 4: %q", got)
+	}
+	if got := execClient(db, client, "hello", "3", "setname", "tester"); got[0] != '%' {
+		t.Fatalf("expected a map from HELLO 3, got %q", got)
+	}
+	if client.Protocol() != resp.RESP3 || client.ClientName() != "tester" {
+		t.Fatalf("HELLO did not apply, protocol %d name %q", client.Protocol(), client.ClientName())
+	}
+	for _, tt := range tests {
+		if got := execClient(db, client, tt.args...); got != tt.resp3 {
+			t.Errorf("%v over RESP3: expected %q, got %q", tt.args, tt.resp3, got)
+		}
+	}
+}
+
+func TestClientNameAndList(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	a := connection.NewConn(nil)
+	b := connection.NewConn(nil)
+
+	if got := execClient(db, a, "client", "getname"); got != "$-1\r\n" {
+		t.Errorf("expected no name, got %q", got)
+	}
+	if got := execClient(db, a, "client", "setname", "bad name"); got[0] != '-' {
+		t.Errorf("expected a name with a space to be refused, got %q", got)
+	}
+	execClient(db, a, "client", "setname", "worker")
+	if got := execClient(db, a, "client", "getname"); got != "$6\r\nworker\r\n" {
+		t.Errorf("expected worker, got %q", got)
+	}
+	execClient(db, b, "subscribe", "ch")
+
+	list := execClient(db, a, "client", "list")
+	lines := strings.Split(strings.TrimSpace(list[strings.Index(list, "\n")+1:]), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 clients, got %q", list)
+	}
+	wantA := "id=" + strconv.FormatUint(a.ID(), 10) + " "
+	if !strings.HasPrefix(lines[0], wantA) || !strings.Contains(lines[0], " name=worker ") ||
+		!strings.Contains(lines[0], " flags=N ") || !strings.Contains(lines[0], " cmd=client ") {
+		t.Errorf("unexpected line for the first client: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], " flags=P ") || !strings.Contains(lines[1], " sub=1 ") {
+		t.Errorf("unexpected line for the subscriber: %q", lines[1])
+	}
+	if got := execClient(db, a, "client", "list", "type", "pubsub"); !strings.Contains(got, "id="+strconv.FormatUint(b.ID(), 10)+" ") || strings.Contains(got, wantA) {
+		t.Errorf("expected only the subscriber, got %q", got)
+	}
+	if got := execClient(db, a, "client", "info"); !strings.Contains(got, wantA) {
+		t.Errorf("expected the info of the calling client, got %q", got)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	a := connection.NewConn(nil)
+	b := connection.NewConn(nil)
+	execClient(db, b, "ping")
+
+	if got := execClient(db, a, "client", "kill", "id", "0"); got[0] != '-' {
+		t.Errorf("expected an invalid ID to be refused, got %q", got)
+	}
+	if got := execClient(db, a, "client", "kill", "user", "nobody"); got[0] != '-' {
+		t.Errorf("expected an unknown user to be refused, got %q", got)
+	}
+	// the caller is skipped by default
+	if got := execClient(db, a, "client", "kill", "user", "default"); got != ":1\r\n" {
+		t.Errorf("expected one client killed, got %q", got)
+	}
+	if a.ClosingAfterReply() {
+		t.Error("expected the caller to be skipped")
+	}
+	if got := execClient(db, a, "client", "kill", "id", strconv.FormatUint(a.ID(), 10), "skipme", "no"); got != ":1\r\n" {
+		t.Errorf("expected the caller to be killed, got %q", got)
+	}
+	if !a.ClosingAfterReply() {
+		t.Error("expected the caller to be closed after the reply")
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	noReply := string(resp.MakeNoReply().ToBytes())
+
+	if got := execClient(db, client, "client", "reply", "skip"); got != noReply {
+		t.Errorf("expected no reply to CLIENT REPLY SKIP, got %q", got)
+	}
+	if got := execClient(db, client, "set", "k", "v"); got != noReply {
+		t.Errorf("expected the reply to be skipped, got %q", got)
+	}
+	if got := execClient(db, client, "get", "k"); got != "$1\r\nv\r\n" {
+		t.Errorf("expected replies after the skipped one, got %q", got)
+	}
+	execClient(db, client, "client", "reply", "off")
+	if got := execClient(db, client, "get", "k"); got != noReply {
+		t.Errorf("expected no reply once off, got %q", got)
+	}
+	if got := execClient(db, client, "client", "reply", "on"); got != "+OK\r\n" {
+		t.Errorf("expected CLIENT REPLY ON to reply, got %q", got)
+	}
+}
+
+func TestClientPauseWrite(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	admin := connection.NewConn(nil)
+	writer := connection.NewConn(nil)
+
+	if got := execClient(db, admin, "client", "pause", "10000", "write"); got != "+OK\r\n" {
+		t.Fatalf("unexpected reply to CLIENT PAUSE %q", got)
+	}
+	written := make(chan string)
+	go func() {
+		written <- execClient(db, writer, "set", "k", "v")
+	}()
+	if got := execClient(db, connection.NewConn(nil), "get", "k"); got != "$-1\r\n" {
+		t.Errorf("expected reads to go on during the pause, got %q", got)
+	}
+	select {
+	case got := <-written:
+		t.Fatalf("expected the write to wait for the pause, got %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	execClient(db, admin, "client", "unpause")
+	if got := <-written; got != "+OK\r\n" {
+		t.Errorf("unexpected reply to the postponed write %q", got)
+	}
+	if got := execClient(db, admin, "get", "k"); got != "$1\r\nv\r\n" {
+		t.Errorf("expected the write to be applied, got %q", got)
+	}
+}
@@ -1,76 +1,397 @@
 package database
 
 import (
+	"runtime"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/mirage208/redis-go/internal/acl"
+	"github.com/mirage208/redis-go/internal/config"
 	"github.com/mirage208/redis-go/internal/connection"
 	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/persister"
+	"github.com/mirage208/redis-go/internal/pubsub"
 	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/internal/tracking"
+	"github.com/mirage208/redis-go/pkg/logger"
 )
 
 // DB is the interface for redis style storage engine
 type DB interface {
 	Exec(client *connection.Connection, cmdLine [][]byte) resp.Reply
+	ExecBatch(client *connection.Connection, cmdLines [][][]byte) []resp.Reply
 	AfterClientClose(c *connection.Connection)
+	// AuthRequired reports whether new clients have to authenticate
+	AuthRequired() bool
+	// ShutdownRequested is closed once a client asked for the server to shut down
+	ShutdownRequested() <-chan struct{}
 	Close()
 }
 
+// errWrongType is replied when a command is applied to a key of another data type
+const errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// errShuttingDown is replied to the commands which arrive once the server began to shut down
+const errShuttingDown = "ERR the server is shutting down"
+
+// cronInterval is how often periodic tasks such as automatic snapshots are checked
+const cronInterval = 100 * time.Millisecond
+
+// CMD is a batch of commands from one client, executed together on the command goroutine
 type CMD struct {
-	cmd      string
-	args     [][]byte
-	callback chan resp.Reply
+	client   *connection.Connection
+	cmdLines [][][]byte
+	replies  []resp.Reply
+	done     chan struct{} // closed once replies may be sent
+
+	// the batch may be postponed by CLIENT PAUSE, it then resumes from the command it stopped at
+	executed int
+	writes   []int // indexes of the commands propagated to the AOF
 }
 
 type SequentialDB struct {
+	cfg       *config.ServerProperties
+	startTime time.Time
+
 	cache *kvcache.KVCache
+	dirty int64 // number of changes since the last successful save
+	rdb   *rdbState
+
+	// propagateArgs replaces the arguments of the command being executed in the AOF and
+	// the replication stream, when the command must not be replayed as it was sent
+	propagateArgs [][]byte
+
+	snapshot *snapshotJob // incremental snapshot in progress
+
+	persister *persister.Persister // nil unless append only mode is enabled
+	appended  int64                // commands appended to the AOF, tells which commands of a batch to sync
 
-	cmdCh chan *CMD
+	hub         *pubsub.Hub
+	notifyFlags int // keyspace event classes to publish, see notify
+	tracking    *tracking.Table
+
+	txs         map[*connection.Connection]*txState            // clients in MULTI or watching keys
+	watchedKeys map[string]map[*connection.Connection]struct{} // clients by watched key
+
+	acl          *acl.ACL
+	authRequired atomic.Bool // see AuthRequired
+
+	// clients which have sent commands by ID, until they are closed
+	clients      map[uint64]*connection.Connection
+	paused       clientPause
+	outputLimits *connection.OutputLimits // client-output-buffer-limit
+
+	repl *replState
+
+	// shuttingDown is set once the final snapshot is saved, commands are refused from then on
+	shuttingDown      bool
+	shutdownRequested bool
+	shutdownCh        chan struct{} // closed by SHUTDOWN
+
+	cmdCh         chan *CMD
+	clientCloseCh chan *CMD
+	closeCh       chan chan struct{}
+	linkCh        chan func()   // work of the link to the master, see replica.go
+	stopped       chan struct{} // closed once the command goroutine returned
 }
 
-type ExecFunc func(db *SequentialDB, args [][]byte) resp.Reply
+// ExecFunc executes a command, client is nil for commands replayed from the AOF
+type ExecFunc func(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply
 
-func NewSequentialDB() *SequentialDB {
+// NewSequentialDB loads the data from disk and starts the command goroutine, cfg must not change afterwards
+func NewSequentialDB(cfg *config.ServerProperties) *SequentialDB {
 	d := &SequentialDB{
-		cache: kvcache.NewKVCache(),
-		cmdCh: make(chan *CMD, 1024),
+		cfg:           cfg,
+		startTime:     time.Now(),
+		cache:         kvcache.NewKVCache(),
+		rdb:           newRDBState(cfg.SaveParams()),
+		repl:          newReplState(cfg),
+		hub:           pubsub.NewHub(),
+		clients:       make(map[uint64]*connection.Connection),
+		txs:           make(map[*connection.Connection]*txState),
+		watchedKeys:   make(map[string]map[*connection.Connection]struct{}),
+		cmdCh:         make(chan *CMD, 1024),
+		clientCloseCh: make(chan *CMD),
+		closeCh:       make(chan chan struct{}),
+		linkCh:        make(chan func()),
+		stopped:       make(chan struct{}),
+		shutdownCh:    make(chan struct{}),
+	}
+	if flags, ok := parseNotifyFlags(cfg.NotifyKeyspaceEvents); ok {
+		d.notifyFlags = flags
+	} else {
+		logger.Warnf("invalid notify-keyspace-events: %s", cfg.NotifyKeyspaceEvents)
+	}
+	d.outputLimits = outputLimits(cfg)
+	d.acl = acl.New(aclCatalog(), cfg.RequirePass)
+	d.loadACLFile()
+	d.usersChanged()
+	d.tracking = tracking.NewTable(func(id uint64) *connection.Connection {
+		return d.clients[id]
+	})
+	d.watchExpired()
+	// the AOF is more complete than the snapshot, it is preferred when enabled
+	if cfg.AppendOnly {
+		d.loadAOF()
+		d.openAOF()
+	} else {
+		d.loadRDB()
 	}
+	d.replicaOfConfig()
 	go d.handleCommands()
 	return d
 }
 
+// watchExpired notifies the removal of expired keys, when accessed or by expireCron, which
+// counts as a modification for tracking and WATCH
+func (db *SequentialDB) watchExpired() {
+	db.cache.OnExpired(func(key string) {
+		db.notify(notifyExpired, "expired", key)
+		db.signalModifiedKey(key, nil)
+	})
+}
+
 func (db *SequentialDB) Exec(client *connection.Connection, cmdLine [][]byte) resp.Reply {
+	return db.ExecBatch(client, [][][]byte{cmdLine})[0]
+}
+
+// ExecBatch executes pipelined commands in order with a single round trip to the
+// command goroutine and returns their replies. The replies to a client are converted to
+// its protocol when each command is executed, since a HELLO in the batch changes it
+// for the following commands only.
+func (db *SequentialDB) ExecBatch(client *connection.Connection, cmdLines [][][]byte) []resp.Reply {
 	cmd := &CMD{
-		cmd:      strings.ToLower(string(cmdLine[0])),
-		args:     cmdLine[1:],
-		callback: make(chan resp.Reply),
+		client:   client,
+		cmdLines: cmdLines,
+		replies:  make([]resp.Reply, len(cmdLines)),
+		done:     make(chan struct{}),
+	}
+	select {
+	case db.cmdCh <- cmd:
+	case <-db.stopped:
+		return stoppedReplies(len(cmdLines))
+	}
+	select {
+	case <-cmd.done:
+	case <-db.stopped:
+		// the batch may have completed before the goroutine returned
+		select {
+		case <-cmd.done:
+		default:
+			return stoppedReplies(len(cmdLines))
+		}
 	}
-	db.cmdCh <- cmd
-	return <-cmd.callback
+	return cmd.replies
 }
 
+func stoppedReplies(n int) []resp.Reply {
+	replies := make([]resp.Reply, n)
+	for i := range replies {
+		replies[i] = resp.MakeErrorReply(errShuttingDown)
+	}
+	return replies
+}
+
+// AfterClientClose drops the state of a closed client, such as its subscriptions. It
+// returns once done, so that the connection can be reused.
 func (db *SequentialDB) AfterClientClose(c *connection.Connection) {
-	// No specific actions needed for sequential DB
+	cmd := &CMD{client: c, done: make(chan struct{})}
+	select {
+	case db.clientCloseCh <- cmd:
+		<-cmd.done
+	case <-db.stopped:
+	}
 }
 
+// Close stops the command goroutine, saves the final snapshot unless SHUTDOWN already
+// did and flushes the AOF. No command may be executed afterwards.
 func (db *SequentialDB) Close() {
-	// No specific actions needed for sequential DB
+	done := make(chan struct{})
+	db.closeCh <- done
+	<-done
 }
 
 func (db *SequentialDB) handleCommands() {
-	for cmd := range db.cmdCh {
-		switch cmd.cmd {
-		case "multi":
-			cmd.callback <- resp.MakeErrorReply("ERR 'multi' command not supported in concurrent DB")
-		case "exec":
-			cmd.callback <- resp.MakeErrorReply("ERR 'exec' command not supported in concurrent DB")
-		case "discard":
-			cmd.callback <- resp.MakeErrorReply("ERR 'discard' command not supported in concurrent DB")
-		case "watch":
-			cmd.callback <- resp.MakeErrorReply("ERR 'watch' command not supported in concurrent DB")
-		default:
-			cmd.callback <- db.executeCommand(cmd.cmd, cmd.args)
+	defer close(db.stopped)
+	ticker := time.NewTicker(cronInterval)
+	defer ticker.Stop()
+	for {
+		var cmd *CMD
+		select {
+		case cmd = <-db.cmdCh:
+		case done := <-db.closeCh:
+			db.stopMasterLink()
+			if !db.shuttingDown {
+				if err := db.prepareForShutdown(saveIfConfigured); err != nil {
+					logger.Errorf("failed to prepare for shutdown: %v", err)
+				}
+			}
+			if err := db.persister.Close(); err != nil {
+				logger.Errorf("failed to close AOF: %v", err)
+			}
+			close(done)
+			return
+		case cmd := <-db.clientCloseCh:
+			db.hub.Forget(cmd.client)
+			db.tracking.Disable(cmd.client)
+			db.forgetReplica(cmd.client)
+			db.discardTransaction(cmd.client)
+			delete(db.clients, cmd.client.ID())
+			close(cmd.done)
+			continue
+		case err := <-db.rdb.bgsaveDone:
+			db.bgsaveFinished(err)
+			continue
+		case result := <-db.repl.syncDone:
+			db.fullResyncFinished(result)
+			continue
+		case f := <-db.linkCh:
+			f()
+			continue
+		case now := <-ticker.C:
+			db.cron(now)
+			continue
+		case <-db.snapshotReady():
+			db.snapshotStep()
+			// let clients woken up by replies submit their next command before the next step
+			runtime.Gosched()
+			continue
+		}
+		db.execBatch(cmd)
+		// the batches postponed by CLIENT PAUSE go on as soon as it is over
+		db.pauseCron(time.Now())
+	}
+}
+
+// cron runs periodic background tasks on the command goroutine
+func (db *SequentialDB) cron(now time.Time) {
+	db.snapshotStep()
+	db.clientsCron(now)
+	db.expireCron(now)
+	db.rdbCron(now)
+	db.pauseCron(now)
+	db.replicationCron(now)
+}
+
+// execBatch executes the commands of a batch in order. With the always fsync policy
+// the replies are withheld until the writes of the batch are on disk, which a
+// single fsync covers. A paused command postpones the rest of the batch.
+func (db *SequentialDB) execBatch(cmd *CMD) {
+	if cmd.client != nil {
+		db.clients[cmd.client.ID()] = cmd.client
+		cmd.client.SetOutputLimits(db.outputLimits)
+	}
+	for ; cmd.executed < len(cmd.cmdLines); cmd.executed++ {
+		i, cmdLine := cmd.executed, cmd.cmdLines[cmd.executed]
+		name := strings.ToLower(string(cmdLine[0]))
+		if db.shuttingDown {
+			cmd.replies[i] = resp.MakeErrorReply(errShuttingDown)
+			continue
+		}
+		if db.pausing(cmd.client, name) {
+			db.paused.postponed = append(db.paused.postponed, cmd)
+			return
+		}
+		appended := db.appended
+		cmd.replies[i] = db.call(cmd.client, name, cmdLine[1:])
+		if db.appended != appended {
+			cmd.writes = append(cmd.writes, i)
+		}
+		if cmd.client != nil {
+			cmd.client.CommandExecuted(name)
+			if !cmd.client.Replying() {
+				cmd.replies[i] = resp.MakeNoReply()
+			} else {
+				cmd.replies[i] = resp.ForProtocol(cmd.replies[i], cmd.client.Protocol())
+			}
+		}
+	}
+	if len(cmd.writes) == 0 || db.persister.FsyncPolicy() != persister.FsyncAlways {
+		close(cmd.done)
+		return
+	}
+	db.persister.Sync(func(err error) {
+		if err != nil {
+			// the commands are applied in memory but not durable, the client must not take them as acknowledged
+			for _, i := range cmd.writes {
+				cmd.replies[i] = resp.MakeErrorReply("MISCONF Errors writing to the AOF file: " + err.Error())
+			}
+		}
+		close(cmd.done)
+	})
+}
+
+// call executes a client command and propagates it to the AOF and the replicas if it
+// changed the dataset. The commands of a client in MULTI are queued instead.
+func (db *SequentialDB) call(client *connection.Connection, name string, args [][]byte) resp.Reply {
+	command, exists := cmdTable[name]
+	if !exists {
+		return db.rejectCommand(client, resp.MakeErrorReply("ERR unknown command '"+name+"'"))
+	}
+	if client != nil && client.Protocol() == resp.RESP2 && client.Subscribed() && command.flags&flagSubscribed == 0 {
+		return db.rejectCommand(client, resp.MakeErrorReply("ERR Can't execute '"+name+"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"))
+	}
+	if client != nil && command.flags&flagNoAuth == 0 {
+		if errReply := db.checkPermissions(client, command, args); errReply != nil {
+			return db.rejectCommand(client, errReply)
 		}
 	}
+	if client != nil && db.repl.master != nil && command.flags&flagWrite != 0 {
+		return db.rejectCommand(client, resp.MakeErrorReply("READONLY You can't write against a read only replica."))
+	}
+	if command.flags&flagWrite != 0 {
+		if db.rdbWriteError() != nil {
+			return db.rejectCommand(client, resp.MakeErrorReply(errRDBMisconf))
+		}
+		if err := db.aofWriteError(); err != nil {
+			return db.rejectCommand(client, resp.MakeErrorReply("MISCONF Errors writing to the AOF file: "+err.Error()))
+		}
+	}
+	if command.flags&flagNoQueue == 0 && db.inMulti(client) {
+		return db.queueCommand(client, command, name, args)
+	}
+
+	dirty := db.dirty
+	db.propagateArgs = nil
+	reply := command.executer(db, client, args)
+	if client != nil && db.tracking.Enabled(client) {
+		var readKeys []string
+		if command.flags&flagReadonly != 0 {
+			readKeys = command.keys.extract(args)
+		}
+		db.tracking.AfterCommand(client, readKeys, name == "client")
+	}
+	if db.dirty != dirty && (!db.tracking.Empty() || len(db.watchedKeys) > 0) {
+		for _, key := range command.keys.extract(args) {
+			db.signalModifiedKey(key, client)
+		}
+	}
+	// SAVE resets the dirty counter, only writes are propagated
+	if command.flags&flagWrite == 0 || db.dirty == dirty {
+		return reply
+	}
+	if db.propagateArgs != nil {
+		args = db.propagateArgs
+	}
+	cmdLine := make([][]byte, 0, len(args)+1)
+	cmdLine = append(cmdLine, []byte(name))
+	cmdLine = append(cmdLine, args...)
+	db.replicationFeed(cmdLine)
+	if db.persister != nil {
+		db.persister.Append(cmdLine)
+		db.appended++
+	}
+	return reply
+}
+
+// signalModifiedKey invalidates key for the clients tracking it and aborts the
+// transactions watching it, client is the one which modified it, if any
+func (db *SequentialDB) signalModifiedKey(key string, client *connection.Connection) {
+	if !db.tracking.Empty() {
+		db.tracking.Invalidate(key, client)
+	}
+	db.touchWatchedKey(key)
 }
 
 func (db *SequentialDB) executeCommand(cmdName string, args [][]byte) resp.Reply {
@@ -78,5 +399,5 @@ func (db *SequentialDB) executeCommand(cmdName string, args [][]byte) resp.Reply
 	if !exists {
 		return resp.MakeErrorReply("ERR unknown command '" + cmdName + "'")
 	}
-	return cmd.executer(db, args)
+	return cmd.executer(db, nil, args)
 }
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"time"
+)
+
+const (
+	// activeExpireSample is how many keys with a TTL are checked at once
+	activeExpireSample = 20
+	// activeExpireTimeLimit bounds the time a cron spends removing expired keys
+	activeExpireTimeLimit = cronInterval / 4
+)
+
+// expireCron removes the expired keys nobody accesses, so that their memory is released
+// and their expired event is sent. Like Redis it samples the keys with a TTL and goes on
+// while more than a quarter of a sample was expired. Keys do not expire while writes are
+// paused by CLIENT PAUSE.
+func (db *SequentialDB) expireCron(now time.Time) {
+	if !db.paused.until.IsZero() {
+		return
+	}
+	deadline := time.Now().Add(activeExpireTimeLimit)
+	for {
+		checked, expired := db.cache.ActiveExpire(activeExpireSample, now)
+		if expired*4 <= checked || time.Now().After(deadline) {
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"github.com/mirage208/redis-go/common/datastruct/dict"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// getHash returns the hash stored at key, nil if the key does not exist.
+// With forWrite the caller may modify the hash in place.
+func (db *SequentialDB) getHash(key string, forWrite bool) (dict.Dict, resp.Reply) {
+	get := db.cache.GetEntity
+	if forWrite {
+		get = db.cache.GetEntityForWrite
+	}
+	entity, exists := get(key)
+	if !exists {
+		return nil, nil
+	}
+	hash, ok := entity.Data.(dict.Dict)
+	if !ok {
+		return nil, resp.MakeErrorReply(errWrongType)
+	}
+	return hash, nil
+}
+
+// hsetExecuter implements HSET key field value [field value ...]
+func hsetExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'hset' command")
+	}
+	key := string(args[0])
+	hash, errReply := db.getHash(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		hash = dict.NewSequentialDict()
+		db.cache.PutEntity(key, &kvcache.DataEntity{Data: hash})
+	}
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		added += hash.Put(string(args[i]), args[i+1])
+	}
+	db.dirty++
+	db.notify(notifyHash, "hset", key)
+	return resp.MakeIntegerReply(int64(added))
+}
+
+func hgetExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'hget' command")
+	}
+	hash, errReply := db.getHash(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return resp.MakeNullBulkReply()
+	}
+	value, exists := hash.Get(string(args[1]))
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+	return resp.MakeBulkReply(value.([]byte))
+}
+
+// hdelExecuter implements HDEL key field [field ...], the key is removed with its last field
+func hdelExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'hdel' command")
+	}
+	key := string(args[0])
+	hash, errReply := db.getHash(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	deleted := 0
+	for _, field := range args[1:] {
+		_, result := hash.Remove(string(field))
+		deleted += result
+	}
+	if deleted > 0 {
+		db.dirty++
+		db.notify(notifyHash, "hdel", key)
+	}
+	if hash.Len() == 0 {
+		db.cache.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return resp.MakeIntegerReply(int64(deleted))
+}
+
+func hlenExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'hlen' command")
+	}
+	hash, errReply := db.getHash(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	return resp.MakeIntegerReply(int64(hash.Len()))
+}
+
+func hexistsExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'hexists' command")
+	}
+	hash, errReply := db.getHash(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	if _, exists := hash.Get(string(args[1])); exists {
+		return resp.MakeIntegerReply(1)
+	}
+	return resp.MakeIntegerReply(0)
+}
+
+// hgetallExecuter replies a map, which RESP2 clients receive as a flat array of fields and values
+func hgetallExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'hgetall' command")
+	}
+	hash, errReply := db.getHash(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return resp.MakeMapReply(nil)
+	}
+	pairs := make([]resp.Reply, 0, 2*hash.Len())
+	hash.ForEach(func(field string, value any) bool {
+		pairs = append(pairs, resp.MakeBulkReply([]byte(field)), resp.MakeBulkReply(value.([]byte)))
+		return true
+	})
+	return resp.MakeMapReply(pairs)
+}
+
+func init() {
+	registerCommand("hset", hsetExecuter, flagWrite, singleKey, "hash", "fast")
+	registerCommand("hget", hgetExecuter, flagReadonly, singleKey, "hash", "fast")
+	registerCommand("hdel", hdelExecuter, flagWrite, singleKey, "hash", "fast")
+	registerCommand("hlen", hlenExecuter, flagReadonly, singleKey, "hash", "fast")
+	registerCommand("hexists", hexistsExecuter, flagReadonly, singleKey, "hash", "fast")
+	registerCommand("hgetall", hgetallExecuter, flagReadonly, singleKey, "hash", "slow")
+}
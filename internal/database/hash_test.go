@@ -0,0 +1,58 @@
+package database
+
+import "testing"
+
+const wrongType = "-" + errWrongType + "\r\n"
+
+func TestHashCommands(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{
+			name: "hset and read",
+			steps: []commandStep{
+				{cmd: []string{"hset", "h", "f1", "v1", "f2", "v2"}, reply: ":2\r\n", events: []string{"hset h"}},
+				{cmd: []string{"hset", "h", "f1", "x"}, reply: ":0\r\n", events: []string{"hset h"}},
+				{cmd: []string{"hget", "h", "f1"}, reply: "$1\r\nx\r\n"},
+				{cmd: []string{"hget", "h", "nope"}, reply: "$-1\r\n"},
+				{cmd: []string{"hget", "missing", "f1"}, reply: "$-1\r\n"},
+				{cmd: []string{"hlen", "h"}, reply: ":2\r\n"},
+				{cmd: []string{"hlen", "missing"}, reply: ":0\r\n"},
+				{cmd: []string{"hexists", "h", "f2"}, reply: ":1\r\n"},
+				{cmd: []string{"hexists", "h", "nope"}, reply: ":0\r\n"},
+				{cmd: []string{"hexists", "missing", "f2"}, reply: ":0\r\n"},
+				{cmd: []string{"hset", "h", "f3"}, reply: "-ERR wrong number of arguments for 'hset' command\r\n"},
+			},
+		},
+		{
+			name: "hgetall",
+			steps: []commandStep{
+				{cmd: []string{"hgetall", "h"}, reply: "*0\r\n"},
+				{cmd: []string{"hset", "h", "f", "v"}, reply: ":1\r\n", events: []string{"hset h"}},
+				{cmd: []string{"hgetall", "h"}, reply: "*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+			},
+		},
+		{
+			name: "hdel removes the empty key",
+			steps: []commandStep{
+				{cmd: []string{"hset", "h", "f1", "v", "f2", "v"}, reply: ":2\r\n", events: []string{"hset h"}},
+				{cmd: []string{"hdel", "h", "f1", "nope"}, reply: ":1\r\n", events: []string{"hdel h"}},
+				{cmd: []string{"hdel", "h", "nope"}, reply: ":0\r\n"},
+				{cmd: []string{"hdel", "missing", "f1"}, reply: ":0\r\n"},
+				{cmd: []string{"hdel", "h", "f2"}, reply: ":1\r\n", events: []string{"hdel h", "del h"}},
+				{cmd: []string{"hlen", "h"}, reply: ":0\r\n"},
+			},
+			absent: []string{"h", "missing"},
+		},
+		{
+			name: "wrong type",
+			steps: []commandStep{
+				{cmd: []string{"set", "s", "v"}, reply: "+OK\r\n", events: []string{"set s"}},
+				{cmd: []string{"hset", "s", "f", "v"}, reply: wrongType},
+				{cmd: []string{"hget", "s", "f"}, reply: wrongType},
+				{cmd: []string{"hdel", "s", "f"}, reply: wrongType},
+				{cmd: []string{"hlen", "s"}, reply: wrongType},
+				{cmd: []string{"hexists", "s", "f"}, reply: wrongType},
+				{cmd: []string{"hgetall", "s"}, reply: wrongType},
+			},
+		},
+	})
+}
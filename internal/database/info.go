@@ -0,0 +1,120 @@
+package database
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// infoSection generates the "field:value" lines of an INFO section
+type infoSection struct {
+	name     string
+	generate func(db *SequentialDB) []string
+}
+
+// infoSections are listed in the order INFO prints them
+var infoSections = []infoSection{
+	{name: "server", generate: (*SequentialDB).serverInfo},
+	{name: "clients", generate: (*SequentialDB).clientsInfo},
+	{name: "persistence", generate: (*SequentialDB).persistenceInfo},
+	{name: "stats", generate: (*SequentialDB).statsInfo},
+	{name: "replication", generate: (*SequentialDB).replicationInfo},
+	{name: "keyspace", generate: (*SequentialDB).keyspaceInfo},
+}
+
+func (db *SequentialDB) serverInfo() []string {
+	uptime := time.Since(db.startTime)
+	return []string{
+		"redis_version:" + config.RedisVersion,
+		"redis_mode:standalone",
+		"os:" + runtime.GOOS,
+		"arch_bits:" + strconv.Itoa(strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		"process_id:" + strconv.Itoa(os.Getpid()),
+		"run_id:" + db.cfg.RunID,
+		"tcp_port:" + strconv.Itoa(db.cfg.Port),
+		"uptime_in_seconds:" + strconv.FormatInt(int64(uptime.Seconds()), 10),
+		"uptime_in_days:" + strconv.FormatInt(int64(uptime.Hours()/24), 10),
+	}
+}
+
+func (db *SequentialDB) clientsInfo() []string {
+	pubsubClients := 0
+	var maxOutput int64
+	for _, c := range db.clients {
+		if c.Subscribed() {
+			pubsubClients++
+		}
+		_, pending := c.PendingOutput()
+		maxOutput = max(maxOutput, pending)
+	}
+	return []string{
+		"connected_clients:" + strconv.Itoa(len(db.clients)),
+		"maxclients:" + strconv.Itoa(db.cfg.MaxClients),
+		"client_recent_max_output_buffer:" + strconv.FormatInt(maxOutput, 10),
+		"pubsub_clients:" + strconv.Itoa(pubsubClients),
+	}
+}
+
+func (db *SequentialDB) statsInfo() []string {
+	return []string{
+		"client_output_buffer_limit_disconnections:" + strconv.FormatInt(db.outputLimits.Disconnections(), 10),
+		"sync_full:" + strconv.FormatInt(db.repl.syncFull, 10),
+		"sync_partial_ok:" + strconv.FormatInt(db.repl.syncPartialOK, 10),
+		"sync_partial_err:" + strconv.FormatInt(db.repl.syncPartialErr, 10),
+	}
+}
+
+func (db *SequentialDB) keyspaceInfo() []string {
+	if db.cache.Len() == 0 {
+		return nil
+	}
+	return []string{
+		"db0:keys=" + strconv.Itoa(db.cache.Len()) + ",expires=" + strconv.Itoa(db.cache.ExpiresLen()) + ",avg_ttl=0",
+	}
+}
+
+func boolInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// infoExecuter implements INFO [section [section ...]]
+func infoExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	all := len(args) == 0
+	wanted := make(map[string]bool, len(args))
+	for _, arg := range args {
+		section := strings.ToLower(string(arg))
+		if section == "all" || section == "default" || section == "everything" {
+			all = true
+		}
+		wanted[section] = true
+	}
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(resp.CRLF)
+		}
+		sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + resp.CRLF)
+		for _, line := range section.generate(db) {
+			sb.WriteString(line + resp.CRLF)
+		}
+	}
+	return resp.MakeBulkReply([]byte(sb.String()))
+}
+
+func init() {
+	registerCommand("info", infoExecuter, 0, noKeys, "slow", "dangerous")
+}
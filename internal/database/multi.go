@@ -0,0 +1,198 @@
+package database
+
+import (
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// txState is the transaction of a client: the keys it watches and, between MULTI and
+// EXEC, the commands it queued. It is only accessed by the command goroutine.
+type txState struct {
+	multi   bool       // MULTI was called, commands are queued until EXEC or DISCARD
+	queued  [][][]byte // command lines, their name lowercased
+	writes  bool       // a queued command may modify the dataset
+	aborted bool       // a command was rejected while queueing, EXEC discards the transaction
+	watched []string   // keys watched with WATCH
+	dirty   bool       // a watched key was modified, EXEC aborts the transaction
+}
+
+// inMulti reports whether the commands of client are queued
+func (db *SequentialDB) inMulti(client *connection.Connection) bool {
+	tx := db.txs[client]
+	return tx != nil && tx.multi
+}
+
+// txOf returns the transaction of client, which is created if needed
+func (db *SequentialDB) txOf(client *connection.Connection) *txState {
+	tx := db.txs[client]
+	if tx == nil {
+		tx = &txState{}
+		db.txs[client] = tx
+	}
+	return tx
+}
+
+// rejectCommand returns the error of a command which was not executed, it aborts
+// the transaction of a client in MULTI like Redis does
+func (db *SequentialDB) rejectCommand(client *connection.Connection, errReply resp.Reply) resp.Reply {
+	if tx := db.txs[client]; tx != nil && tx.multi {
+		tx.aborted = true
+	}
+	return errReply
+}
+
+// queueCommand adds a command to the transaction of client
+func (db *SequentialDB) queueCommand(client *connection.Connection, command *Command, name string, args [][]byte) resp.Reply {
+	tx := db.txs[client]
+	cmdLine := make([][]byte, 0, len(args)+1)
+	cmdLine = append(cmdLine, []byte(name))
+	tx.queued = append(tx.queued, append(cmdLine, args...))
+	if command.flags&flagWrite != 0 {
+		tx.writes = true
+	}
+	return resp.MakeStatusReply("QUEUED")
+}
+
+// touchWatchedKey makes EXEC fail for the clients watching key
+func (db *SequentialDB) touchWatchedKey(key string) {
+	for client := range db.watchedKeys[key] {
+		db.txs[client].dirty = true
+	}
+}
+
+// touchAllWatchedKeys makes EXEC fail for every client watching keys, the dataset was replaced
+func (db *SequentialDB) touchAllWatchedKeys() {
+	for _, tx := range db.txs {
+		if len(tx.watched) > 0 {
+			tx.dirty = true
+		}
+	}
+}
+
+// unwatchAllKeys forgets the keys watched by client
+func (db *SequentialDB) unwatchAllKeys(client *connection.Connection) {
+	tx := db.txs[client]
+	if tx == nil {
+		return
+	}
+	for _, key := range tx.watched {
+		clients := db.watchedKeys[key]
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(db.watchedKeys, key)
+		}
+	}
+	tx.watched, tx.dirty = nil, false
+	if !tx.multi {
+		delete(db.txs, client)
+	}
+}
+
+// discardTransaction ends the transaction of client, its keys are unwatched
+func (db *SequentialDB) discardTransaction(client *connection.Connection) {
+	if tx := db.txs[client]; tx != nil {
+		tx.multi = false
+		db.unwatchAllKeys(client)
+	}
+}
+
+// multiExecuter implements MULTI, the following commands are queued until EXEC
+func multiExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'multi' command")
+	}
+	if client == nil {
+		return resp.MakeErrorReply("ERR MULTI is only allowed to clients")
+	}
+	tx := db.txOf(client)
+	if tx.multi {
+		return resp.MakeErrorReply("ERR MULTI calls can not be nested")
+	}
+	tx.multi = true
+	tx.queued, tx.writes, tx.aborted = nil, false, false
+	return resp.MakeOkReply()
+}
+
+// execExecuter implements EXEC, which runs the queued commands without interleaving
+// other clients. The transaction is aborted if a command failed to queue or a watched
+// key was modified. The writes are propagated to the AOF and the replicas one by one.
+func execExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'exec' command")
+	}
+	tx := db.txs[client]
+	if tx == nil || !tx.multi {
+		return resp.MakeErrorReply("ERR EXEC without MULTI")
+	}
+	defer db.discardTransaction(client)
+	if tx.aborted {
+		return resp.MakeErrorReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	if tx.dirty {
+		return resp.MakeNullArrayReply()
+	}
+	// the queued commands are executed rather than queued again
+	tx.multi = false
+	replies := make([]resp.Reply, len(tx.queued))
+	for i, cmdLine := range tx.queued {
+		replies[i] = db.call(client, string(cmdLine[0]), cmdLine[1:])
+	}
+	return resp.MakeArrayReply(replies)
+}
+
+// discardExecuter implements DISCARD, which drops the queued commands
+func discardExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'discard' command")
+	}
+	if !db.inMulti(client) {
+		return resp.MakeErrorReply("ERR DISCARD without MULTI")
+	}
+	db.discardTransaction(client)
+	return resp.MakeOkReply()
+}
+
+// watchExecuter implements WATCH key [key ...], EXEC then fails if any key is modified
+func watchExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'watch' command")
+	}
+	if client == nil {
+		return resp.MakeErrorReply("ERR WATCH is only allowed to clients")
+	}
+	if db.inMulti(client) {
+		return resp.MakeErrorReply("ERR WATCH inside MULTI is not allowed")
+	}
+	tx := db.txOf(client)
+	for _, arg := range args {
+		key := string(arg)
+		clients := db.watchedKeys[key]
+		if clients == nil {
+			clients = make(map[*connection.Connection]struct{})
+			db.watchedKeys[key] = clients
+		}
+		if _, ok := clients[client]; ok {
+			continue
+		}
+		clients[client] = struct{}{}
+		tx.watched = append(tx.watched, key)
+	}
+	return resp.MakeOkReply()
+}
+
+// unwatchExecuter implements UNWATCH
+func unwatchExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'unwatch' command")
+	}
+	db.unwatchAllKeys(client)
+	return resp.MakeOkReply()
+}
+
+func init() {
+	registerCommand("multi", multiExecuter, flagNoQueue, noKeys, "fast", "transaction")
+	registerCommand("exec", execExecuter, flagNoQueue, noKeys, "slow", "transaction")
+	registerCommand("discard", discardExecuter, flagNoQueue, noKeys, "fast", "transaction")
+	registerCommand("watch", watchExecuter, flagNoQueue, keySpec{first: 1, last: -1, step: 1}, "fast", "transaction")
+	registerCommand("unwatch", unwatchExecuter, 0, noKeys, "fast", "transaction")
+}
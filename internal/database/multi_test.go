@@ -0,0 +1,113 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+func TestMultiExec(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	other := connection.NewConn(nil)
+
+	if got := execClient(db, client, "exec"); got != "-ERR EXEC without MULTI\r\n" {
+		t.Errorf("unexpected EXEC without MULTI %q", got)
+	}
+	execClient(db, client, "multi")
+	if got := execClient(db, client, "multi"); got != "-ERR MULTI calls can not be nested\r\n" {
+		t.Errorf("unexpected nested MULTI %q", got)
+	}
+	if got := execClient(db, client, "set", "a", "1"); got != "+QUEUED\r\n" {
+		t.Fatalf("expected SET to be queued, got %q", got)
+	}
+	execClient(db, client, "get", "a")
+	if list := execClient(db, other, "client", "list"); !strings.Contains(list, " flags=x ") {
+		t.Errorf("expected the x flag in CLIENT LIST, got %q", list)
+	}
+	if got := execClient(db, other, "get", "a"); got != "$-1\r\n" {
+		t.Errorf("expected the queued SET not to run before EXEC, got %q", got)
+	}
+	if got := execClient(db, client, "exec"); got != "*2\r\n+OK\r\n$1\r\n1\r\n" {
+		t.Errorf("unexpected EXEC reply %q", got)
+	}
+
+	// a command rejected while queueing discards the transaction
+	execClient(db, client, "multi")
+	execClient(db, client, "set", "a", "2")
+	if got := execClient(db, client, "nope"); got[0] != '-' {
+		t.Errorf("expected an unknown command to be rejected, got %q", got)
+	}
+	if got := execClient(db, client, "exec"); !strings.HasPrefix(got, "-EXECABORT") {
+		t.Errorf("expected EXECABORT, got %q", got)
+	}
+	execClient(db, client, "multi")
+	execClient(db, client, "set", "a", "3")
+	if got := execClient(db, client, "discard"); got != "+OK\r\n" {
+		t.Errorf("unexpected DISCARD reply %q", got)
+	}
+	if got := execClient(db, client, "discard"); got != "-ERR DISCARD without MULTI\r\n" {
+		t.Errorf("unexpected DISCARD without MULTI %q", got)
+	}
+	if got := execClient(db, client, "get", "a"); got != "$1\r\n1\r\n" {
+		t.Errorf("expected the discarded and aborted transactions not to run, got %q", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	other := connection.NewConn(nil)
+
+	// a watched key modified by another client aborts EXEC
+	execClient(db, client, "watch", "a")
+	execClient(db, other, "set", "a", "1")
+	execClient(db, client, "multi")
+	if got := execClient(db, client, "watch", "b"); got != "-ERR WATCH inside MULTI is not allowed\r\n" {
+		t.Errorf("unexpected WATCH inside MULTI %q", got)
+	}
+	execClient(db, client, "set", "a", "2")
+	if got := execClient(db, client, "exec"); got != "*-1\r\n" {
+		t.Errorf("expected EXEC to be aborted, got %q", got)
+	}
+	if got := execClient(db, other, "get", "a"); got != "$1\r\n1\r\n" {
+		t.Errorf("expected the aborted transaction not to run, got %q", got)
+	}
+
+	// EXEC unwatches, the next transaction runs
+	execClient(db, other, "set", "a", "3")
+	execClient(db, client, "multi")
+	execClient(db, client, "set", "a", "4")
+	if got := execClient(db, client, "exec"); got != "*1\r\n+OK\r\n" {
+		t.Errorf("expected EXEC to run once unwatched, got %q", got)
+	}
+
+	// so does UNWATCH, and the writes of the client itself count
+	execClient(db, client, "watch", "a")
+	execClient(db, client, "unwatch")
+	execClient(db, other, "set", "a", "5")
+	execClient(db, client, "watch", "a")
+	execClient(db, client, "set", "a", "6")
+	execClient(db, client, "multi")
+	execClient(db, client, "get", "a")
+	if got := execClient(db, client, "exec"); got != "*-1\r\n" {
+		t.Errorf("expected EXEC to be aborted by a write of the client, got %q", got)
+	}
+
+	// RESP3 clients get the null type
+	execClient(db, client, "hello", "3")
+	execClient(db, client, "watch", "a")
+	execClient(db, other, "set", "a", "7")
+	execClient(db, client, "multi")
+	if got := execClient(db, client, "exec"); got != "_\r\n" {
+		t.Errorf("expected the RESP3 null, got %q", got)
+	}
+
+	db.AfterClientClose(client)
+	if len(db.txs) != 0 || len(db.watchedKeys) != 0 {
+		t.Errorf("expected the transactions to be forgotten, got %d clients and %d keys", len(db.txs), len(db.watchedKeys))
+	}
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"strings"
+)
+
+// keyspace event classes, selected by the letters of notify-keyspace-events
+const (
+	notifyKeyspace = 1 << iota // K, __keyspace@<db>__:<key> channels receive the event
+	notifyKeyevent             // E, __keyevent@<db>__:<event> channels receive the key
+	notifyGeneric              // g, commands which are not type specific, e.g. DEL
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x, keys removed because their TTL passed, when accessed or by expireCron
+	notifyEvicted              // e, keys evicted under maxmemory
+	notifyStream               // t
+	notifyModule               // d
+
+	// notifyAll is the A alias
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+// notifyClasses maps the letters of notify-keyspace-events to event classes. The m and n
+// classes of Redis, key misses and new keys, are never sent by this server and are rejected
+// rather than silently ignored.
+var notifyClasses = map[rune]int{
+	'K': notifyKeyspace, 'E': notifyKeyevent, 'A': notifyAll,
+	'g': notifyGeneric, '$': notifyString, 'l': notifyList, 's': notifySet, 'h': notifyHash,
+	'z': notifyZSet, 'x': notifyExpired, 'e': notifyEvicted, 't': notifyStream,
+	'd': notifyModule,
+}
+
+// parseNotifyFlags parses the notify-keyspace-events letters, ok is false if one is unknown.
+// Events are only sent when K or E is given along with at least one class. The classes of
+// the types and features this server lacks, such as lists, evictions and streams, are
+// accepted like Redis does, they just never fire.
+func parseNotifyFlags(value string) (flags int, ok bool) {
+	for _, letter := range strings.Trim(value, "\"") {
+		class, known := notifyClasses[letter]
+		if !known {
+			return 0, false
+		}
+		flags |= class
+	}
+	return flags, true
+}
+
+// notify publishes a keyspace event if its class is enabled. This server has a single
+// database, whose index in the channel names is 0.
+func (db *SequentialDB) notify(class int, event string, key string) {
+	if db.notifyFlags&class == 0 {
+		return
+	}
+	if db.notifyFlags&notifyKeyspace != 0 {
+		db.hub.Publish("__keyspace@0__:"+key, []byte(event))
+	}
+	if db.notifyFlags&notifyKeyevent != 0 {
+		db.hub.Publish("__keyevent@0__:"+event, []byte(key))
+	}
+}
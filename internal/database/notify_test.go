@@ -0,0 +1,183 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+// commandStep is a command with its reply and the keyevent notifications it fires, as "event key"
+type commandStep struct {
+	cmd    []string
+	reply  string
+	events []string
+}
+
+// commandTest runs its steps in order on an empty DB, absent lists the keys which must be gone afterwards
+type commandTest struct {
+	name   string
+	steps  []commandStep
+	absent []string
+}
+
+func runCommandTests(t *testing.T, tests []commandTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), NotifyKeyspaceEvents: "EA"})
+			defer db.Close()
+			subscriber := connection.NewConn(nil)
+			client := connection.NewConn(nil)
+			execClient(db, subscriber, "psubscribe", "__keyevent@0__:*")
+			pushed(subscriber)
+
+			for _, step := range tt.steps {
+				if got := execClient(db, client, step.cmd...); got != step.reply {
+					t.Errorf("%v: expected %q, got %q", step.cmd, step.reply, got)
+				}
+				var events []string
+				for _, message := range pushed(subscriber) {
+					// *4 pmessage pattern channel key
+					parts := strings.Split(message, "\r\n")
+					events = append(events, strings.TrimPrefix(parts[6], "__keyevent@0__:")+" "+parts[8])
+				}
+				if strings.Join(events, ",") != strings.Join(step.events, ",") {
+					t.Errorf("%v: expected events %q, got %q", step.cmd, step.events, events)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, exists := db.cache.GetEntity(key); exists {
+					t.Errorf("expected %s to be removed", key)
+				}
+			}
+		})
+	}
+}
+
+func TestParseNotifyFlags(t *testing.T) {
+	tests := []struct {
+		value string
+		flags int
+		ok    bool
+	}{
+		{"", 0, true},
+		{"Ex", notifyKeyevent | notifyExpired, true},
+		{`"KA"`, notifyKeyspace | notifyAll, true},
+		{"KEA", notifyKeyspace | notifyKeyevent | notifyAll, true},
+		{"Kq", 0, false},
+		{"Km", 0, false},
+		{"En", 0, false},
+	}
+	for _, tt := range tests {
+		flags, ok := parseNotifyFlags(tt.value)
+		if flags != tt.flags || ok != tt.ok {
+			t.Errorf("parseNotifyFlags(%q) = %b %v, want %b %v", tt.value, flags, ok, tt.flags, tt.ok)
+		}
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), NotifyKeyspaceEvents: "KEhgx"})
+	defer db.Close()
+	subscriber := connection.NewConn(nil)
+	client := connection.NewConn(nil)
+	execClient(db, subscriber, "psubscribe", "__key*__:*")
+
+	execClient(db, client, "set", "s", "v") // strings are not enabled
+	execClient(db, client, "hset", "h", "f", "v")
+	execClient(db, client, "hdel", "h", "f")
+	want := []string{
+		"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$16\r\n__keyspace@0__:h\r\n$4\r\nhset\r\n",
+		"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$19\r\n__keyevent@0__:hset\r\n$1\r\nh\r\n",
+		"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$16\r\n__keyspace@0__:h\r\n$4\r\nhdel\r\n",
+		"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$19\r\n__keyevent@0__:hdel\r\n$1\r\nh\r\n",
+		"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$16\r\n__keyspace@0__:h\r\n$3\r\ndel\r\n",
+		"*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$18\r\n__keyevent@0__:del\r\n$1\r\nh\r\n",
+	}
+	if got := pushed(subscriber); len(got) != len(want) {
+		t.Fatalf("expected %d messages, got %q", len(want), got)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("message %d: expected %q, got %q", i, want[i], got[i])
+			}
+		}
+	}
+
+	// the command goroutine is idle between commands
+	db.cache.Expire("s", time.Now().Add(-time.Second))
+	if got := execClient(db, client, "get", "s"); got != "$-1\r\n" {
+		t.Fatalf("expected the key to expire, got %q", got)
+	}
+	if got := pushed(subscriber); len(got) != 2 || got[1] != "*4\r\n$8\r\npmessage\r\n$10\r\n__key*__:*\r\n$22\r\n__keyevent@0__:expired\r\n$1\r\ns\r\n" {
+		t.Errorf("unexpected expiration messages %q", got)
+	}
+}
+
+func TestActiveExpire(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), NotifyKeyspaceEvents: "Ex"})
+	defer db.Close()
+	subscriber := connection.NewConn(nil)
+	execClient(db, subscriber, "subscribe", "__keyevent@0__:expired")
+	pushed(subscriber)
+
+	// far more keys than a sample, every one expired, and a key to keep. The pause
+	// keeps the cron from removing them early.
+	onCommandGoroutine(db, func() { db.pause(time.Now().Add(time.Minute), false) })
+	execLine(db, "set", "kept", "v", "ex", "100")
+	for i := 0; i < 10*activeExpireSample; i++ {
+		execLine(db, "set", "k"+strconv.Itoa(i), "v", "px", "1")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	onCommandGoroutine(db, func() { db.expireCron(time.Now()) })
+	if n := db.cache.Len(); n != 10*activeExpireSample+1 {
+		t.Fatalf("expected no key to expire during CLIENT PAUSE, %d left", n)
+	}
+	onCommandGoroutine(db, func() {
+		db.unpause()
+		db.pauseCron(time.Now())
+		db.expireCron(time.Now())
+	})
+	if n := db.cache.Len(); n != 1 {
+		t.Errorf("expected the expired keys to be removed without being accessed, %d left", n)
+	}
+	if got := pushed(subscriber); len(got) != 10*activeExpireSample {
+		t.Errorf("expected an expired event per key, got %d", len(got))
+	}
+}
+
+func TestSetExpireNotification(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), NotifyKeyspaceEvents: "Eg$"})
+	defer db.Close()
+	subscriber := connection.NewConn(nil)
+	client := connection.NewConn(nil)
+	execClient(db, subscriber, "psubscribe", "__keyevent@0__:*")
+	pushed(subscriber)
+
+	if got := execClient(db, client, "set", "s", "v", "ex", "100"); got != "+OK\r\n" {
+		t.Fatalf("unexpected reply to SET %q", got)
+	}
+	want := []string{
+		"*4\r\n$8\r\npmessage\r\n$16\r\n__keyevent@0__:*\r\n$18\r\n__keyevent@0__:set\r\n$1\r\ns\r\n",
+		"*4\r\n$8\r\npmessage\r\n$16\r\n__keyevent@0__:*\r\n$21\r\n__keyevent@0__:expire\r\n$1\r\ns\r\n",
+	}
+	if got := pushed(subscriber); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected set and expire events, got %q", got)
+	}
+
+	// nothing is set, nothing is notified
+	if got := execClient(db, client, "set", "s", "w", "nx", "px", "100"); got != "$-1\r\n" {
+		t.Fatalf("unexpected reply to SET NX %q", got)
+	}
+	if got := pushed(subscriber); len(got) != 0 {
+		t.Errorf("expected no event, got %q", got)
+	}
+	if got := execClient(db, client, "set", "s", "w", "ex", "0"); got != "-ERR invalid expire time in 'set' command\r\n" {
+		t.Errorf("unexpected reply to SET EX 0 %q", got)
+	}
+}
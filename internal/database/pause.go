@@ -0,0 +1,59 @@
+package database
+
+import (
+	"time"
+
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+// clientPause is the state of CLIENT PAUSE. The batches of the paused clients are
+// postponed until the pause ends, without holding up the command goroutine.
+type clientPause struct {
+	until     time.Time // zero unless paused
+	all       bool      // every command is paused, not only the writes
+	postponed []*CMD    // in the order they arrived
+}
+
+// pause implements CLIENT PAUSE, a pause already in effect is only extended or made stricter
+func (db *SequentialDB) pause(until time.Time, all bool) {
+	if until.After(db.paused.until) {
+		db.paused.until = until
+	}
+	db.paused.all = db.paused.all || all
+}
+
+// unpause implements CLIENT UNPAUSE, the postponed batches are executed once the current one is done
+func (db *SequentialDB) unpause() {
+	if !db.paused.until.IsZero() {
+		db.paused.until = time.Now()
+	}
+}
+
+// pausing reports whether the command of client has to wait until the pause ends
+func (db *SequentialDB) pausing(client *connection.Connection, name string) bool {
+	if client == nil || db.paused.until.IsZero() {
+		return false
+	}
+	if db.paused.all {
+		return true
+	}
+	// like Redis, the commands which may change the dataset or be propagated are paused,
+	// in a transaction they are queued and EXEC is paused instead
+	if tx := db.txs[client]; tx != nil && tx.multi {
+		return name == "exec" && tx.writes
+	}
+	command, ok := cmdTable[name]
+	return ok && (command.flags&flagWrite != 0 || name == "publish" || name == "spublish")
+}
+
+// pauseCron ends the pause once it is over and executes the batches it postponed
+func (db *SequentialDB) pauseCron(now time.Time) {
+	if db.paused.until.IsZero() || now.Before(db.paused.until) {
+		return
+	}
+	postponed := db.paused.postponed
+	db.paused = clientPause{}
+	for _, cmd := range postponed {
+		db.execBatch(cmd)
+	}
+}
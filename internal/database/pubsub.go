@@ -0,0 +1,154 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/mirage208/redis-go/internal/cluster"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func names(args [][]byte) []string {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = string(arg)
+	}
+	return names
+}
+
+// checkSubscription validates the arguments of a subscription command, subscribe
+// commands need at least one name and unsubscribe commands none
+func checkSubscription(name string, client *connection.Connection, args [][]byte, minArgs int) resp.Reply {
+	if len(args) < minArgs {
+		return resp.MakeErrorReply("ERR wrong number of arguments for '" + name + "' command")
+	}
+	if client == nil {
+		return resp.MakeErrorReply("ERR " + strings.ToUpper(name) + " requires a client connection")
+	}
+	return nil
+}
+
+func subscribeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if errReply := checkSubscription("subscribe", client, args, 1); errReply != nil {
+		return errReply
+	}
+	return db.hub.Subscribe(client, names(args))
+}
+
+func unsubscribeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if errReply := checkSubscription("unsubscribe", client, args, 0); errReply != nil {
+		return errReply
+	}
+	return db.hub.Unsubscribe(client, names(args))
+}
+
+func psubscribeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if errReply := checkSubscription("psubscribe", client, args, 1); errReply != nil {
+		return errReply
+	}
+	return db.hub.PSubscribe(client, names(args))
+}
+
+func punsubscribeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if errReply := checkSubscription("punsubscribe", client, args, 0); errReply != nil {
+		return errReply
+	}
+	return db.hub.PUnsubscribe(client, names(args))
+}
+
+// sameSlot checks that shard channels hash to one slot, the slot served by a single node in a cluster
+func sameSlot(channels [][]byte) resp.Reply {
+	for _, channel := range channels[min(1, len(channels)):] {
+		if cluster.KeySlot(string(channel)) != cluster.KeySlot(string(channels[0])) {
+			return resp.MakeErrorReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	return nil
+}
+
+func ssubscribeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if errReply := checkSubscription("ssubscribe", client, args, 1); errReply != nil {
+		return errReply
+	}
+	if errReply := sameSlot(args); errReply != nil {
+		return errReply
+	}
+	return db.hub.SSubscribe(client, names(args))
+}
+
+func sunsubscribeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if errReply := checkSubscription("sunsubscribe", client, args, 0); errReply != nil {
+		return errReply
+	}
+	if errReply := sameSlot(args); errReply != nil {
+		return errReply
+	}
+	return db.hub.SUnsubscribe(client, names(args))
+}
+
+func publishExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'publish' command")
+	}
+	return resp.MakeIntegerReply(int64(db.hub.Publish(string(args[0]), args[1])))
+}
+
+func spublishExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'spublish' command")
+	}
+	return resp.MakeIntegerReply(int64(db.hub.SPublish(string(args[0]), args[1])))
+}
+
+// pubsubExecuter implements PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...], PUBSUB NUMPAT,
+// PUBSUB SHARDCHANNELS [pattern] and PUBSUB SHARDNUMSUB [channel ...]
+func pubsubExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'pubsub' command")
+	}
+	subcommand := strings.ToLower(string(args[0]))
+	switch {
+	case (subcommand == "channels" || subcommand == "shardchannels") && len(args) <= 2:
+		pattern := ""
+		if len(args) == 2 {
+			pattern = string(args[1])
+		}
+		if subcommand == "shardchannels" {
+			return stringsReply(db.hub.ShardChannels(pattern))
+		}
+		return stringsReply(db.hub.Channels(pattern))
+	case subcommand == "numsub" || subcommand == "shardnumsub":
+		numSub := db.hub.NumSub
+		if subcommand == "shardnumsub" {
+			numSub = db.hub.ShardNumSub
+		}
+		counts := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			counts = append(counts, resp.MakeBulkReply(channel), resp.MakeIntegerReply(int64(numSub(string(channel)))))
+		}
+		return resp.MakeArrayReply(counts)
+	case subcommand == "numpat" && len(args) == 1:
+		return resp.MakeIntegerReply(int64(db.hub.NumPat()))
+	}
+	return resp.MakeErrorReply("ERR unknown subcommand or wrong number of arguments for '" + string(args[0]) + "'. Try PUBSUB HELP.")
+}
+
+func stringsReply(values []string) resp.Reply {
+	args := make([][]byte, len(values))
+	for i, value := range values {
+		args[i] = []byte(value)
+	}
+	return resp.MakeMultiBulkReply(args)
+}
+
+func init() {
+	registerCommand("subscribe", subscribeExecuter, flagSubscribed, noKeys, "pubsub", "slow")
+	registerCommand("unsubscribe", unsubscribeExecuter, flagSubscribed, noKeys, "pubsub", "slow")
+	registerCommand("psubscribe", psubscribeExecuter, flagSubscribed, noKeys, "pubsub", "slow")
+	registerCommand("punsubscribe", punsubscribeExecuter, flagSubscribed, noKeys, "pubsub", "slow")
+	registerCommand("ssubscribe", ssubscribeExecuter, flagSubscribed, noKeys, "pubsub", "slow")
+	registerCommand("sunsubscribe", sunsubscribeExecuter, flagSubscribed, noKeys, "pubsub", "slow")
+	registerCommand("publish", publishExecuter, 0, noKeys, "pubsub", "fast")
+	registerCommand("spublish", spublishExecuter, 0, noKeys, "pubsub", "fast")
+	registerCommand("pubsub", pubsubExecuter, 0, noKeys, "pubsub", "slow")
+}
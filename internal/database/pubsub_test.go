@@ -0,0 +1,188 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func pushed(client *connection.Connection) []string {
+	var messages []string
+	for _, r := range client.TakePushed() {
+		messages = append(messages, string(resp.ForProtocol(r, client.Protocol()).ToBytes()))
+	}
+	return messages
+}
+
+func TestPublish(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	subscriber := connection.NewConn(nil)
+	publisher := connection.NewConn(nil)
+
+	want := "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n"
+	if got := execClient(db, subscriber, "subscribe", "news", "sport"); got != want {
+		t.Fatalf("unexpected confirmations %q", got)
+	}
+	execClient(db, subscriber, "psubscribe", "n*")
+	if got := execClient(db, publisher, "publish", "news", "hi"); got != ":2\r\n" {
+		t.Errorf("expected 2 receivers, got %q", got)
+	}
+	// the size of a message is measured once, it must match what the subscriber gets
+	if _, size := subscriber.PendingOutput(); size != int64(len("*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n")+
+		len("*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$2\r\nhi\r\n")) {
+		t.Errorf("unexpected pending output size %d", size)
+	}
+	messages := pushed(subscriber)
+	if len(messages) != 2 ||
+		messages[0] != "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n" ||
+		messages[1] != "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$2\r\nhi\r\n" {
+		t.Errorf("unexpected messages %q", messages)
+	}
+
+	if got := execClient(db, publisher, "pubsub", "numsub", "news", "none"); got != "*4\r\n$4\r\nnews\r\n:1\r\n$4\r\nnone\r\n:0\r\n" {
+		t.Errorf("unexpected PUBSUB NUMSUB %q", got)
+	}
+	if got := execClient(db, publisher, "pubsub", "channels", "s*"); got != "*1\r\n$5\r\nsport\r\n" {
+		t.Errorf("unexpected PUBSUB CHANNELS %q", got)
+	}
+
+	db.AfterClientClose(subscriber)
+	if got := execClient(db, publisher, "publish", "news", "hi"); got != ":0\r\n" {
+		t.Errorf("expected no receiver once the subscriber closed, got %q", got)
+	}
+	if got := execClient(db, publisher, "pubsub", "numpat"); got != ":0\r\n" {
+		t.Errorf("expected no pattern once the subscriber closed, got %q", got)
+	}
+}
+
+func TestSubscribedMode(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+
+	execClient(db, client, "subscribe", "news")
+	if got := execClient(db, client, "get", "k"); got[0] != '-' {
+		t.Errorf("expected GET to be refused in subscribed mode, got %q", got)
+	}
+	if got := execClient(db, client, "ping"); got != "*2\r\n$4\r\npong\r\n$0\r\n\r\n" {
+		t.Errorf("unexpected PING reply in subscribed mode %q", got)
+	}
+	if got := execClient(db, client, "unsubscribe"); got != "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:0\r\n" {
+		t.Errorf("unexpected UNSUBSCRIBE reply %q", got)
+	}
+	if got := execClient(db, client, "get", "k"); got != "$-1\r\n" {
+		t.Errorf("expected GET once unsubscribed, got %q", got)
+	}
+
+	// RESP3 clients may run any command while subscribed
+	execClient(db, client, "hello", "3")
+	execClient(db, client, "subscribe", "news")
+	if got := execClient(db, client, "get", "k"); got != "_\r\n" {
+		t.Errorf("expected GET over RESP3 in subscribed mode, got %q", got)
+	}
+}
+
+func TestShardedPubSub(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	subscriber := connection.NewConn(nil)
+	publisher := connection.NewConn(nil)
+
+	if got := execClient(db, subscriber, "ssubscribe", "a", "b"); got[0] != '-' {
+		t.Errorf("expected channels of different slots to be refused, got %q", got)
+	}
+	want := "*3\r\n$10\r\nssubscribe\r\n$8\r\n{u1}.inv\r\n:1\r\n*3\r\n$10\r\nssubscribe\r\n$9\r\n{u1}.cart\r\n:2\r\n"
+	if got := execClient(db, subscriber, "ssubscribe", "{u1}.inv", "{u1}.cart"); got != want {
+		t.Fatalf("unexpected confirmations %q", got)
+	}
+	// shard subscriptions are not counted with the others
+	if got := execClient(db, subscriber, "subscribe", "{u1}.inv"); got != "*3\r\n$9\r\nsubscribe\r\n$8\r\n{u1}.inv\r\n:1\r\n" {
+		t.Errorf("unexpected confirmation %q", got)
+	}
+	execClient(db, subscriber, "psubscribe", "*")
+
+	if got := execClient(db, publisher, "spublish", "{u1}.inv", "hi"); got != ":1\r\n" {
+		t.Errorf("expected 1 receiver, got %q", got)
+	}
+	if messages := pushed(subscriber); len(messages) != 1 || messages[0] != "*3\r\n$8\r\nsmessage\r\n$8\r\n{u1}.inv\r\n$2\r\nhi\r\n" {
+		t.Errorf("unexpected messages %q", messages)
+	}
+	if got := execClient(db, publisher, "pubsub", "shardchannels"); got != "*2\r\n$9\r\n{u1}.cart\r\n$8\r\n{u1}.inv\r\n" {
+		t.Errorf("unexpected PUBSUB SHARDCHANNELS %q", got)
+	}
+	if got := execClient(db, publisher, "pubsub", "shardnumsub", "{u1}.inv"); got != "*2\r\n$8\r\n{u1}.inv\r\n:1\r\n" {
+		t.Errorf("unexpected PUBSUB SHARDNUMSUB %q", got)
+	}
+
+	execClient(db, subscriber, "sunsubscribe")
+	if got := execClient(db, publisher, "spublish", "{u1}.inv", "hi"); got != ":0\r\n" {
+		t.Errorf("expected no receiver once unsubscribed, got %q", got)
+	}
+	if got := execClient(db, publisher, "publish", "{u1}.inv", "hi"); got != ":2\r\n" {
+		t.Errorf("expected the channel and pattern subscriptions to remain, got %q", got)
+	}
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), ClientOutputBufferLimit: "pubsub 100 50 60"})
+	defer db.Close()
+	subscriber := connection.NewConn(nil)
+	publisher := connection.NewConn(nil)
+	execClient(db, subscriber, "subscribe", "news")
+
+	// the soft limit is exceeded, but not for long enough
+	execClient(db, publisher, "publish", "news", strings.Repeat("x", 40))
+	if subscriber.Overflowed() {
+		t.Fatal("expected the subscriber to stay below the limits")
+	}
+	if list := execClient(db, publisher, "client", "list", "type", "pubsub"); !strings.Contains(list, " oll=1 omem=") {
+		t.Errorf("expected the pending message in CLIENT LIST, got %q", list)
+	}
+	execClient(db, publisher, "publish", "news", strings.Repeat("x", 40))
+	if !subscriber.Overflowed() {
+		t.Fatal("expected the subscriber to be disconnected over the hard limit")
+	}
+	if got := execClient(db, publisher, "publish", "news", "hi"); got != ":1\r\n" {
+		t.Errorf("expected the subscriber to be counted until it is closed, got %q", got)
+	}
+	if messages := pushed(subscriber); len(messages) != 0 {
+		t.Errorf("expected the queue to be dropped once disconnected, got %d messages", len(messages))
+	}
+	if list := execClient(db, publisher, "client", "list", "type", "pubsub"); !strings.Contains(list, " flags=PA ") {
+		t.Errorf("expected the A flag in CLIENT LIST, got %q", list)
+	}
+	if info := execClient(db, publisher, "info", "stats"); !strings.Contains(info, "client_output_buffer_limit_disconnections:1\r\n") {
+		t.Errorf("expected the disconnection in INFO, got %q", info)
+	}
+}
+
+func TestSoftOutputLimitCron(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), ClientOutputBufferLimit: "pubsub 0 50 1"})
+	defer db.Close()
+	stuck := connection.NewConn(nil)
+	reading := connection.NewConn(nil)
+	publisher := connection.NewConn(nil)
+	execClient(db, stuck, "subscribe", "news")
+	execClient(db, reading, "subscribe", "news")
+	pushed(stuck)
+	pushed(reading)
+
+	execClient(db, publisher, "publish", "news", strings.Repeat("x", 60))
+	if stuck.Overflowed() || reading.Overflowed() {
+		t.Fatal("expected the subscribers to stay connected below the soft duration")
+	}
+	// one drains its output, the other is over the soft limit with nothing more pushed
+	pushed(reading)
+	onCommandGoroutine(db, func() { db.clientsCron(time.Now().Add(2 * time.Second)) })
+	if !stuck.Overflowed() {
+		t.Error("expected the cron to disconnect the client over its soft limit for too long")
+	}
+	if reading.Overflowed() {
+		t.Error("expected the client which read its output to stay connected")
+	}
+}
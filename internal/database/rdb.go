@@ -0,0 +1,224 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/persister"
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+const errRDBMisconf = "MISCONF Redis is configured to save RDB snapshots, but it's currently unable to persist to disk. " +
+	"Commands that may modify the data set are disabled, because this instance is configured to report errors during writes " +
+	"if RDB snapshotting fails (stop-writes-on-bgsave-error option). Please check the Redis logs for details about the RDB error."
+
+// bgsaveRetryDelay is the minimum wait before an automatic BGSAVE is retried after a failure
+const bgsaveRetryDelay = 5 * time.Second
+
+// rdbState tracks snapshot progress, it is only accessed by the command goroutine
+type rdbState struct {
+	saveParams         []config.SaveParam
+	lastSave           time.Time
+	bgsaveInProgress   bool
+	bgsaveJob          *snapshotJob
+	bgsaveStart        time.Time
+	lastBgsaveTry      time.Time
+	lastBgsaveDuration time.Duration
+	lastBgsaveErr      error
+	dirtyBeforeBgsave  int64
+	bgsaveDone         chan error
+}
+
+func newRDBState(saveParams []config.SaveParam) *rdbState {
+	return &rdbState{
+		saveParams:         saveParams,
+		lastSave:           time.Now(),
+		lastBgsaveDuration: -1,
+		bgsaveDone:         make(chan error, 1),
+	}
+}
+
+// loadRDB restores the snapshot file, if any, before the DB starts serving
+func (db *SequentialDB) loadRDB() {
+	filename := db.cfg.RDBPath()
+	start := time.Now()
+	err := persister.LoadRDBFile(filename, db.cache)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logger.Errorf("failed to load RDB file %s: %v", filename, err)
+		return
+	}
+	logger.Infof("DB loaded from disk: %.3f seconds", time.Since(start).Seconds())
+}
+
+// rdbSave writes a snapshot synchronously, blocking the command goroutine
+func (db *SequentialDB) rdbSave() error {
+	err := persister.SaveRDBFile(db.cfg.RDBPath(), func(w io.Writer) error {
+		return persister.WriteRDB(w, db.cache)
+	})
+	// a failed SAVE counts like a failed BGSAVE, for rdb_last_bgsave_status and stop-writes-on-bgsave-error
+	db.rdb.lastBgsaveErr = err
+	if err != nil {
+		logger.Warnf("failed to save RDB file: %v", err)
+		return err
+	}
+	db.dirty = 0
+	db.rdb.lastSave = time.Now()
+	logger.Info("DB saved on disk")
+	return nil
+}
+
+// rdbBgsave starts an incremental snapshot whose chunks are written to file by a background goroutine
+func (db *SequentialDB) rdbBgsave() error {
+	if db.snapshot != nil {
+		return errors.New("another snapshot is in progress")
+	}
+	job := db.startSnapshot()
+	db.rdb.bgsaveInProgress = true
+	db.rdb.bgsaveJob = job
+	db.rdb.bgsaveStart = time.Now()
+	db.rdb.lastBgsaveTry = db.rdb.bgsaveStart
+	db.rdb.dirtyBeforeBgsave = db.dirty
+	filename := db.cfg.RDBPath()
+	go func() {
+		db.rdb.bgsaveDone <- persister.SaveRDBFile(filename, func(w io.Writer) error {
+			var err error
+			for chunk := range job.chunks {
+				if err == nil {
+					_, err = w.Write(chunk)
+				}
+			}
+			if err == nil {
+				err = job.err
+			}
+			return err
+		})
+	}()
+	logger.Info("Background saving started")
+	return nil
+}
+
+// bgsaveFinished is called on the command goroutine once a background save returns
+func (db *SequentialDB) bgsaveFinished(err error) {
+	db.rdb.bgsaveInProgress = false
+	db.rdb.lastBgsaveDuration = time.Since(db.rdb.bgsaveStart)
+	db.rdb.lastBgsaveErr = err
+	job := db.rdb.bgsaveJob
+	db.rdb.bgsaveJob = nil
+	if err != nil {
+		// the save may fail before reading any chunk, such as when the temp file cannot
+		// be created, the job would then stay installed and block every later snapshot
+		if db.snapshot == job {
+			db.abortSnapshot()
+		}
+		logger.Warnf("background saving error: %v", err)
+		return
+	}
+	db.dirty -= db.rdb.dirtyBeforeBgsave
+	db.rdb.lastSave = time.Now()
+	logger.Info("Background saving terminated with success")
+}
+
+// rdbWriteError returns the error of the last save while it makes the DB refuse writes:
+// snapshots are enabled with save rules and stop-writes-on-bgsave-error is set
+func (db *SequentialDB) rdbWriteError() error {
+	if len(db.rdb.saveParams) == 0 || !db.cfg.StopWritesOnBgsaveErr() {
+		return nil
+	}
+	return db.rdb.lastBgsaveErr
+}
+
+// rdbCron starts a BGSAVE once any save rule is satisfied
+func (db *SequentialDB) rdbCron(now time.Time) {
+	if db.rdb.bgsaveInProgress {
+		return
+	}
+	for _, param := range db.rdb.saveParams {
+		if db.dirty < int64(param.Changes) || now.Sub(db.rdb.lastSave) <= time.Duration(param.Seconds)*time.Second {
+			continue
+		}
+		if db.rdb.lastBgsaveErr != nil && now.Sub(db.rdb.lastBgsaveTry) <= bgsaveRetryDelay {
+			continue
+		}
+		logger.Infof("%d changes in %d seconds. Saving...", param.Changes, param.Seconds)
+		if err := db.rdbBgsave(); err != nil {
+			db.rdb.lastBgsaveTry = now
+			db.rdb.lastBgsaveErr = err
+			logger.Warnf("failed to start background saving: %v", err)
+		}
+		return
+	}
+}
+
+// persistenceInfo generates the persistence section of INFO
+func (db *SequentialDB) persistenceInfo() []string {
+	status := "ok"
+	if db.rdb.lastBgsaveErr != nil {
+		status = "err"
+	}
+	current := int64(-1)
+	if db.rdb.bgsaveInProgress {
+		current = int64(time.Since(db.rdb.bgsaveStart).Seconds())
+	}
+	last := int64(-1)
+	if db.rdb.lastBgsaveDuration >= 0 {
+		last = int64(db.rdb.lastBgsaveDuration.Seconds())
+	}
+	lines := []string{
+		"loading:0",
+		"rdb_changes_since_last_save:" + strconv.FormatInt(db.dirty, 10),
+		"rdb_bgsave_in_progress:" + boolInfo(db.rdb.bgsaveInProgress),
+		"rdb_last_save_time:" + strconv.FormatInt(db.rdb.lastSave.Unix(), 10),
+		"rdb_last_bgsave_status:" + status,
+		"rdb_last_bgsave_time_sec:" + strconv.FormatInt(last, 10),
+		"rdb_current_bgsave_time_sec:" + strconv.FormatInt(current, 10),
+	}
+	return append(lines, db.aofInfo()...)
+}
+
+func saveExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'save' command")
+	}
+	if db.rdb.bgsaveInProgress {
+		return resp.MakeErrorReply("ERR Background save already in progress")
+	}
+	if err := db.rdbSave(); err != nil {
+		return resp.MakeErrorReply("ERR " + err.Error())
+	}
+	return resp.MakeOkReply()
+}
+
+func bgsaveExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'bgsave' command")
+	}
+	if db.rdb.bgsaveInProgress {
+		return resp.MakeErrorReply("ERR Background save already in progress")
+	}
+	if err := db.rdbBgsave(); err != nil {
+		return resp.MakeErrorReply("ERR " + err.Error())
+	}
+	return resp.MakeStatusReply("Background saving started")
+}
+
+func lastsaveExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'lastsave' command")
+	}
+	return resp.MakeIntegerReply(db.rdb.lastSave.Unix())
+}
+
+func init() {
+	registerCommand("save", saveExecuter, flagAdmin, noKeys, "slow")
+	registerCommand("bgsave", bgsaveExecuter, flagAdmin, noKeys, "slow")
+	registerCommand("lastsave", lastsaveExecuter, 0, noKeys, "admin", "fast", "dangerous")
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// onCommandGoroutine runs f on the command goroutine of db, like the work of the master link
+func onCommandGoroutine(db *SequentialDB, f func()) {
+	done := make(chan struct{})
+	db.linkCh <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// persistenceInfo returns the persistence section of INFO
+func persistenceInfo(db *SequentialDB) string {
+	return string(execLine(db, "info", "persistence").(*resp.BulkReply).Arg)
+}
+
+func expectInfo(t *testing.T, info string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !bytes.Contains([]byte(info), []byte(line+"\r\n")) {
+			t.Errorf("expected %s in INFO:\n%s", line, info)
+		}
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), Save: "60 2"})
+	defer db.Close()
+	execLine(db, "set", "a", "1")
+
+	// a rule needs both its changes and its seconds
+	started := func(now time.Time) (started bool) {
+		onCommandGoroutine(db, func() {
+			db.rdbCron(now)
+			started = db.rdb.bgsaveInProgress
+		})
+		return started
+	}
+	if started(time.Now().Add(61 * time.Second)) {
+		t.Fatal("expected no BGSAVE before 2 changes")
+	}
+	execLine(db, "set", "b", "2")
+	if started(time.Now()) {
+		t.Fatal("expected no BGSAVE before 60 seconds")
+	}
+	expectInfo(t, persistenceInfo(db), "rdb_changes_since_last_save:2", "rdb_last_bgsave_time_sec:-1")
+	if !started(time.Now().Add(61 * time.Second)) {
+		t.Fatal("expected the rule to start a BGSAVE")
+	}
+	waitBgsave(t, db)
+	expectInfo(t, persistenceInfo(db), "rdb_changes_since_last_save:0", "rdb_last_bgsave_status:ok",
+		"rdb_last_bgsave_time_sec:0", "rdb_current_bgsave_time_sec:-1")
+	if _, err := os.Stat(db.cfg.RDBPath()); err != nil {
+		t.Errorf("expected the snapshot on disk: %v", err)
+	}
+
+	// the changes made while the snapshot is saved are still to be saved
+	onCommandGoroutine(db, func() {
+		if err := db.rdbBgsave(); err != nil {
+			t.Error(err)
+		}
+		db.call(nil, "set", [][]byte{[]byte("c"), []byte("3")})
+	})
+	waitBgsave(t, db)
+	expectInfo(t, persistenceInfo(db), "rdb_changes_since_last_save:1")
+}
+
+func TestSaveRulesRetry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	db := NewSequentialDB(&config.ServerProperties{Dir: dir, Save: "60 1"})
+	defer db.Close()
+	execLine(db, "set", "a", "1")
+	started := func(now time.Time) (started bool) {
+		onCommandGoroutine(db, func() {
+			db.rdbCron(now)
+			started = db.rdb.bgsaveInProgress
+		})
+		return started
+	}
+
+	// the last save is old enough for the rule, the snapshot cannot be written
+	var first bool
+	onCommandGoroutine(db, func() {
+		db.rdb.lastSave = time.Now().Add(-2 * time.Minute)
+		db.rdbCron(time.Now())
+		first = db.rdb.bgsaveInProgress
+	})
+	if !first {
+		t.Fatal("expected the rule to start a BGSAVE")
+	}
+	waitBgsave(t, db)
+	expectInfo(t, persistenceInfo(db), "rdb_last_bgsave_status:err", "rdb_changes_since_last_save:1")
+
+	// a failed BGSAVE is retried once bgsaveRetryDelay elapsed
+	if started(time.Now()) {
+		t.Fatal("expected no retry before the retry delay")
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if !started(time.Now().Add(bgsaveRetryDelay + time.Second)) {
+		t.Fatal("expected a retry after the retry delay")
+	}
+	waitBgsave(t, db)
+	expectInfo(t, persistenceInfo(db), "rdb_last_bgsave_status:ok", "rdb_changes_since_last_save:0")
+}
+
+func TestMisconfOnSaveError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	db := NewSequentialDB(&config.ServerProperties{Dir: dir, Save: "60 1"})
+	defer db.Close()
+	execLine(db, "set", "a", "1")
+
+	if got, ok := execLine(db, "save").(*resp.ErrorReply); !ok {
+		t.Fatalf("expected SAVE to fail, got %q", got.ToBytes())
+	}
+	if got := string(execLine(db, "set", "a", "2").ToBytes()); !strings.HasPrefix(got, "-MISCONF Redis is configured to save RDB snapshots") {
+		t.Errorf("expected writes to be refused, got %q", got)
+	}
+	if got := string(execLine(db, "get", "a").ToBytes()); got != "$1\r\n1\r\n" {
+		t.Errorf("expected reads to be served, got %q", got)
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	execLine(db, "bgsave")
+	waitBgsave(t, db)
+	if got := string(execLine(db, "set", "a", "3").ToBytes()); got != "+OK\r\n" {
+		t.Errorf("expected writes once a save succeeded, got %q", got)
+	}
+}
+
+func TestStopWritesOnBgsaveErrorDisabled(t *testing.T) {
+	for name, cfg := range map[string]*config.ServerProperties{
+		"no save rule": {Save: ""},
+		"option off":   {Save: "60 1", StopWritesOnBgsaveError: "no"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Dir = filepath.Join(t.TempDir(), "missing")
+			db := NewSequentialDB(cfg)
+			defer db.Close()
+			execLine(db, "save")
+			if got := string(execLine(db, "set", "a", "1").ToBytes()); got != "+OK\r\n" {
+				t.Errorf("expected writes after a failed save, got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/persister"
+	"github.com/mirage208/redis-go/internal/replication"
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+// A replica follows its master from a link goroutine: it connects, asks for the stream
+// with PSYNC, loads the snapshot of a full resync and then receives the write commands.
+// Whatever changes the DB is handed over to the command goroutine through linkCh.
+
+// replReconnectDelay is the wait before a replica connects again to its master
+const replReconnectDelay = time.Second
+
+// replAckPeriod is how often a replica acknowledges the offset it processed
+const replAckPeriod = time.Second
+
+// linkState is the state of the link of a replica to its master
+type linkState = int32
+
+const (
+	linkConnecting linkState = iota
+	linkHandshake
+	linkSync // receiving the snapshot
+	linkConnected
+)
+
+var linkStateNames = [...]string{"connecting", "handshake", "sync", "connected"}
+
+// masterLink is the link of a replica to its master
+type masterLink struct {
+	host string
+	port int
+	stop chan struct{} // closed once the server no longer replicates this master
+
+	state  atomic.Int32
+	offset atomic.Int64 // offset processed by the replica, acknowledged to the master
+	lastIO time.Time    // when the master was last heard of, only accessed by the command goroutine
+}
+
+func (link *masterLink) addr() string {
+	return net.JoinHostPort(link.host, strconv.Itoa(link.port))
+}
+
+// receivedSnapshot is the snapshot of a full resync, loaded by the link goroutine
+type receivedSnapshot struct {
+	cache   *kvcache.KVCache
+	replID  string
+	offset  int64
+	rdbFile string // temp file holding the snapshot, which becomes the RDB file
+	aofFile string // temp file holding the snapshot as the preamble of the AOF, empty without AOF
+}
+
+// discard removes the temp files of a snapshot which is not used
+func (s *receivedSnapshot) discard() {
+	_ = os.Remove(s.rdbFile)
+	if s.aofFile != "" {
+		_ = os.Remove(s.aofFile)
+	}
+}
+
+// replicaOf makes the server a replica of host:port. Its own replicas are disconnected,
+// they resynchronize once the history of the server changed.
+func (db *SequentialDB) replicaOf(host string, port int) {
+	db.stopMasterLink()
+	link := &masterLink{host: host, port: port, stop: make(chan struct{})}
+	db.repl.master = link
+	db.disconnectReplicas()
+	logger.Infof("Connecting to MASTER %s", link.addr())
+	go db.runMasterLink(link)
+}
+
+// promote makes a replica a master. The stream it got from its master goes on with a new
+// replication ID, the replicas which followed the same master can continue with the old one.
+func (db *SequentialDB) promote() {
+	db.stopMasterLink()
+	db.repl.id2, db.repl.offset2 = db.repl.id, db.replOffset()+1
+	db.repl.id = newReplID()
+	db.disconnectReplicas()
+	logger.Info("MASTER MODE enabled")
+}
+
+func (db *SequentialDB) stopMasterLink() {
+	if db.repl.master == nil {
+		return
+	}
+	close(db.repl.master.stop)
+	db.repl.master = nil
+}
+
+// runMasterLink keeps the replica in sync with its master until the link is stopped
+func (db *SequentialDB) runMasterLink(link *masterLink) {
+	for {
+		err := db.syncWithMaster(link)
+		select {
+		case <-link.stop:
+			return
+		case <-db.stopped:
+			return
+		default:
+		}
+		logger.Warnf("Connection with MASTER %s lost: %v", link.addr(), err)
+		select {
+		case <-link.stop:
+			return
+		case <-db.stopped:
+			return
+		case <-time.After(replReconnectDelay):
+		}
+	}
+}
+
+// syncWithMaster connects to the master, resynchronizes and applies the stream until the
+// connection fails or the link is stopped
+func (db *SequentialDB) syncWithMaster(link *masterLink) error {
+	link.state.Store(linkConnecting)
+	l, err := replication.Dial(link.addr(), db.repl.timeout, db.cfg.ProtoLimits())
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// closing the connection interrupts the reads of the link goroutine
+		select {
+		case <-link.stop:
+		case <-db.stopped:
+		case <-done:
+		}
+		_ = l.Close()
+	}()
+
+	link.state.Store(linkHandshake)
+	if err := l.Handshake(db.cfg.MasterUser, db.cfg.MasterAuth, db.cfg.Port); err != nil {
+		return err
+	}
+	var replID string
+	var offset int64
+	if !db.linkDo(link, func() {
+		if db.repl.backlog != nil {
+			replID, offset = db.repl.id, db.replOffset()+1
+		}
+	}) {
+		return errors.New("link stopped")
+	}
+	sync, err := l.Psync(replID, offset)
+	if err != nil {
+		return err
+	}
+
+	if sync.Full {
+		logger.Infof("Full resync from master: %s:%d", sync.ReplID, sync.Offset)
+		link.state.Store(linkSync)
+		snapshot, err := db.receiveSnapshot(l)
+		if err != nil {
+			return err
+		}
+		snapshot.replID, snapshot.offset = sync.ReplID, sync.Offset
+		loaded := false
+		db.linkDo(link, func() {
+			loaded = db.fullSyncLoaded(link, snapshot)
+		})
+		if !loaded {
+			snapshot.discard()
+			return errors.New("link stopped")
+		}
+	} else {
+		logger.Info("Successful partial resynchronization with master.")
+		if !db.linkDo(link, func() { db.partialSyncAccepted(link, sync.ReplID) }) {
+			return errors.New("link stopped")
+		}
+	}
+	link.state.Store(linkConnected)
+	logger.Info("MASTER <-> REPLICA sync: Finished with success")
+
+	go func() {
+		ticker := time.NewTicker(replAckPeriod)
+		defer ticker.Stop()
+		for {
+			if err := l.Ack(link.offset.Load()); err != nil {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for payload := range l.Commands() {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		cmd, ok := payload.Data.(*resp.MultiBulkReply)
+		if !ok || len(cmd.Args) == 0 {
+			return errors.New("unexpected data in the replication stream")
+		}
+		if !db.linkPost(link, func() { db.applyMasterCommand(link, cmd.Args) }) {
+			return errors.New("link stopped")
+		}
+	}
+	return io.EOF
+}
+
+// receiveSnapshot loads the snapshot of a full resync into a new keyspace, while it is
+// written to temp files which become the RDB file and the AOF of the replica
+func (db *SequentialDB) receiveSnapshot(l *replication.Link) (*receivedSnapshot, error) {
+	r, err := l.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &receivedSnapshot{cache: kvcache.NewKVCache()}
+	rdbFile, err := os.CreateTemp(filepath.Dir(db.cfg.RDBPath()), "temp-*.rdb")
+	if err != nil {
+		return nil, err
+	}
+	snapshot.rdbFile = rdbFile.Name()
+	files := []*os.File{rdbFile}
+	writers := []io.Writer{rdbFile}
+	if db.cfg.AppendOnly {
+		aofFile, err := os.CreateTemp(filepath.Dir(db.cfg.AOFPath()), "temp-*.aof")
+		if err != nil {
+			_ = rdbFile.Close()
+			snapshot.discard()
+			return nil, err
+		}
+		snapshot.aofFile = aofFile.Name()
+		files = append(files, aofFile)
+		writers = append(writers, aofFile)
+	}
+
+	tee := io.TeeReader(r, io.MultiWriter(writers...))
+	err = persister.ReadRDB(tee, snapshot.cache)
+	if err == nil {
+		// the decoder may stop short of the end, the files get every byte
+		_, err = io.Copy(io.Discard, tee)
+	}
+	for _, file := range files {
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		snapshot.discard()
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// linkDo runs f on the command goroutine and waits for it, false if the link or the DB stopped first
+func (db *SequentialDB) linkDo(link *masterLink, f func()) bool {
+	done := make(chan struct{})
+	if !db.linkPost(link, func() {
+		f()
+		close(done)
+	}) {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-db.stopped:
+		return false
+	}
+}
+
+// linkPost queues f to run on the command goroutine, false if the link or the DB stopped first
+func (db *SequentialDB) linkPost(link *masterLink, f func()) bool {
+	select {
+	case db.linkCh <- f:
+		return true
+	case <-link.stop:
+		return false
+	case <-db.stopped:
+		return false
+	}
+}
+
+// fullSyncLoaded replaces the dataset with the snapshot of a full resync, the stream then
+// continues from its offset. It returns false if the server no longer follows link.
+func (db *SequentialDB) fullSyncLoaded(link *masterLink, snapshot *receivedSnapshot) bool {
+	if db.repl.master != link {
+		return false
+	}
+	if db.snapshot != nil {
+		// a snapshot in progress would save the dataset being replaced
+		bgsave := db.rdb.bgsaveInProgress
+		db.abortSnapshot()
+		if bgsave {
+			db.bgsaveFinished(<-db.rdb.bgsaveDone)
+		}
+	}
+	if err := os.Rename(snapshot.rdbFile, db.cfg.RDBPath()); err != nil {
+		logger.Warnf("failed to save the snapshot from MASTER: %v", err)
+	}
+	if snapshot.aofFile != "" {
+		if db.persister != nil {
+			if err := db.persister.Close(); err != nil {
+				logger.Errorf("failed to close AOF: %v", err)
+			}
+			db.persister = nil
+			if err := os.Rename(snapshot.aofFile, db.cfg.AOFPath()); err != nil {
+				logger.Errorf("failed to start the AOF from the snapshot of MASTER: %v", err)
+			}
+			db.openAOF()
+		} else {
+			_ = os.Remove(snapshot.aofFile)
+		}
+	}
+	db.replaceDataset(snapshot.cache)
+	db.dirty = 0
+	db.rdb.lastSave = time.Now()
+
+	db.repl.id, db.repl.id2, db.repl.offset2 = snapshot.replID, "", -1
+	db.repl.backlog = replication.NewBacklog(db.repl.backlogSize, snapshot.offset)
+	db.disconnectReplicas()
+	link.offset.Store(snapshot.offset)
+	link.lastIO = time.Now()
+	logger.Infof("MASTER <-> REPLICA sync: Loaded %d keys", db.cache.Len())
+	return true
+}
+
+// partialSyncAccepted continues the stream after a partial resync. A master which got a
+// new replication ID, once promoted, continues the history of the previous one.
+func (db *SequentialDB) partialSyncAccepted(link *masterLink, replID string) {
+	if db.repl.master != link {
+		return
+	}
+	if replID != db.repl.id {
+		db.repl.id2, db.repl.offset2 = db.repl.id, db.replOffset()+1
+		db.repl.id = replID
+		// the replicas of this server learn about the new ID when they reconnect
+		db.disconnectReplicas()
+	}
+	link.offset.Store(db.replOffset())
+	link.lastIO = time.Now()
+}
+
+// replaceDataset swaps the keyspace, the clients tracking the keys of either are invalidated
+// and the transactions watching keys are aborted
+func (db *SequentialDB) replaceDataset(cache *kvcache.KVCache) {
+	db.touchAllWatchedKeys()
+	if !db.tracking.Empty() {
+		invalidate := func(key string, entity *kvcache.DataEntity, expiration *time.Time) bool {
+			db.tracking.Invalidate(key, nil)
+			return true
+		}
+		db.cache.ForEach(invalidate)
+		cache.ForEach(invalidate)
+	}
+	db.cache = cache
+	db.watchExpired()
+}
+
+// applyMasterCommand executes a command of the replication stream. Every command counts
+// for the offset and is fed to the replicas of this server, PINGs included.
+func (db *SequentialDB) applyMasterCommand(link *masterLink, cmdLine [][]byte) {
+	if db.repl.master != link || db.shuttingDown {
+		return
+	}
+	link.lastIO = time.Now()
+	name := strings.ToLower(string(cmdLine[0]))
+	if command, ok := cmdTable[name]; ok && command.flags&flagWrite != 0 {
+		dirty := db.dirty
+		command.executer(db, nil, cmdLine[1:])
+		if db.dirty != dirty {
+			if !db.tracking.Empty() || len(db.watchedKeys) > 0 {
+				for _, key := range command.keys.extract(cmdLine[1:]) {
+					db.signalModifiedKey(key, nil)
+				}
+			}
+			if db.persister != nil {
+				db.persister.Append(cmdLine)
+			}
+		}
+	}
+	db.replicationFeed(cmdLine)
+	link.offset.Store(db.replOffset())
+}
+
+// replicaofExecuter implements REPLICAOF host port and REPLICAOF NO ONE
+func replicaofExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'replicaof' command")
+	}
+	if strings.EqualFold(string(args[0]), "no") && strings.EqualFold(string(args[1]), "one") {
+		if db.repl.master != nil {
+			db.promote()
+		}
+		return resp.MakeOkReply()
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port < 0 || port > 65535 {
+		return resp.MakeErrorReply("ERR Invalid master port")
+	}
+	host := string(args[0])
+	if master := db.repl.master; master != nil && master.host == host && master.port == port {
+		return resp.MakeStatusReply("OK Already connected to specified master")
+	}
+	db.replicaOf(host, port)
+	return resp.MakeOkReply()
+}
+
+// replicaOfConfig applies the replicaof directive, "<host> <port>"
+func (db *SequentialDB) replicaOfConfig() {
+	if db.cfg.ReplicaOf == "" {
+		return
+	}
+	fields := strings.Fields(db.cfg.ReplicaOf)
+	if len(fields) == 2 {
+		if port, err := strconv.Atoi(fields[1]); err == nil && port >= 0 && port <= 65535 {
+			db.replicaOf(fields[0], port)
+			return
+		}
+	}
+	logger.Warnf("invalid replicaof: %s", db.cfg.ReplicaOf)
+}
+
+func init() {
+	registerCommand("replicaof", replicaofExecuter, flagAdmin, noKeys, "slow")
+	registerCommand("slaveof", replicaofExecuter, flagAdmin, noKeys, "slow")
+}
@@ -0,0 +1,582 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mirage208/redis-go/common/utils"
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/replication"
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+// replPingPeriod is how often a master pings its replicas through the stream, so that
+// they can tell a quiet master from a broken link
+const replPingPeriod = 10 * time.Second
+
+// replKeepalivePeriod is how often a newline is sent to the replicas waiting for the
+// snapshot of their full resync, which pings cannot reach before the snapshot
+const replKeepalivePeriod = time.Second
+
+// defaultReplTimeout applies unless repl-timeout is set
+const defaultReplTimeout = 60 * time.Second
+
+// replState is the replication state of the server, as a master of its replicas and as a
+// replica of its master. It is only accessed by the command goroutine.
+type replState struct {
+	id      string // replication ID of the stream held by this server
+	id2     string // ID of the stream before the last change of master, empty if none
+	offset2 int64  // id2 is valid for the offsets before it, see second_repl_offset
+	timeout time.Duration
+
+	backlogSize int
+	// backlog is created once the first replica connects, or the first sync with a master
+	backlog *replication.Backlog
+
+	replicas map[*connection.Connection]*replicaInfo
+	waiting  []*connection.Connection // full resyncs waiting for a snapshot to start
+	syncing  []*connection.Connection // full resyncs waiting for the snapshot in progress
+	syncDone chan syncResult
+	lastPing time.Time
+	// the last newline sent to the replicas waiting for a snapshot
+	lastKeepalive time.Time
+
+	// resynchronizations served, see INFO stats
+	syncFull, syncPartialOK, syncPartialErr int64
+
+	master *masterLink // nil unless this server is a replica
+}
+
+// replicaState is how far a replica got into the synchronization
+type replicaState int
+
+const (
+	replicaHandshake    replicaState = iota // sent REPLCONF, not PSYNC yet
+	replicaWaitSnapshot                     // waiting for a snapshot to start
+	replicaSendSnapshot                     // waiting for the snapshot, the stream is queued behind it meanwhile
+	replicaOnline                           // receives the stream
+)
+
+var replicaStateNames = [...]string{"handshake", "wait_bgsave", "send_bulk", "online"}
+
+// replicaInfo is what a master knows of a replica
+type replicaInfo struct {
+	state     replicaState
+	port      int // announced with REPLCONF listening-port
+	ackOffset int64
+	lastAck   time.Time
+	// the snapshot of the last full resync, pushed before the stream fed while it is prepared
+	snapshot *snapshotReply
+}
+
+// syncResult is the snapshot of a full resync, file is nil if it failed
+type syncResult struct {
+	file *os.File
+	size int64
+	err  error
+}
+
+// snapshotReply is the snapshot a replica receives after FULLRESYNC. It is pushed right
+// away and becomes ready once the snapshot is complete, then it is streamed from the
+// file to the socket. Like in Redis, it does not count for the output limits, only the
+// stream queued behind it does.
+type snapshotReply struct {
+	file atomic.Pointer[snapshotFile]
+	done atomic.Bool // the file was written or dropped, and released
+}
+
+// snapshotFile is shared by the replicas of a full resync, it is closed once every one
+// of them is done with it
+type snapshotFile struct {
+	file *os.File
+	size int64
+	refs atomic.Int32
+}
+
+var errSnapshotSent = errors.New("snapshot already sent")
+
+func (f *snapshotFile) release() {
+	if f.refs.Add(-1) == 0 {
+		_ = f.file.Close()
+	}
+}
+
+func (r *snapshotReply) attach(f *snapshotFile) {
+	f.refs.Add(1)
+	r.file.Store(f)
+}
+
+// drop releases the file unless it was written already
+func (r *snapshotReply) drop() {
+	if f := r.file.Load(); f != nil && !r.done.Swap(true) {
+		f.release()
+	}
+}
+
+func (r *snapshotReply) Ready() bool {
+	return r.file.Load() != nil
+}
+
+func (r *snapshotReply) WriteTo(w io.Writer) (int64, error) {
+	f := r.file.Load()
+	if f == nil || r.done.Swap(true) {
+		return 0, errSnapshotSent
+	}
+	defer f.release()
+	n, err := io.WriteString(w, "$"+strconv.FormatInt(f.size, 10)+resp.CRLF)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := io.Copy(w, io.NewSectionReader(f.file, 0, f.size))
+	return int64(n) + m, err
+}
+
+func (r *snapshotReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	_, _ = r.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+func newReplState(cfg *config.ServerProperties) *replState {
+	timeout := defaultReplTimeout
+	if cfg.ReplTimeout > 0 {
+		timeout = time.Duration(cfg.ReplTimeout) * time.Second
+	}
+	return &replState{
+		id:          newReplID(),
+		offset2:     -1,
+		timeout:     timeout,
+		backlogSize: cfg.ReplBacklogSize,
+		replicas:    make(map[*connection.Connection]*replicaInfo),
+		syncDone:    make(chan syncResult, 1),
+	}
+}
+
+// newReplID returns a random replication ID, 40 hex characters like Redis
+func newReplID() string {
+	return utils.RandHexString(40)
+}
+
+// replOffset returns the offset of the last byte of the replication stream, master_repl_offset
+func (db *SequentialDB) replOffset() int64 {
+	if db.repl.backlog == nil {
+		return 0
+	}
+	return db.repl.backlog.Offset()
+}
+
+// role returns master, or replica once REPLICAOF set a master
+func (db *SequentialDB) role() string {
+	if db.repl.master != nil {
+		return "replica"
+	}
+	return "master"
+}
+
+// replicationFeed appends a command to the replication stream: it is kept in the backlog
+// and sent to the replicas. Nothing is kept until a replica connected.
+func (db *SequentialDB) replicationFeed(cmdLine [][]byte) {
+	if db.repl.backlog == nil {
+		return
+	}
+	data := resp.MakeMultiBulkReply(cmdLine).ToBytes()
+	db.repl.backlog.Write(data)
+	for replica, info := range db.repl.replicas {
+		if info.state == replicaOnline || info.state == replicaSendSnapshot {
+			replica.Push(resp.MakeRawReply(data))
+		}
+	}
+}
+
+// replicationCron starts the full resyncs which wait for a snapshot, pings the replicas,
+// keeps alive those waiting for a snapshot and drops those which stopped acknowledging
+// the stream
+func (db *SequentialDB) replicationCron(now time.Time) {
+	db.startFullResync()
+	if len(db.repl.replicas) == 0 {
+		return
+	}
+	if db.repl.master == nil && now.Sub(db.repl.lastPing) >= replPingPeriod {
+		db.repl.lastPing = now
+		db.replicationFeed([][]byte{[]byte("PING")})
+	}
+	if now.Sub(db.repl.lastKeepalive) >= replKeepalivePeriod {
+		db.repl.lastKeepalive = now
+		for replica, info := range db.repl.replicas {
+			if info.state == replicaWaitSnapshot || info.state == replicaSendSnapshot {
+				replica.Keepalive()
+			}
+		}
+	}
+	for replica, info := range db.repl.replicas {
+		if info.state == replicaOnline && now.Sub(info.lastAck) > db.repl.timeout {
+			logger.Warnf("Disconnecting timedout replica: %s", replica.RemoteAddr())
+			_ = replica.Disconnect()
+		}
+	}
+}
+
+// addReplica turns client into a replica once it sent PSYNC
+func (db *SequentialDB) addReplica(client *connection.Connection) *replicaInfo {
+	client.SetReplica()
+	info := db.repl.replicas[client]
+	if info == nil {
+		info = &replicaInfo{}
+		db.repl.replicas[client] = info
+	}
+	if db.repl.backlog == nil {
+		db.repl.backlog = replication.NewBacklog(db.repl.backlogSize, db.replOffset())
+	}
+	info.lastAck = time.Now()
+	return info
+}
+
+// forgetReplica drops the state of a closed client which was a replica
+func (db *SequentialDB) forgetReplica(client *connection.Connection) {
+	info, ok := db.repl.replicas[client]
+	if !ok {
+		return
+	}
+	if info.snapshot != nil {
+		info.snapshot.drop()
+	}
+	delete(db.repl.replicas, client)
+	db.repl.waiting = removeConn(db.repl.waiting, client)
+	db.repl.syncing = removeConn(db.repl.syncing, client)
+	if client.Replica() {
+		logger.Infof("Connection with replica %s lost.", client.RemoteAddr())
+	}
+}
+
+func removeConn(conns []*connection.Connection, c *connection.Connection) []*connection.Connection {
+	for i, conn := range conns {
+		if conn == c {
+			return append(conns[:i], conns[i+1:]...)
+		}
+	}
+	return conns
+}
+
+// disconnectReplicas closes the replicas, which have to resynchronize after the history
+// of this server changed
+func (db *SequentialDB) disconnectReplicas() {
+	for replica := range db.repl.replicas {
+		if replica.Replica() {
+			_ = replica.Disconnect()
+		}
+	}
+}
+
+// tryPartialResync continues the stream of a replica from offset, provided it belongs to
+// the history of this server and is still in the backlog
+func (db *SequentialDB) tryPartialResync(client *connection.Connection, replID string, offset int64) bool {
+	backlog := db.repl.backlog
+	if backlog == nil || (replID != db.repl.id && (replID != db.repl.id2 || offset > db.repl.offset2)) {
+		return false
+	}
+	data, ok := backlog.Since(offset)
+	if !ok {
+		return false
+	}
+	info := db.addReplica(client)
+	info.state = replicaOnline
+	client.Push(resp.MakeStatusReply("CONTINUE " + db.repl.id))
+	if len(data) > 0 {
+		client.Push(resp.MakeRawReply(data))
+	}
+	logger.Infof("Partial resynchronization request from %s accepted. Sending %d bytes of backlog starting from offset %d.",
+		client.RemoteAddr(), len(data), offset)
+	return true
+}
+
+// fullResync queues a replica for the next snapshot
+func (db *SequentialDB) fullResync(client *connection.Connection) {
+	info := db.addReplica(client)
+	info.state = replicaWaitSnapshot
+	db.repl.waiting = append(db.repl.waiting, client)
+	db.startFullResync()
+}
+
+// startFullResync starts a snapshot for the replicas waiting for one, unless a snapshot
+// is already in progress: it is retried by the cron once that one is done. The stream
+// of the replicas begins at the offset of the snapshot, it is queued behind the snapshot.
+func (db *SequentialDB) startFullResync() {
+	if len(db.repl.waiting) == 0 || db.snapshot != nil || db.repl.syncing != nil {
+		return
+	}
+	job := db.startSnapshot()
+	db.repl.syncing, db.repl.waiting = db.repl.waiting, nil
+	offset := db.replOffset()
+	for _, replica := range db.repl.syncing {
+		info := db.repl.replicas[replica]
+		info.state = replicaSendSnapshot
+		info.snapshot = &snapshotReply{}
+		replica.Push(resp.MakeStatusReply("FULLRESYNC " + db.repl.id + " " + strconv.FormatInt(offset, 10)))
+		replica.PushSized(info.snapshot, 0)
+	}
+	logger.Infof("Starting snapshot for SYNC with %d replicas, offset %d", len(db.repl.syncing), offset)
+	dir := filepath.Dir(db.cfg.RDBPath())
+	go func() {
+		file, size, err := spoolSnapshot(dir, job)
+		db.repl.syncDone <- syncResult{file: file, size: size, err: err}
+	}()
+}
+
+// spoolSnapshot writes the chunks of a snapshot to a temp file under dir, which is
+// removed right away and lives as long as it is open
+func spoolSnapshot(dir string, job *snapshotJob) (*os.File, int64, error) {
+	file, err := os.CreateTemp(dir, "temp-repl-*.rdb")
+	if err == nil {
+		err = os.Remove(file.Name())
+	}
+	var size int64
+	for chunk := range job.chunks {
+		if err == nil {
+			var n int
+			n, err = file.Write(chunk)
+			size += int64(n)
+		}
+	}
+	if err == nil {
+		err = job.err
+	}
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+// fullResyncFinished hands the snapshot over to the replicas which waited for it, the
+// goroutines serving them stream it and then the stream fed in the meantime
+func (db *SequentialDB) fullResyncFinished(result syncResult) {
+	syncing := db.repl.syncing
+	db.repl.syncing = nil
+	var snapshot *snapshotFile
+	if result.err == nil {
+		// the reference held here keeps the file open until every replica got it
+		snapshot = &snapshotFile{file: result.file, size: result.size}
+		snapshot.refs.Store(1)
+		defer snapshot.release()
+	}
+	for _, replica := range syncing {
+		info, ok := db.repl.replicas[replica]
+		if !ok || info.state != replicaSendSnapshot {
+			continue
+		}
+		if result.err != nil {
+			logger.Warnf("SYNC failed for replica %s: %v", replica.RemoteAddr(), result.err)
+			_ = replica.Disconnect()
+			continue
+		}
+		info.snapshot.attach(snapshot)
+		replica.Wake()
+		info.state = replicaOnline
+		info.lastAck = time.Now()
+		logger.Infof("Streaming %d bytes of snapshot to replica %s", snapshot.size, replica.RemoteAddr())
+	}
+	db.startFullResync()
+}
+
+// psyncExecuter implements PSYNC replicationid offset, which a replica sends to receive the
+// replication stream. The stream is pushed to the connection, beginning with CONTINUE or
+// FULLRESYNC and the snapshot.
+func psyncExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'psync' command")
+	}
+	if client == nil {
+		return resp.MakeErrorReply("ERR PSYNC is only allowed to clients")
+	}
+	if client.Replica() {
+		return resp.MakeNoReply()
+	}
+	if master := db.repl.master; master != nil && master.state.Load() != linkConnected {
+		return resp.MakeErrorReply("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorReply("ERR value is not an integer or out of range")
+	}
+	replID := string(args[0])
+	if db.tryPartialResync(client, replID, offset) {
+		db.repl.syncPartialOK++
+		return resp.MakeNoReply()
+	}
+	if replID != "?" {
+		db.repl.syncPartialErr++
+	}
+	db.repl.syncFull++
+	logger.Infof("Replica %s asks for synchronization, starting a full resync", client.RemoteAddr())
+	db.fullResync(client)
+	return resp.MakeNoReply()
+}
+
+// replconfExecuter implements REPLCONF <option> <value> [<option> <value> ...], which
+// replicas use to describe themselves and acknowledge the stream with ACK
+func replconfExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 || len(args) == 0 {
+		return resp.MakeErrorReply("ERR syntax error")
+	}
+	if client == nil {
+		return resp.MakeOkReply()
+	}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return resp.MakeErrorReply("ERR value is not an integer or out of range")
+			}
+			info := db.repl.replicas[client]
+			if info == nil {
+				info = &replicaInfo{}
+				db.repl.replicas[client] = info
+			}
+			info.port = port
+		case "ip-address", "capa":
+		case "ack":
+			// acknowledgements get no reply, they would mix with the stream the replica reads
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if info, ok := db.repl.replicas[client]; ok && err == nil && client.Replica() {
+				info.ackOffset = max(info.ackOffset, offset)
+				info.lastAck = time.Now()
+			}
+			return resp.MakeNoReply()
+		case "getack":
+			// only a master asks, its replica acknowledges every second anyway
+			return resp.MakeNoReply()
+		default:
+			return resp.MakeErrorReply("ERR Unrecognized REPLCONF option: " + string(args[i]))
+		}
+	}
+	return resp.MakeOkReply()
+}
+
+// replicaAddr returns the IP and the listening port of a replica
+func replicaAddr(replica *connection.Connection, info *replicaInfo) (string, string) {
+	ip, _, err := net.SplitHostPort(replica.RemoteAddr())
+	if err != nil {
+		ip = replica.RemoteAddr()
+	}
+	return ip, strconv.Itoa(info.port)
+}
+
+// connectedReplicas returns the replicas past the handshake in the order they connected
+func (db *SequentialDB) connectedReplicas() []*connection.Connection {
+	var replicas []*connection.Connection
+	for _, c := range db.sortedClients() {
+		if info, ok := db.repl.replicas[c]; ok && info.state != replicaHandshake {
+			replicas = append(replicas, c)
+		}
+	}
+	return replicas
+}
+
+// replicationInfo generates the replication section of INFO
+func (db *SequentialDB) replicationInfo() []string {
+	lines := []string{"role:master"}
+	if master := db.repl.master; master != nil {
+		lines[0] = "role:slave"
+		state := master.state.Load()
+		lastIO := int64(-1)
+		if state == linkConnected {
+			lastIO = int64(time.Since(master.lastIO).Seconds())
+		}
+		status := "down"
+		if state == linkConnected {
+			status = "up"
+		}
+		lines = append(lines,
+			"master_host:"+master.host,
+			"master_port:"+strconv.Itoa(master.port),
+			"master_link_status:"+status,
+			"master_last_io_seconds_ago:"+strconv.FormatInt(lastIO, 10),
+			"master_sync_in_progress:"+boolInfo(state == linkSync),
+			"slave_read_repl_offset:"+strconv.FormatInt(db.replOffset(), 10),
+			"slave_repl_offset:"+strconv.FormatInt(db.replOffset(), 10),
+			"slave_read_only:1",
+		)
+	}
+	replicas := db.connectedReplicas()
+	lines = append(lines, "connected_slaves:"+strconv.Itoa(len(replicas)))
+	for i, replica := range replicas {
+		info := db.repl.replicas[replica]
+		ip, port := replicaAddr(replica, info)
+		lines = append(lines, "slave"+strconv.Itoa(i)+":ip="+ip+",port="+port+
+			",state="+replicaStateNames[info.state]+
+			",offset="+strconv.FormatInt(info.ackOffset, 10)+
+			",lag="+strconv.FormatInt(int64(time.Since(info.lastAck).Seconds()), 10))
+	}
+	id2 := db.repl.id2
+	if id2 == "" {
+		id2 = strings.Repeat("0", 40)
+	}
+	var first int64
+	var histlen int
+	if backlog := db.repl.backlog; backlog != nil {
+		first, histlen = backlog.FirstOffset(), backlog.Len()
+	}
+	backlogSize := db.repl.backlogSize
+	if backlogSize <= 0 {
+		backlogSize = replication.DefaultBacklogSize
+	}
+	return append(lines,
+		"master_replid:"+db.repl.id,
+		"master_replid2:"+id2,
+		"master_repl_offset:"+strconv.FormatInt(db.replOffset(), 10),
+		"second_repl_offset:"+strconv.FormatInt(db.repl.offset2, 10),
+		"repl_backlog_active:"+boolInfo(db.repl.backlog != nil),
+		"repl_backlog_size:"+strconv.Itoa(backlogSize),
+		"repl_backlog_first_byte_offset:"+strconv.FormatInt(first, 10),
+		"repl_backlog_histlen:"+strconv.Itoa(histlen),
+	)
+}
+
+// roleExecuter implements ROLE
+func roleExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'role' command")
+	}
+	if master := db.repl.master; master != nil {
+		return resp.MakeArrayReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("slave")),
+			resp.MakeBulkReply([]byte(master.host)),
+			resp.MakeIntegerReply(int64(master.port)),
+			resp.MakeBulkReply([]byte(linkStateNames[master.state.Load()])),
+			resp.MakeIntegerReply(db.replOffset()),
+		})
+	}
+	var replicas []resp.Reply
+	for _, replica := range db.connectedReplicas() {
+		info := db.repl.replicas[replica]
+		ip, port := replicaAddr(replica, info)
+		replicas = append(replicas, resp.MakeMultiBulkReply([][]byte{
+			[]byte(ip), []byte(port), []byte(strconv.FormatInt(info.ackOffset, 10)),
+		}))
+	}
+	return resp.MakeArrayReply([]resp.Reply{
+		resp.MakeBulkReply([]byte("master")),
+		resp.MakeIntegerReply(db.replOffset()),
+		resp.MakeArrayReply(replicas),
+	})
+}
+
+func init() {
+	registerCommand("psync", psyncExecuter, flagAdmin, noKeys, "slow")
+	registerCommand("replconf", replconfExecuter, flagAdmin, noKeys, "slow")
+	registerCommand("role", roleExecuter, 0, noKeys, "admin", "fast", "dangerous")
+}
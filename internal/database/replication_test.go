@@ -0,0 +1,166 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/persister"
+)
+
+// waitPushed collects the replies pushed to client until there are n of them
+func waitPushed(t *testing.T, client *connection.Connection, n int) []string {
+	t.Helper()
+	var messages []string
+	for i := 0; i < 500 && len(messages) < n; i++ {
+		messages = append(messages, pushed(client)...)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(messages) != n {
+		t.Fatalf("expected %d pushed replies, got %q", n, messages)
+	}
+	return messages
+}
+
+func TestPsync(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	writer := connection.NewConn(nil)
+	execClient(db, writer, "set", "a", "1")
+
+	replica := connection.NewConn(nil)
+	if got := execClient(db, replica, "replconf", "listening-port", "7000"); got != "+OK\r\n" {
+		t.Fatalf("unexpected reply to REPLCONF %q", got)
+	}
+	execClient(db, replica, "psync", "?", "-1")
+	messages := waitPushed(t, replica, 2)
+	fields := strings.Fields(messages[0])
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" || fields[2] != "0" {
+		t.Fatalf("unexpected reply to PSYNC %q", messages[0])
+	}
+	replID := fields[1]
+	header, snapshot, _ := strings.Cut(messages[1], "\r\n")
+	if header != "$"+strconv.Itoa(len(snapshot)) {
+		t.Errorf("unexpected snapshot header %q", header)
+	}
+	cache := kvcache.NewKVCache()
+	if err := persister.ReadRDB(strings.NewReader(snapshot), cache); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.GetEntity("a"); !ok {
+		t.Error("expected the snapshot to hold the dataset")
+	}
+
+	set := "*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	execClient(db, writer, "set", "b", "2")
+	if got := pushed(replica); len(got) != 1 || got[0] != set {
+		t.Errorf("expected the write to be streamed, got %q", got)
+	}
+	execClient(db, writer, "get", "b")
+	if got := pushed(replica); len(got) != 0 {
+		t.Errorf("expected reads not to be streamed, got %q", got)
+	}
+
+	// a replica which got the snapshot continues from the offset after it
+	resumed := connection.NewConn(nil)
+	execClient(db, resumed, "psync", replID, "1")
+	if got := pushed(resumed); len(got) != 2 || got[0] != "+CONTINUE "+replID+"\r\n" || got[1] != set {
+		t.Errorf("expected a partial resync, got %q", got)
+	}
+	unknown := connection.NewConn(nil)
+	execClient(db, unknown, "psync", strings.Repeat("0", 40), "1")
+	if got := waitPushed(t, unknown, 2); !strings.HasPrefix(got[0], "+FULLRESYNC "+replID+" "+strconv.Itoa(len(set))) {
+		t.Errorf("expected a full resync for an unknown history, got %q", got[0])
+	}
+
+	info := execClient(db, writer, "info", "replication", "stats")
+	for _, line := range []string{"connected_slaves:3", "sync_full:2", "sync_partial_ok:1", "sync_partial_err:1",
+		"slave0:ip=,port=7000,state=online", "master_repl_offset:" + strconv.Itoa(len(set))} {
+		if !strings.Contains(info, line) {
+			t.Errorf("expected %s in INFO:\n%s", line, info)
+		}
+	}
+	if got := execClient(db, writer, "role"); !strings.HasPrefix(got, "*3\r\n$6\r\nmaster\r\n:"+strconv.Itoa(len(set))) {
+		t.Errorf("unexpected reply to ROLE %q", got)
+	}
+}
+
+func TestFullResyncOutputLimit(t *testing.T) {
+	db := NewSequentialDB(&config.ServerProperties{Dir: t.TempDir(), ClientOutputBufferLimit: "replica 100 0 0"})
+	defer db.Close()
+	writer := connection.NewConn(nil)
+	for i := 0; i < 20; i++ {
+		execClient(db, writer, "set", "key:"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+
+	// the snapshot is streamed to the replica, it does not count for the limit
+	replica := connection.NewConn(nil)
+	execClient(db, replica, "psync", "?", "-1")
+	if messages := waitPushed(t, replica, 2); len(messages[1]) < 200 {
+		t.Fatalf("expected the snapshot, got %q", messages[1])
+	}
+	if replica.Overflowed() {
+		t.Fatal("expected the snapshot not to count for the output limit")
+	}
+	// the stream does
+	execClient(db, writer, "set", "b", strings.Repeat("x", 200))
+	if !replica.Overflowed() {
+		t.Error("expected the stream to count for the output limit")
+	}
+}
+
+func TestFullResyncKeepalive(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	replica := connection.NewConn(nil)
+
+	// the snapshot is only handed over by the command goroutine, it cannot be ready here
+	onCommandGoroutine(db, func() {
+		db.call(replica, "psync", [][]byte{[]byte("?"), []byte("-1")})
+		db.replicationCron(time.Now().Add(replKeepalivePeriod))
+	})
+	if !replica.TakeKeepalive() {
+		t.Error("expected a newline for the replica waiting for its snapshot")
+	}
+	// FULLRESYNC, the snapshot and the first PING
+	waitPushed(t, replica, 3)
+	onCommandGoroutine(db, func() {
+		db.replicationCron(time.Now().Add(2 * replKeepalivePeriod))
+	})
+	if replica.TakeKeepalive() {
+		t.Error("expected no newline once the snapshot is sent")
+	}
+}
+
+func TestReplicaReadOnly(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	// nothing listens on port 0, the replica keeps trying
+	if got := execClient(db, client, "replicaof", "127.0.0.1", "0"); got != "+OK\r\n" {
+		t.Fatalf("unexpected reply to REPLICAOF %q", got)
+	}
+	if got := execClient(db, client, "set", "a", "1"); got != "-READONLY You can't write against a read only replica.\r\n" {
+		t.Errorf("expected writes to be refused, got %q", got)
+	}
+	if got := execClient(db, client, "get", "a"); got != "$-1\r\n" {
+		t.Errorf("expected reads to be served, got %q", got)
+	}
+	if got := execClient(db, client, "psync", "?", "-1"); !strings.HasPrefix(got, "-NOMASTERLINK") {
+		t.Errorf("expected PSYNC to be refused without a link to the master, got %q", got)
+	}
+	if got := execClient(db, client, "replicaof", "no", "one"); got != "+OK\r\n" {
+		t.Fatalf("unexpected reply to REPLICAOF NO ONE %q", got)
+	}
+	if got := execClient(db, client, "set", "a", "1"); got != "+OK\r\n" {
+		t.Errorf("expected writes once promoted, got %q", got)
+	}
+	info := execClient(db, client, "info", "replication")
+	if !strings.Contains(info, "role:master") || strings.Contains(info, "master_replid2:0000") {
+		t.Errorf("expected the previous replication ID to be kept, got:\n%s", info)
+	}
+}
@@ -1,18 +1,92 @@
 package database
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/mirage208/redis-go/internal/acl"
+)
+
+// command flags
+const (
+	flagWrite      = 1 << iota // the command may modify the dataset
+	flagReadonly               // the command never modifies the dataset
+	flagAdmin                  // administrative command, e.g. SAVE
+	flagSubscribed             // allowed to RESP2 clients with subscriptions, e.g. SUBSCRIBE and PING
+	flagNoAuth                 // allowed before the client authenticated, e.g. AUTH and HELLO
+	flagNoQueue                // executed at once by a client in MULTI rather than queued, e.g. EXEC
+)
 
 type Command struct {
 	name     string   // Command name
 	executer ExecFunc // Function to execute the command
+	flags    int      // Combination of command flags
+	keys     keySpec  // Which arguments are keys
+
+	categories []string // ACL categories, such as read and hash
+}
+
+// keySpec locates the keys in a command line, where the command name is at position 0.
+// A negative last counts from the end, -1 being the last argument.
+type keySpec struct {
+	first, last, step int
+}
+
+var (
+	noKeys    = keySpec{}
+	singleKey = keySpec{first: 1, last: 1, step: 1}
+)
+
+// extract returns the keys among the arguments of a command, which exclude its name
+func (spec keySpec) extract(args [][]byte) []string {
+	last := spec.last
+	if last < 0 {
+		last += len(args) + 1
+	}
+	last = min(last, len(args))
+	if spec.first == 0 || last < spec.first {
+		return nil
+	}
+	keys := make([]string, 0, (last-spec.first)/spec.step+1)
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, string(args[i-1]))
+	}
+	return keys
 }
 
 var cmdTable = make(map[string]*Command)
 
-// RegisterCommand registers a new command with the command table
-func registerCommand(name string, executer ExecFunc) {
+// AllowedBeforeAuth reports whether a client may run the command before it authenticated
+func AllowedBeforeAuth(name []byte) bool {
+	command, ok := cmdTable[strings.ToLower(string(name))]
+	return ok && command.flags&flagNoAuth != 0
+}
+
+// RegisterCommand registers a new command with the command table. The read, write,
+// admin and dangerous categories follow from flags, the others are given.
+func registerCommand(name string, executer ExecFunc, flags int, keys keySpec, categories ...string) {
+	switch {
+	case flags&flagWrite != 0:
+		categories = append(categories, "write")
+	case flags&flagReadonly != 0:
+		categories = append(categories, "read")
+	}
+	if flags&flagAdmin != 0 {
+		categories = append(categories, "admin", "dangerous")
+	}
 	cmdTable[strings.ToLower(name)] = &Command{
-		name:     name,
-		executer: executer,
+		name:       name,
+		executer:   executer,
+		flags:      flags,
+		keys:       keys,
+		categories: categories,
+	}
+}
+
+// aclCatalog lists the commands and their categories for ACL rules
+func aclCatalog() *acl.Catalog {
+	commands := make(map[string][]string, len(cmdTable))
+	for name, command := range cmdTable {
+		commands[name] = command.categories
 	}
+	return acl.NewCatalog(commands)
 }
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"github.com/mirage208/redis-go/common/datastruct/set"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// getSet returns the set stored at key, nil if the key does not exist.
+// With forWrite the caller may modify the set in place.
+func (db *SequentialDB) getSet(key string, forWrite bool) (set.Set, resp.Reply) {
+	get := db.cache.GetEntity
+	if forWrite {
+		get = db.cache.GetEntityForWrite
+	}
+	entity, exists := get(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(set.Set)
+	if !ok {
+		return nil, resp.MakeErrorReply(errWrongType)
+	}
+	return s, nil
+}
+
+// saddExecuter implements SADD key member [member ...]
+func saddExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'sadd' command")
+	}
+	key := string(args[0])
+	s, errReply := db.getSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s = set.NewSequentialSet()
+		db.cache.PutEntity(key, &kvcache.DataEntity{Data: s})
+	}
+	added := 0
+	for _, member := range args[1:] {
+		added += s.Add(string(member))
+	}
+	if added > 0 {
+		db.dirty++
+		db.notify(notifySet, "sadd", key)
+	}
+	return resp.MakeIntegerReply(int64(added))
+}
+
+// sremExecuter implements SREM key member [member ...], the key is removed with its last member
+func sremExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'srem' command")
+	}
+	key := string(args[0])
+	s, errReply := db.getSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		removed += s.Remove(string(member))
+	}
+	if removed > 0 {
+		db.dirty++
+		db.notify(notifySet, "srem", key)
+	}
+	if s.Len() == 0 {
+		db.cache.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return resp.MakeIntegerReply(int64(removed))
+}
+
+func sismemberExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'sismember' command")
+	}
+	s, errReply := db.getSet(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if s != nil && s.Has(string(args[1])) {
+		return resp.MakeIntegerReply(1)
+	}
+	return resp.MakeIntegerReply(0)
+}
+
+func scardExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'scard' command")
+	}
+	s, errReply := db.getSet(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	return resp.MakeIntegerReply(int64(s.Len()))
+}
+
+// smembersExecuter replies a set, which RESP2 clients receive as an array
+func smembersExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'smembers' command")
+	}
+	s, errReply := db.getSet(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return resp.MakeSetReply(nil)
+	}
+	members := make([]resp.Reply, 0, s.Len())
+	s.ForEach(func(member string) bool {
+		members = append(members, resp.MakeBulkReply([]byte(member)))
+		return true
+	})
+	return resp.MakeSetReply(members)
+}
+
+func init() {
+	registerCommand("sadd", saddExecuter, flagWrite, singleKey, "set", "fast")
+	registerCommand("srem", sremExecuter, flagWrite, singleKey, "set", "fast")
+	registerCommand("sismember", sismemberExecuter, flagReadonly, singleKey, "set", "fast")
+	registerCommand("scard", scardExecuter, flagReadonly, singleKey, "set", "fast")
+	registerCommand("smembers", smembersExecuter, flagReadonly, singleKey, "set", "slow")
+}
@@ -0,0 +1,52 @@
+package database
+
+import "testing"
+
+func TestSetCommands(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{
+			name: "sadd and read",
+			steps: []commandStep{
+				{cmd: []string{"sadd", "s", "a", "b", "a"}, reply: ":2\r\n", events: []string{"sadd s"}},
+				{cmd: []string{"sadd", "s", "a"}, reply: ":0\r\n"},
+				{cmd: []string{"sismember", "s", "b"}, reply: ":1\r\n"},
+				{cmd: []string{"sismember", "s", "c"}, reply: ":0\r\n"},
+				{cmd: []string{"sismember", "missing", "b"}, reply: ":0\r\n"},
+				{cmd: []string{"scard", "s"}, reply: ":2\r\n"},
+				{cmd: []string{"scard", "missing"}, reply: ":0\r\n"},
+				{cmd: []string{"sadd", "s"}, reply: "-ERR wrong number of arguments for 'sadd' command\r\n"},
+			},
+		},
+		{
+			name: "smembers",
+			steps: []commandStep{
+				{cmd: []string{"smembers", "s"}, reply: "*0\r\n"},
+				{cmd: []string{"sadd", "s", "a"}, reply: ":1\r\n", events: []string{"sadd s"}},
+				{cmd: []string{"smembers", "s"}, reply: "*1\r\n$1\r\na\r\n"},
+			},
+		},
+		{
+			name: "srem removes the empty key",
+			steps: []commandStep{
+				{cmd: []string{"sadd", "s", "a", "b"}, reply: ":2\r\n", events: []string{"sadd s"}},
+				{cmd: []string{"srem", "s", "a", "c"}, reply: ":1\r\n", events: []string{"srem s"}},
+				{cmd: []string{"srem", "s", "c"}, reply: ":0\r\n"},
+				{cmd: []string{"srem", "missing", "a"}, reply: ":0\r\n"},
+				{cmd: []string{"srem", "s", "b"}, reply: ":1\r\n", events: []string{"srem s", "del s"}},
+				{cmd: []string{"scard", "s"}, reply: ":0\r\n"},
+			},
+			absent: []string{"s", "missing"},
+		},
+		{
+			name: "wrong type",
+			steps: []commandStep{
+				{cmd: []string{"hset", "h", "f", "v"}, reply: ":1\r\n", events: []string{"hset h"}},
+				{cmd: []string{"sadd", "h", "a"}, reply: wrongType},
+				{cmd: []string{"srem", "h", "a"}, reply: wrongType},
+				{cmd: []string{"sismember", "h", "a"}, reply: wrongType},
+				{cmd: []string{"scard", "h"}, reply: wrongType},
+				{cmd: []string{"smembers", "h"}, reply: wrongType},
+			},
+		},
+	})
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+// saveMode tells whether a snapshot is saved on shutdown
+type saveMode int
+
+const (
+	saveIfConfigured saveMode = iota // save if there are save rules, like Redis
+	saveNever                        // SHUTDOWN NOSAVE
+	saveAlways                       // SHUTDOWN SAVE
+)
+
+// ShutdownRequested is closed once a client called SHUTDOWN, the server is then expected to close the DB
+func (db *SequentialDB) ShutdownRequested() <-chan struct{} {
+	return db.shutdownCh
+}
+
+// prepareForShutdown stops background saving, saves a snapshot according to mode and
+// makes sure the AOF is on disk. The DB refuses commands once it succeeded.
+func (db *SequentialDB) prepareForShutdown(mode saveMode) error {
+	if db.rdb.bgsaveInProgress {
+		// the final snapshot supersedes it
+		db.abortSnapshot()
+		db.bgsaveFinished(<-db.rdb.bgsaveDone)
+	}
+	if mode == saveAlways || mode == saveIfConfigured && len(db.rdb.saveParams) > 0 {
+		logger.Info("Saving the final RDB snapshot before exiting.")
+		if err := db.rdbSave(); err != nil {
+			return err
+		}
+	}
+	if db.persister != nil {
+		synced := make(chan error, 1)
+		db.persister.Sync(func(err error) {
+			synced <- err
+		})
+		if err := <-synced; err != nil {
+			return err
+		}
+	}
+	db.shuttingDown = true
+	return nil
+}
+
+// requestShutdown has the server shut down, the commands postponed by CLIENT PAUSE are refused
+func (db *SequentialDB) requestShutdown() {
+	if db.shutdownRequested {
+		return
+	}
+	db.shutdownRequested = true
+	close(db.shutdownCh)
+	db.unpause()
+}
+
+// shutdownExecuter implements SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]. Replicas are not
+// waited for, so NOW makes no difference and there is no shutdown to abort.
+func shutdownExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	mode := saveIfConfigured
+	var force, abort bool
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "nosave":
+			mode = saveNever
+		case "save":
+			mode = saveAlways
+		case "now":
+		case "force":
+			force = true
+		case "abort":
+			abort = true
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+	}
+	if abort {
+		if len(args) > 1 {
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+		return resp.MakeErrorReply("ERR No shutdown in progress.")
+	}
+
+	if err := db.prepareForShutdown(mode); err != nil {
+		if !force {
+			logger.Warnf("Errors trying to shut down the server: %v", err)
+			return resp.MakeErrorReply("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+		logger.Warnf("Shutting down despite errors: %v", err)
+		db.shuttingDown = true
+	}
+	logger.Info("User requested shutdown...")
+	db.requestShutdown()
+	// like Redis, the client gets no reply, it sees the connection closed
+	if client != nil {
+		client.CloseAfterReply()
+	}
+	return resp.MakeNoReply()
+}
+
+// abortSnapshot stops the snapshot job in progress, its consumer then fails
+func (db *SequentialDB) abortSnapshot() {
+	job := db.snapshot
+	if job == nil {
+		return
+	}
+	if job.err == nil {
+		job.err = errors.New("snapshot aborted")
+	}
+	job.snapshot.Abort()
+	close(job.chunks)
+	db.snapshot = nil
+}
+
+func init() {
+	registerCommand("shutdown", shutdownExecuter, flagAdmin, noKeys, "slow")
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+func TestShutdownSave(t *testing.T) {
+	cfg := &config.ServerProperties{Dir: t.TempDir()}
+	db := NewSequentialDB(cfg)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	execClient(db, client, "set", "k", "v")
+
+	if got := execClient(db, client, "shutdown", "abort"); got != "-ERR No shutdown in progress.\r\n" {
+		t.Errorf("unexpected reply to SHUTDOWN ABORT %q", got)
+	}
+	if got := execClient(db, client, "shutdown", "save"); got != "\r\n" {
+		t.Fatalf("expected no reply to SHUTDOWN, got %q", got)
+	}
+	if !client.ClosingAfterReply() {
+		t.Error("expected the client to be closed")
+	}
+	select {
+	case <-db.ShutdownRequested():
+	default:
+		t.Fatal("expected the shutdown to be requested")
+	}
+	if _, err := os.Stat(cfg.RDBPath()); err != nil {
+		t.Errorf("expected the snapshot to be saved: %v", err)
+	}
+	if got := execClient(db, connection.NewConn(nil), "set", "k", "lost"); got != "-"+errShuttingDown+"\r\n" {
+		t.Errorf("expected commands to be refused once shut down, got %q", got)
+	}
+}
+
+func TestShutdownNoSave(t *testing.T) {
+	cfg := &config.ServerProperties{Dir: t.TempDir(), Save: "3600 1"}
+	db := NewSequentialDB(cfg)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	execClient(db, client, "set", "k", "v")
+
+	execClient(db, client, "shutdown", "nosave")
+	if _, err := os.Stat(cfg.RDBPath()); !os.IsNotExist(err) {
+		t.Errorf("expected no snapshot with NOSAVE, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/persister"
+)
+
+// A snapshot job dumps the keyspace without stalling clients: the command goroutine
+// encodes a few keys at a time in between commands, while the write barrier of
+// kvcache.Snapshot captures keys right before they are modified. Encoded bytes are
+// handed over in chunks to a consumer goroutine, which does the slow I/O.
+const (
+	snapshotStepKeys   = 32      // keys encoded per step
+	snapshotMaxPending = 4 << 20 // bytes waiting for the consumer before encoding pauses
+	snapshotChunks     = 16      // chunks buffered between the command goroutine and the consumer
+)
+
+// closedCh is always ready to receive, it drives snapshot steps from the select of handleCommands
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+type snapshotJob struct {
+	snapshot *kvcache.Snapshot
+	encoder  *persister.RDBEncoder
+	buf      bytes.Buffer
+	finished bool // every key and the footer are encoded
+
+	// chunks is closed once the snapshot is complete or failed, err is safe to read after that
+	chunks chan []byte
+	err    error
+}
+
+// startSnapshot begins a snapshot of the current dataset, the consumer must drain
+// the returned channel and then check err of the job
+func (db *SequentialDB) startSnapshot() *snapshotJob {
+	job := &snapshotJob{
+		chunks: make(chan []byte, snapshotChunks),
+	}
+	job.encoder = persister.NewRDBEncoder(&job.buf)
+	job.err = job.encoder.WriteHeader(db.cache.Len(), db.cache.ExpiresLen())
+	job.snapshot = db.cache.BeginSnapshot(func(key string, entity *kvcache.DataEntity, expiration *time.Time) {
+		if job.err == nil {
+			job.err = job.encoder.WriteEntry(key, entity, expiration)
+		}
+	})
+	db.snapshot = job
+	return job
+}
+
+// snapshotReady returns a ready channel while the snapshot job can make progress,
+// otherwise nil so that the command goroutine blocks on other events
+func (db *SequentialDB) snapshotReady() <-chan struct{} {
+	job := db.snapshot
+	if job == nil {
+		return nil
+	}
+	pending := job.buf.Len()
+	if job.err != nil ||
+		(!job.finished && pending < snapshotMaxPending) ||
+		(pending > 0 && len(job.chunks) < cap(job.chunks)) ||
+		(job.finished && pending == 0) {
+		return closedCh
+	}
+	return nil
+}
+
+// snapshotStep encodes the next keys and passes the encoded bytes to the consumer without blocking
+func (db *SequentialDB) snapshotStep() {
+	job := db.snapshot
+	if job == nil {
+		return
+	}
+	if job.err == nil && !job.finished && job.buf.Len() < snapshotMaxPending {
+		if job.snapshot.Step(snapshotStepKeys) {
+			job.finished = true
+			job.err = job.encoder.WriteFooter()
+		}
+	}
+	if job.err == nil {
+		job.err = job.encoder.Flush()
+	}
+	if job.err != nil {
+		job.snapshot.Abort()
+		close(job.chunks)
+		db.snapshot = nil
+		return
+	}
+
+	if job.buf.Len() > 0 {
+		select {
+		case job.chunks <- bytes.Clone(job.buf.Bytes()):
+			job.buf.Reset()
+		default:
+		}
+	}
+	if job.finished && job.buf.Len() == 0 {
+		close(job.chunks)
+		db.snapshot = nil
+	}
+}
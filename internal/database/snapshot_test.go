@@ -0,0 +1,113 @@
+package database
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/persister"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func makeTestDB(tb testing.TB) *SequentialDB {
+	return NewSequentialDB(&config.ServerProperties{Dir: tb.TempDir()})
+}
+
+func execLine(db *SequentialDB, args ...string) resp.Reply {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return db.Exec(nil, cmdLine)
+}
+
+func waitBgsave(tb testing.TB, db *SequentialDB) {
+	for i := 0; i < 500; i++ {
+		info := execLine(db, "info", "persistence").(*resp.BulkReply)
+		if bytes.Contains(info.Arg, []byte("rdb_bgsave_in_progress:0")) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.Fatal("background save did not finish")
+}
+
+func TestBgsaveIsPointInTime(t *testing.T) {
+	db := makeTestDB(t)
+	for i := 0; i < 10000; i++ {
+		execLine(db, "set", "key"+strconv.Itoa(i), "old")
+	}
+	if r := execLine(db, "bgsave"); string(r.ToBytes()) != "+Background saving started\r\n" {
+		t.Fatalf("bgsave failed: %s", r.ToBytes())
+	}
+	for i := 0; i < 10000; i++ {
+		execLine(db, "set", "key"+strconv.Itoa(i), "new")
+	}
+	execLine(db, "set", "created", "new")
+	waitBgsave(t, db)
+
+	cache := kvcache.NewKVCache()
+	if err := persister.LoadRDBFile(db.cfg.RDBPath(), cache); err != nil {
+		t.Fatalf("load snapshot: %v", err)
+	}
+	if cache.Len() != 10000 {
+		t.Errorf("expected 10000 keys, got %d", cache.Len())
+	}
+	cache.ForEach(func(key string, entity *kvcache.DataEntity, expiration *time.Time) bool {
+		if string(entity.Data.([]byte)) != "old" {
+			t.Errorf("key %s saved with value written after BGSAVE", key)
+			return false
+		}
+		return true
+	})
+}
+
+func TestBgsaveFailureReleasesSnapshot(t *testing.T) {
+	// the temp file cannot be created, the save fails before reading the snapshot
+	db := NewSequentialDB(&config.ServerProperties{Dir: filepath.Join(t.TempDir(), "missing")})
+	defer db.Close()
+	for i := 0; i < 5000; i++ {
+		execLine(db, "set", "key"+strconv.Itoa(i), "value")
+	}
+	for i := 0; i < 2; i++ {
+		if r := execLine(db, "bgsave"); string(r.ToBytes()) != "+Background saving started\r\n" {
+			t.Fatalf("bgsave %d refused: %s", i, r.ToBytes())
+		}
+		waitBgsave(t, db)
+	}
+	info := execLine(db, "info", "persistence").(*resp.BulkReply)
+	if !bytes.Contains(info.Arg, []byte("rdb_last_bgsave_status:err")) {
+		t.Errorf("expected the failure in INFO, got %q", info.Arg)
+	}
+}
+
+// BenchmarkSetDuringBgsave measures SET latency while background saves of a large dataset run back to back
+func BenchmarkSetDuringBgsave(b *testing.B) {
+	db := makeTestDB(b)
+	value := string(bytes.Repeat([]byte("x"), 256))
+	for i := 0; i < 200000; i++ {
+		execLine(db, "set", "key"+strconv.Itoa(i), value)
+	}
+
+	latencies := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%1000 == 0 {
+			execLine(db, "bgsave")
+		}
+		start := time.Now()
+		execLine(db, "set", "key"+strconv.Itoa(i%200000), value)
+		latencies = append(latencies, time.Since(start))
+	}
+	b.StopTimer()
+	waitBgsave(b, db)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-us")
+	b.ReportMetric(float64(latencies[len(latencies)-1].Microseconds()), "max-us")
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mirage208/redis-go/common/datastruct/sortedset"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// getSortedSet returns the sorted set stored at key, nil if the key does not exist.
+// With forWrite the caller may modify the sorted set in place.
+func (db *SequentialDB) getSortedSet(key string, forWrite bool) (*sortedset.SortedSet, resp.Reply) {
+	get := db.cache.GetEntity
+	if forWrite {
+		get = db.cache.GetEntityForWrite
+	}
+	entity, exists := get(key)
+	if !exists {
+		return nil, nil
+	}
+	zset, ok := entity.Data.(*sortedset.SortedSet)
+	if !ok {
+		return nil, resp.MakeErrorReply(errWrongType)
+	}
+	return zset, nil
+}
+
+func parseScore(arg []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// zaddExecuter implements ZADD key [NX|XX] [CH] score member [score member ...]
+func zaddExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'zadd' command")
+	}
+	key := string(args[0])
+	var nx, xx, ch bool
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+			continue
+		case "xx":
+			xx = true
+			continue
+		case "ch":
+			ch = true
+			continue
+		}
+		break
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return resp.MakeErrorReply("ERR syntax error")
+	}
+	if nx && xx {
+		return resp.MakeErrorReply("ERR XX and NX options at the same time are not compatible")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseScore(pairs[2*j])
+		if !ok {
+			return resp.MakeErrorReply("ERR value is not a valid float")
+		}
+		scores[j] = score
+	}
+
+	zset, errReply := db.getSortedSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		if xx {
+			return resp.MakeIntegerReply(0)
+		}
+		zset = sortedset.Make()
+		db.cache.PutEntity(key, &kvcache.DataEntity{Data: zset})
+	}
+	added, changed := 0, 0
+	for j, score := range scores {
+		member := string(pairs[2*j+1])
+		element, exists := zset.Get(member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		if exists && element.Score == score {
+			continue
+		}
+		if zset.Add(member, score) {
+			added++
+		}
+		changed++
+	}
+	if changed > 0 {
+		db.dirty++
+		db.notify(notifyZSet, "zadd", key)
+	}
+	if ch {
+		return resp.MakeIntegerReply(int64(changed))
+	}
+	return resp.MakeIntegerReply(int64(added))
+}
+
+// zincrbyExecuter implements ZINCRBY key increment member and replies the new score
+func zincrbyExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'zincrby' command")
+	}
+	key := string(args[0])
+	increment, ok := parseScore(args[1])
+	if !ok {
+		return resp.MakeErrorReply("ERR value is not a valid float")
+	}
+	zset, errReply := db.getSortedSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	member := string(args[2])
+	score := increment
+	if zset != nil {
+		if element, exists := zset.Get(member); exists {
+			score += element.Score
+		}
+	}
+	if math.IsNaN(score) {
+		return resp.MakeErrorReply("ERR resulting score is not a number (NaN)")
+	}
+	if zset == nil {
+		zset = sortedset.Make()
+		db.cache.PutEntity(key, &kvcache.DataEntity{Data: zset})
+	}
+	zset.Add(member, score)
+	db.dirty++
+	db.notify(notifyZSet, "zincr", key)
+	return resp.MakeDoubleReply(score)
+}
+
+// zremExecuter implements ZREM key member [member ...], the key is removed with its last member
+func zremExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'zrem' command")
+	}
+	key := string(args[0])
+	zset, errReply := db.getSortedSet(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		if zset.Remove(string(member)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		db.dirty++
+		db.notify(notifyZSet, "zrem", key)
+	}
+	if zset.Len() == 0 {
+		db.cache.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return resp.MakeIntegerReply(int64(removed))
+}
+
+func zcardExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'zcard' command")
+	}
+	zset, errReply := db.getSortedSet(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return resp.MakeIntegerReply(0)
+	}
+	return resp.MakeIntegerReply(zset.Len())
+}
+
+// zscoreExecuter replies a double, which RESP2 clients receive as a bulk string
+func zscoreExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'zscore' command")
+	}
+	zset, errReply := db.getSortedSet(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return resp.MakeNullBulkReply()
+	}
+	element, exists := zset.Get(string(args[1]))
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+	return resp.MakeDoubleReply(element.Score)
+}
+
+// zrangeExecuter implements ZRANGE key start stop [WITHSCORES]. With scores RESP3
+// clients receive [member, score] pairs, RESP2 clients a flat array.
+func zrangeExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 && len(args) != 4 {
+		return resp.MakeErrorReply("ERR wrong number of arguments for 'zrange' command")
+	}
+	withScores := false
+	if len(args) == 4 {
+		if strings.ToLower(string(args[3])) != "withscores" {
+			return resp.MakeErrorReply("ERR syntax error")
+		}
+		withScores = true
+	}
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorReply("ERR value is not an integer or out of range")
+	}
+	zset, errReply := db.getSortedSet(string(args[0]), false)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return resp.MakeEmptyMultiBulkReply()
+	}
+
+	size := zset.Len()
+	if start < 0 {
+		start = max(size+start, 0)
+	}
+	if stop < 0 {
+		stop += size
+	}
+	stop = min(stop, size-1)
+	if start > stop {
+		return resp.MakeEmptyMultiBulkReply()
+	}
+	elements := zset.RangeByRank(start, stop+1, false)
+
+	if !withScores {
+		members := make([][]byte, len(elements))
+		for i, element := range elements {
+			members[i] = []byte(element.Member)
+		}
+		return resp.MakeMultiBulkReply(members)
+	}
+	if protocol(client) == resp.RESP3 {
+		pairs := make([]resp.Reply, len(elements))
+		for i, element := range elements {
+			pairs[i] = resp.MakeArrayReply([]resp.Reply{
+				resp.MakeBulkReply([]byte(element.Member)),
+				resp.MakeDoubleReply(element.Score),
+			})
+		}
+		return resp.MakeArrayReply(pairs)
+	}
+	flat := make([][]byte, 0, 2*len(elements))
+	for _, element := range elements {
+		flat = append(flat, []byte(element.Member), []byte(resp.FormatDouble(element.Score)))
+	}
+	return resp.MakeMultiBulkReply(flat)
+}
+
+func init() {
+	registerCommand("zadd", zaddExecuter, flagWrite, singleKey, "sortedset", "fast")
+	registerCommand("zincrby", zincrbyExecuter, flagWrite, singleKey, "sortedset", "fast")
+	registerCommand("zrem", zremExecuter, flagWrite, singleKey, "sortedset", "fast")
+	registerCommand("zcard", zcardExecuter, flagReadonly, singleKey, "sortedset", "fast")
+	registerCommand("zscore", zscoreExecuter, flagReadonly, singleKey, "sortedset", "fast")
+	registerCommand("zrange", zrangeExecuter, flagReadonly, singleKey, "sortedset", "slow")
+}
@@ -0,0 +1,73 @@
+package database
+
+import "testing"
+
+func TestSortedSetCommands(t *testing.T) {
+	runCommandTests(t, []commandTest{
+		{
+			name: "zadd and read",
+			steps: []commandStep{
+				{cmd: []string{"zadd", "z", "1", "a", "2", "b", "3", "c"}, reply: ":3\r\n", events: []string{"zadd z"}},
+				{cmd: []string{"zadd", "z", "1", "a"}, reply: ":0\r\n"},
+				{cmd: []string{"zadd", "z", "ch", "5", "a", "1", "d"}, reply: ":2\r\n", events: []string{"zadd z"}},
+				{cmd: []string{"zadd", "z", "nx", "9", "a", "0", "e"}, reply: ":1\r\n", events: []string{"zadd z"}},
+				{cmd: []string{"zadd", "z", "xx", "ch", "4", "a", "9", "f"}, reply: ":1\r\n", events: []string{"zadd z"}},
+				{cmd: []string{"zscore", "z", "a"}, reply: "$1\r\n4\r\n"},
+				{cmd: []string{"zscore", "z", "f"}, reply: "$-1\r\n"},
+				{cmd: []string{"zscore", "missing", "a"}, reply: "$-1\r\n"},
+				{cmd: []string{"zcard", "z"}, reply: ":5\r\n"},
+				{cmd: []string{"zcard", "missing"}, reply: ":0\r\n"},
+				{cmd: []string{"zrange", "z", "0", "-1"}, reply: "*5\r\n$1\r\ne\r\n$1\r\nd\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\na\r\n"},
+				{cmd: []string{"zrange", "z", "-2", "10", "withscores"}, reply: "*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\na\r\n$1\r\n4\r\n"},
+				{cmd: []string{"zrange", "z", "3", "1"}, reply: "*0\r\n"},
+				{cmd: []string{"zrange", "missing", "0", "-1"}, reply: "*0\r\n"},
+			},
+		},
+		{
+			name: "zadd errors",
+			steps: []commandStep{
+				{cmd: []string{"zadd", "z", "nx", "xx", "1", "a"}, reply: "-ERR XX and NX options at the same time are not compatible\r\n"},
+				{cmd: []string{"zadd", "z", "1", "a", "2"}, reply: "-ERR syntax error\r\n"},
+				{cmd: []string{"zadd", "z", "one", "a"}, reply: "-ERR value is not a valid float\r\n"},
+				{cmd: []string{"zadd", "z", "nan", "a"}, reply: "-ERR value is not a valid float\r\n"},
+				{cmd: []string{"zadd", "z", "xx", "1", "a"}, reply: ":0\r\n"},
+				{cmd: []string{"zrange", "z", "0", "-1", "scores"}, reply: "-ERR syntax error\r\n"},
+			},
+			absent: []string{"z"},
+		},
+		{
+			name: "zincrby",
+			steps: []commandStep{
+				{cmd: []string{"zincrby", "z", "1.5", "a"}, reply: "$3\r\n1.5\r\n", events: []string{"zincr z"}},
+				{cmd: []string{"zincrby", "z", "2", "a"}, reply: "$3\r\n3.5\r\n", events: []string{"zincr z"}},
+				{cmd: []string{"zincrby", "z", "inf", "b"}, reply: "$3\r\ninf\r\n", events: []string{"zincr z"}},
+				{cmd: []string{"zincrby", "z", "-inf", "b"}, reply: "-ERR resulting score is not a number (NaN)\r\n"},
+				{cmd: []string{"zincrby", "missing", "-inf", "b"}, reply: "$4\r\n-inf\r\n", events: []string{"zincr missing"}},
+			},
+		},
+		{
+			name: "zrem removes the empty key",
+			steps: []commandStep{
+				{cmd: []string{"zadd", "z", "1", "a", "2", "b"}, reply: ":2\r\n", events: []string{"zadd z"}},
+				{cmd: []string{"zrem", "z", "a", "c"}, reply: ":1\r\n", events: []string{"zrem z"}},
+				{cmd: []string{"zrem", "z", "c"}, reply: ":0\r\n"},
+				{cmd: []string{"zrem", "missing", "a"}, reply: ":0\r\n"},
+				{cmd: []string{"zrem", "z", "b"}, reply: ":1\r\n", events: []string{"zrem z", "del z"}},
+				{cmd: []string{"zcard", "z"}, reply: ":0\r\n"},
+			},
+			absent: []string{"z", "missing"},
+		},
+		{
+			name: "wrong type",
+			steps: []commandStep{
+				{cmd: []string{"sadd", "s", "a"}, reply: ":1\r\n", events: []string{"sadd s"}},
+				{cmd: []string{"zadd", "s", "1", "a"}, reply: wrongType},
+				{cmd: []string{"zincrby", "s", "1", "a"}, reply: wrongType},
+				{cmd: []string{"zrem", "s", "a"}, reply: wrongType},
+				{cmd: []string{"zcard", "s"}, reply: wrongType},
+				{cmd: []string{"zscore", "s", "a"}, reply: wrongType},
+				{cmd: []string{"zrange", "s", "0", "-1"}, reply: wrongType},
+			},
+		},
+	})
+}
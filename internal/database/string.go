@@ -1,8 +1,12 @@
 package database
 
 import (
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mirage208/redis-go/internal/connection"
 	"github.com/mirage208/redis-go/internal/kvcache"
 	"github.com/mirage208/redis-go/internal/resp"
 )
@@ -13,18 +17,46 @@ const (
 	updatePolicy        // update means update if exists
 )
 
-const unlimitedTTL int64 = 0
-
-func setExecuter(db *SequentialDB, args [][]byte) resp.Reply {
+// setExecuter implements SET key value [NX | XX] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds]. A relative time to live is
+// propagated as PXAT, so that the AOF and the replicas expire the key at the same time.
+func setExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
 	if len(args) < 2 {
 		return resp.MakeErrorReply("ERR wrong number of arguments for 'set' command")
 	}
 	key := string(args[0])
 	value := args[1]
 	policy := upsertPolicy
-	ttl := unlimitedTTL
-	if len(args) > 2 {
-		// todo: handle expiration
+	var expireAt time.Time
+	relative := false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); {
+		case option == "NX" && policy != updatePolicy:
+			policy = insertPolicy
+		case option == "XX" && policy != insertPolicy:
+			policy = updatePolicy
+		case (option == "EX" || option == "PX" || option == "EXAT" || option == "PXAT") && expireAt.IsZero() && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return resp.MakeErrorReply("ERR value is not an integer or out of range")
+			}
+			seconds := option == "EX" || option == "EXAT"
+			if n <= 0 || (seconds && n > math.MaxInt64/1000) {
+				return resp.MakeErrorReply("ERR invalid expire time in 'set' command")
+			}
+			if seconds {
+				n *= 1000
+			}
+			relative = option == "EX" || option == "PX"
+			if relative {
+				expireAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			} else {
+				expireAt = time.UnixMilli(n)
+			}
+		default:
+			return resp.MakeErrorReply("ERR syntax error")
+		}
 	}
 
 	entity := &kvcache.DataEntity{
@@ -42,17 +74,39 @@ func setExecuter(db *SequentialDB, args [][]byte) resp.Reply {
 	default:
 		return resp.MakeErrorReply("ERR unknown policy for 'set' command")
 	}
-	if ok {
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.cache.Expire(key, expireTime)
-		} else {
+	if !ok {
+		return resp.MakeNullBulkReply()
+	}
+	db.dirty++
+	db.notify(notifyString, "set", key)
+	if !expireAt.IsZero() {
+		db.cache.Expire(key, expireAt)
+		db.notify(notifyGeneric, "expire", key)
+		if relative {
+			db.propagateArgs = setWithPXAT(args, expireAt)
 		}
-		return resp.MakeOkReply()
+	} else {
+		// like Redis, a new value discards the time to live of the previous one
+		db.cache.Persist(key)
 	}
 	return resp.MakeOkReply()
 }
-func getExecuter(db *SequentialDB, args [][]byte) resp.Reply {
+
+// setWithPXAT rewrites the arguments of SET with the absolute expire time
+func setWithPXAT(args [][]byte, expireAt time.Time) [][]byte {
+	rewritten := [][]byte{args[0], args[1]}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "EX", "PX":
+			i++
+		default:
+			rewritten = append(rewritten, args[i])
+		}
+	}
+	return append(rewritten, []byte("PXAT"), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10)))
+}
+
+func getExecuter(db *SequentialDB, client *connection.Connection, args [][]byte) resp.Reply {
 	if len(args) < 1 {
 		return resp.MakeErrorReply("ERR wrong number of arguments for 'get' command")
 	}
@@ -69,6 +123,6 @@ func getExecuter(db *SequentialDB, args [][]byte) resp.Reply {
 }
 func init() {
 	// Register all commands
-	registerCommand("set", setExecuter)
-	registerCommand("get", getExecuter)
+	registerCommand("set", setExecuter, flagWrite, singleKey, "string", "slow")
+	registerCommand("get", getExecuter, flagReadonly, singleKey, "string", "fast")
 }
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/mirage208/redis-go/internal/connection"
+)
+
+const invalidateK = ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n"
+
+func TestTrackingDefaultMode(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	reader := connection.NewConn(nil)
+	writer := connection.NewConn(nil)
+	execClient(db, reader, "hello", "3")
+
+	if got := execClient(db, reader, "client", "tracking", "on"); got != "+OK\r\n" {
+		t.Fatalf("unexpected reply to CLIENT TRACKING %q", got)
+	}
+	execClient(db, reader, "get", "k")
+	execClient(db, writer, "set", "k", "1")
+	if got := pushed(reader); len(got) != 1 || got[0] != invalidateK {
+		t.Fatalf("expected an invalidation, got %q", got)
+	}
+	// the key is forgotten once invalidated, until it is read again
+	execClient(db, writer, "set", "k", "2")
+	if got := pushed(reader); len(got) != 0 {
+		t.Errorf("expected no invalidation of a key not read again, got %q", got)
+	}
+
+	// the client is told about its own writes unless NOLOOP is set
+	execClient(db, reader, "get", "k")
+	execClient(db, reader, "set", "k", "3")
+	if got := pushed(reader); len(got) != 1 {
+		t.Errorf("expected an invalidation of its own write, got %q", got)
+	}
+	execClient(db, reader, "client", "tracking", "on", "noloop")
+	execClient(db, reader, "get", "k")
+	execClient(db, reader, "set", "k", "4")
+	if got := pushed(reader); len(got) != 0 {
+		t.Errorf("expected no invalidation with NOLOOP, got %q", got)
+	}
+
+	execClient(db, reader, "client", "tracking", "off")
+	execClient(db, writer, "set", "k", "5")
+	if got := pushed(reader); len(got) != 0 {
+		t.Errorf("expected no invalidation once tracking is off, got %q", got)
+	}
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	reader := connection.NewConn(nil)
+	redirect := connection.NewConn(nil)
+	writer := connection.NewConn(nil)
+
+	if got := execClient(db, reader, "client", "tracking", "on", "redirect", "999999"); got[0] != '-' {
+		t.Errorf("expected an unknown redirect client to be refused, got %q", got)
+	}
+	id := strconv.FormatUint(redirect.ID(), 10)
+	if got := execClient(db, redirect, "client", "id"); got != ":"+id+"\r\n" {
+		t.Fatalf("unexpected CLIENT ID %q", got)
+	}
+	execClient(db, redirect, "subscribe", "__redis__:invalidate")
+	pushed(redirect)
+	execClient(db, reader, "client", "tracking", "on", "redirect", id)
+	if got := execClient(db, reader, "client", "getredir"); got != ":"+id+"\r\n" {
+		t.Errorf("unexpected CLIENT GETREDIR %q", got)
+	}
+	execClient(db, reader, "get", "k")
+	execClient(db, writer, "set", "k", "1")
+	want := "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$1\r\nk\r\n"
+	if got := pushed(redirect); len(got) != 1 || got[0] != want {
+		t.Fatalf("expected a message on the redirect connection, got %q", got)
+	}
+
+	// a RESP2 target subscribed to other channels only receives nothing
+	other := connection.NewConn(nil)
+	execClient(db, other, "subscribe", "news")
+	pushed(other)
+	execClient(db, reader, "client", "tracking", "on", "redirect", strconv.FormatUint(other.ID(), 10))
+	execClient(db, reader, "get", "k")
+	execClient(db, writer, "set", "k", "2")
+	if got := pushed(other); len(got) != 0 {
+		t.Errorf("expected no invalidation without the __redis__:invalidate subscription, got %q", got)
+	}
+	execClient(db, reader, "client", "tracking", "on", "redirect", id)
+
+	db.AfterClientClose(redirect)
+	if got := execClient(db, reader, "client", "trackinginfo"); got != "*6\r\n$5\r\nflags\r\n*2\r\n$2\r\non\r\n$15\r\nbroken_redirect\r\n$8\r\nredirect\r\n:"+id+"\r\n$8\r\nprefixes\r\n*0\r\n" {
+		t.Errorf("unexpected CLIENT TRACKINGINFO %q", got)
+	}
+}
+
+func TestTrackingBroadcast(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	writer := connection.NewConn(nil)
+	execClient(db, client, "hello", "3")
+
+	if got := execClient(db, client, "client", "tracking", "on", "prefix", "a"); got[0] != '-' {
+		t.Errorf("expected PREFIX without BCAST to be refused, got %q", got)
+	}
+	if got := execClient(db, client, "client", "tracking", "on", "bcast", "prefix", "user:", "prefix", "us"); got[0] != '-' {
+		t.Errorf("expected overlapping prefixes to be refused, got %q", got)
+	}
+	execClient(db, client, "client", "tracking", "on", "bcast", "prefix", "user:", "prefix", "k")
+	execClient(db, writer, "set", "k", "1")
+	execClient(db, writer, "set", "other", "1")
+	execClient(db, writer, "hset", "user:1", "f", "v")
+	got := pushed(client)
+	if len(got) != 2 || got[0] != invalidateK || got[1] != ">2\r\n$10\r\ninvalidate\r\n*1\r\n$6\r\nuser:1\r\n" {
+		t.Errorf("expected the keys matching the prefixes to be invalidated, got %q", got)
+	}
+}
+
+func TestTrackingOptIn(t *testing.T) {
+	db := makeTestDB(t)
+	defer db.Close()
+	client := connection.NewConn(nil)
+	writer := connection.NewConn(nil)
+	execClient(db, client, "hello", "3")
+
+	if got := execClient(db, client, "client", "caching", "yes"); got[0] != '-' {
+		t.Errorf("expected CLIENT CACHING to be refused without OPTIN, got %q", got)
+	}
+	execClient(db, client, "client", "tracking", "on", "optin")
+	execClient(db, client, "get", "a")
+	execClient(db, client, "client", "caching", "yes")
+	execClient(db, client, "get", "k")
+	execClient(db, writer, "set", "a", "1")
+	execClient(db, writer, "set", "k", "1")
+	if got := pushed(client); len(got) != 1 || got[0] != invalidateK {
+		t.Errorf("expected only the key read after CLIENT CACHING yes to be invalidated, got %q", got)
+	}
+}
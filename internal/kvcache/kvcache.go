@@ -8,6 +8,12 @@ import (
 type KVCache struct {
 	data map[string]*DataEntity
 	ttl  map[string]time.Time
+
+	// snapshot in progress, every modification goes through its write barrier
+	snapshot *Snapshot
+	epoch    uint64
+
+	onExpired func(key string) // called when an expired key is removed
 }
 
 func NewKVCache() *KVCache {
@@ -17,9 +23,17 @@ func NewKVCache() *KVCache {
 	}
 }
 
+// OnExpired registers f to be called with the keys removed because they expired
+func (c *KVCache) OnExpired(f func(key string)) {
+	c.onExpired = f
+}
+
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 type DataEntity struct {
 	Data any
+
+	// epoch of the snapshot which has already captured this entity
+	epoch uint64
 }
 
 // GetEntity retrieves a value by key from the cache.
@@ -31,24 +45,61 @@ func (c *KVCache) GetEntity(key string) (entity *DataEntity, ok bool) {
 
 	if expireTime, ok := c.ttl[key]; ok {
 		if time.Now().After(expireTime) {
-			delete(c.data, key)
-			delete(c.ttl, key)
+			c.removeExpired(key)
 			return nil, false
 		}
 	}
 	return entity, true
 }
 
+// removeExpired deletes a key whose TTL passed
+func (c *KVCache) removeExpired(key string) {
+	c.beforeWrite(key)
+	delete(c.data, key)
+	delete(c.ttl, key)
+	if c.onExpired != nil {
+		c.onExpired(key)
+	}
+}
+
+// ActiveExpire checks up to n keys with an expiration time, in the random order of map
+// iteration, and removes those expired at now. It returns how many keys were checked
+// and how many were removed, so that the caller can tell whether to go on.
+func (c *KVCache) ActiveExpire(n int, now time.Time) (checked, expired int) {
+	for key, expireTime := range c.ttl {
+		if checked == n {
+			break
+		}
+		checked++
+		if now.After(expireTime) {
+			c.removeExpired(key)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// GetEntityForWrite retrieves a value which the caller is going to modify in place.
+func (c *KVCache) GetEntityForWrite(key string) (entity *DataEntity, ok bool) {
+	entity, ok = c.GetEntity(key)
+	if ok {
+		c.beforeWrite(key)
+	}
+	return entity, ok
+}
+
 // PutEntity inserts or updates a key-value pair in the cache.
 func (c *KVCache) PutEntity(key string, entity *DataEntity) (ok bool) {
-	c.data[key] = entity
+	c.beforeWrite(key)
+	c.data[key] = c.stamp(entity)
 	return true
 }
 
 // PutIfAbsent inserts a key-value pair if the key does not already exist and returns true if the insertion was successful.
 func (c *KVCache) PutIfAbsent(key string, entity *DataEntity) (ok bool) {
 	if _, exists := c.data[key]; !exists {
-		c.data[key] = entity
+		c.beforeWrite(key)
+		c.data[key] = c.stamp(entity)
 		return true
 	}
 	return false
@@ -57,22 +108,46 @@ func (c *KVCache) PutIfAbsent(key string, entity *DataEntity) (ok bool) {
 // PutIfExists updates the value for an existing key and returns true if the key existed.
 func (c *KVCache) PutIfExists(key string, entity *DataEntity) (ok bool) {
 	if _, exists := c.data[key]; exists {
-		c.data[key] = entity
+		c.beforeWrite(key)
+		c.data[key] = c.stamp(entity)
 		return true
 	}
 	return false
 }
 
+// Remove deletes a key with its expiration time and returns true if the key existed.
+func (c *KVCache) Remove(key string) (ok bool) {
+	if _, exists := c.data[key]; !exists {
+		return false
+	}
+	c.beforeWrite(key)
+	delete(c.data, key)
+	delete(c.ttl, key)
+	return true
+}
+
 // Expire sets the expiration time for a key.
 func (c *KVCache) Expire(key string, expireTime time.Time) {
+	c.beforeWrite(key)
 	c.ttl[key] = expireTime
 }
 
 // Persist removes the expiration time for a key, making it persistent.
 func (c *KVCache) Persist(key string) {
+	c.beforeWrite(key)
 	delete(c.ttl, key)
 }
 
+// Len returns the number of keys in the cache, including expired ones not yet removed.
+func (c *KVCache) Len() int {
+	return len(c.data)
+}
+
+// ExpiresLen returns the number of keys with an expiration time.
+func (c *KVCache) ExpiresLen() int {
+	return len(c.ttl)
+}
+
 // ForEach iterates over all key-value pairs in the cache, applying the provided function.
 func (c *KVCache) ForEach(f func(key string, entity *DataEntity, expiration *time.Time) bool) {
 	for key, entity := range c.data {
@@ -0,0 +1,95 @@
+package kvcache
+
+import (
+	"iter"
+	"maps"
+	"time"
+)
+
+// Visitor receives a key of a snapshot with the value and expiration it had when the snapshot began
+type Visitor func(key string, entity *DataEntity, expiration *time.Time)
+
+// Snapshot walks the cache incrementally while commands keep modifying it, yet
+// every key is visited exactly once with its value as of BeginSnapshot.
+//
+// Entities carry the epoch of the last snapshot that captured them. Before a key
+// is modified, the write barrier visits the old value unless it was already
+// captured, and new values are stamped with the current epoch so that the walk
+// skips them.
+type Snapshot struct {
+	cache *KVCache
+	epoch uint64
+	visit Visitor
+	next  func() (string, *DataEntity, bool)
+	stop  func()
+}
+
+// BeginSnapshot starts a snapshot of the cache, only one snapshot may be in progress at a time
+func (c *KVCache) BeginSnapshot(visit Visitor) *Snapshot {
+	if c.snapshot != nil {
+		panic("kvcache: snapshot already in progress")
+	}
+	c.epoch++
+	next, stop := iter.Pull2(maps.All(c.data))
+	c.snapshot = &Snapshot{
+		cache: c,
+		epoch: c.epoch,
+		visit: visit,
+		next:  next,
+		stop:  stop,
+	}
+	return c.snapshot
+}
+
+// Step visits up to n keys, returns true once every key has been visited and the snapshot has ended
+func (s *Snapshot) Step(n int) bool {
+	for i := 0; i < n; {
+		key, entity, ok := s.next()
+		if !ok {
+			s.Abort()
+			return true
+		}
+		if entity.epoch == s.epoch {
+			continue
+		}
+		s.capture(key, entity)
+		i++
+	}
+	return false
+}
+
+// Abort ends the snapshot, the remaining keys are not visited
+func (s *Snapshot) Abort() {
+	s.stop()
+	if s.cache.snapshot == s {
+		s.cache.snapshot = nil
+	}
+}
+
+func (s *Snapshot) capture(key string, entity *DataEntity) {
+	entity.epoch = s.epoch
+	if expiration, ok := s.cache.ttl[key]; ok {
+		s.visit(key, entity, &expiration)
+	} else {
+		s.visit(key, entity, nil)
+	}
+}
+
+// beforeWrite is the write barrier, it must be called before key or its value is modified
+func (c *KVCache) beforeWrite(key string) {
+	s := c.snapshot
+	if s == nil {
+		return
+	}
+	if entity, ok := c.data[key]; ok && entity.epoch != s.epoch {
+		s.capture(key, entity)
+	}
+}
+
+// stamp marks an entity stored during a snapshot so that the snapshot skips it
+func (c *KVCache) stamp(entity *DataEntity) *DataEntity {
+	if c.snapshot != nil {
+		entity.epoch = c.snapshot.epoch
+	}
+	return entity
+}
@@ -0,0 +1,60 @@
+package kvcache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIsPointInTime(t *testing.T) {
+	cache := NewKVCache()
+	for i := 0; i < 1000; i++ {
+		cache.PutEntity("k"+strconv.Itoa(i), &DataEntity{Data: []byte("v" + strconv.Itoa(i))})
+	}
+
+	visited := make(map[string]string)
+	expirations := make(map[string]*time.Time)
+	snapshot := cache.BeginSnapshot(func(key string, entity *DataEntity, expiration *time.Time) {
+		if _, ok := visited[key]; ok {
+			t.Errorf("key %s visited twice", key)
+		}
+		visited[key] = string(entity.Data.([]byte))
+		expirations[key] = expiration
+	})
+
+	snapshot.Step(100)
+	for i := 0; i < 1000; i += 10 {
+		key := "k" + strconv.Itoa(i)
+		switch i % 30 {
+		case 0:
+			cache.PutEntity(key, &DataEntity{Data: []byte("replaced")})
+		case 10:
+			entity, _ := cache.GetEntityForWrite(key)
+			entity.Data = []byte("modified")
+		case 20:
+			cache.Expire(key, time.Now().Add(time.Hour))
+		}
+	}
+	cache.PutEntity("new", &DataEntity{Data: []byte("new")})
+	for !snapshot.Step(100) {
+	}
+
+	if len(visited) != 1000 {
+		t.Errorf("expected 1000 keys, got %d", len(visited))
+	}
+	for i := 0; i < 1000; i++ {
+		key := "k" + strconv.Itoa(i)
+		if visited[key] != "v"+strconv.Itoa(i) {
+			t.Errorf("key %s captured as %s", key, visited[key])
+		}
+		if expirations[key] != nil {
+			t.Errorf("key %s captured with expiration set after snapshot", key)
+		}
+	}
+	if _, ok := visited["new"]; ok {
+		t.Errorf("key created after snapshot should not be visited")
+	}
+
+	// the cache accepts a new snapshot once the previous one is done
+	cache.BeginSnapshot(func(string, *DataEntity, *time.Time) {}).Abort()
+}
@@ -1,8 +1,13 @@
 package persister
 
 import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
 	"time"
 
+	"github.com/mirage208/redis-go/internal/kvcache"
 	"github.com/mirage208/redis-go/internal/resp"
 	"github.com/mirage208/redis-go/pkg/logger"
 )
@@ -15,37 +20,128 @@ const (
 
 const (
 	aofChanSize = 1 << 10
+	// aofMaxBatch bounds how many queued commands share one write and fsync
+	aofMaxBatch = 1 << 10
+	// aofRetryInterval is how often a failed write is retried when no new command arrives
+	aofRetryInterval = time.Second
+	// aofFsyncDelayLimit is how long a pending everysec fsync may lag behind before writes count as delayed
+	aofFsyncDelayLimit = 2 * time.Second
 )
 
+// payload is a command to append, or a sync request when cmdLine is nil
 type payload struct {
 	cmdLine [][]byte
+	// done is called once the commands queued before are written and fsynced
+	done func(err error)
 }
 
+// Fsync flushes the AOF file to disk
 func (p *Persister) Fsync() {
 	if err := p.aofFile.Sync(); err != nil {
 		logger.Errorf("failed to fsync AOF file: %v", err)
 	}
 }
 
+// listenAof is the writer goroutine. Every command queued while the previous
+// batch was being written goes out in a single write, and with the always
+// policy or a sync request a single fsync: clients waiting on the same fsync
+// share its cost.
 func (p *Persister) listenAof() {
-	go func() {
-		for pd := range p.aofChan {
-			p.writeAof(pd)
+	defer close(p.writerDone)
+	ticker := time.NewTicker(aofRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case pd, ok := <-p.aofChan:
+			if !ok {
+				p.writeAof(nil)
+				return
+			}
+			batch := []*payload{pd}
+		collect:
+			for len(batch) < aofMaxBatch {
+				select {
+				case pd, ok := <-p.aofChan:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, pd)
+				default:
+					break collect
+				}
+			}
+			p.writeAof(batch)
+		case <-ticker.C:
+			if len(p.aofBuf) > 0 {
+				p.writeAof(nil)
+			}
 		}
-	}()
+	}
 }
 
-func (p *Persister) writeAof(pd *payload) {
-	data := resp.MakeMultiBulkReply(pd.cmdLine).ToBytes()
-	_, err := p.aofFile.Write(data)
-	if err != nil {
-		logger.Warnf("failed to write AOF file: %v", err)
+// writeAof appends batch to the pending buffer and writes it out, then releases the waiting clients
+func (p *Persister) writeAof(batch []*payload) {
+	sync := p.aofFsync == FsyncAlways
+	for _, pd := range batch {
+		if pd.cmdLine != nil {
+			p.aofBuf = append(p.aofBuf, resp.MakeMultiBulkReply(pd.cmdLine).ToBytes()...)
+		}
+		if pd.done != nil {
+			sync = true
+		}
 	}
-	if p.aofFsync == FsyncAlways {
-		p.Fsync()
+	if p.aofFsync == FsyncEverysec {
+		if start := p.fsyncStart.Load(); start != 0 && time.Since(time.Unix(0, start)) > aofFsyncDelayLimit {
+			p.delayedFsync.Add(1)
+		}
+	}
+
+	err := p.flushAof()
+	if err == nil && sync {
+		err = p.aofFile.Sync()
+	}
+	p.setLastWriteErr(err)
+
+	for _, pd := range batch {
+		if pd.done != nil {
+			pd.done(err)
+		}
 	}
 }
 
+// flushAof writes the pending buffer. After a short write the partial command is
+// truncated away when possible, otherwise only the unwritten tail is kept, and
+// the pending data is retried on the next batch.
+func (p *Persister) flushAof() error {
+	if len(p.aofBuf) == 0 {
+		return nil
+	}
+	n, err := p.aofFile.Write(p.aofBuf)
+	if err == nil {
+		p.aofSize.Add(int64(n))
+		p.aofBuf = p.aofBuf[:0]
+		return nil
+	}
+	logger.Warnf("failed to write AOF file: %v", err)
+	if n > 0 {
+		if terr := p.aofFile.Truncate(p.aofSize.Load()); terr != nil {
+			logger.Warnf("failed to truncate partial write of AOF file: %v", terr)
+			p.aofSize.Add(int64(n))
+			p.aofBuf = append(p.aofBuf[:0], p.aofBuf[n:]...)
+		}
+	}
+	return err
+}
+
+func (p *Persister) setLastWriteErr(err error) {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	if err == nil && p.lastWriteErr != nil {
+		logger.Infof("AOF write error looks solved, the server can accept writes again")
+	}
+	p.lastWriteErr = err
+}
+
 func (p *Persister) fsyncEverySec() {
 	go func() {
 		ticker := time.NewTicker(time.Second)
@@ -53,10 +149,50 @@ func (p *Persister) fsyncEverySec() {
 		for {
 			select {
 			case <-ticker.C:
+				p.fsyncStart.Store(time.Now().UnixNano())
 				p.Fsync()
+				p.fsyncStart.Store(0)
 			case <-p.ctx.Done():
 				return
 			}
 		}
 	}()
 }
+
+// LoadAOF replays the commands of an append only file through exec. A command
+// truncated at the end of the file, as left by a crash, is ignored with a warning.
+// An RDB preamble, which starts the AOF of a replica after a full sync, is loaded
+// into cache before the commands following it are replayed.
+func LoadAOF(filename string, cache *kvcache.KVCache, limits resp.Limits, exec func(cmdLine [][]byte)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if magic, _ := reader.Peek(len(rdbMagic)); string(magic) == rdbMagic {
+		// ReadRDB reads through the same buffered reader, so it stops right after the preamble
+		if err := ReadRDB(reader, cache); err != nil {
+			return err
+		}
+	}
+	for payload := range resp.ParseStreamWithLimits(reader, limits) {
+		if payload.Err != nil {
+			if payload.Err == io.EOF {
+				return nil
+			}
+			if errors.Is(payload.Err, io.ErrUnexpectedEOF) {
+				logger.Warnf("AOF file %s is truncated, the last command is ignored", filename)
+				return nil
+			}
+			return payload.Err
+		}
+		cmd, ok := payload.Data.(*resp.MultiBulkReply)
+		if !ok || len(cmd.Args) == 0 {
+			return errors.New("invalid AOF format: expected a command")
+		}
+		exec(cmd.Args)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package persister
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func TestSyncAfterAppend(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	p, err := NewPersister(filename, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	cmdLines := [][][]byte{
+		{[]byte("set"), []byte("k"), []byte("v")},
+		{[]byte("set"), []byte("k2"), []byte("v2")},
+	}
+	for _, cmdLine := range cmdLines {
+		p.Append(cmdLine)
+	}
+	synced := make(chan error, 1)
+	p.Sync(func(err error) { synced <- err })
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, cmdLine := range cmdLines {
+		want = append(want, resp.MakeMultiBulkReply(cmdLine).ToBytes()...)
+	}
+	if string(data) != string(want) {
+		t.Errorf("commands not on disk once synced: %q", data)
+	}
+	if p.CurrentSize() != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), p.CurrentSize())
+	}
+}
+
+func TestWriteErrorIsReported(t *testing.T) {
+	p, err := NewPersister(filepath.Join(t.TempDir(), "appendonly.aof"), FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writes to a closed file fail the same way a full disk does
+	_ = p.aofFile.Close()
+
+	synced := make(chan error, 1)
+	p.Append([][]byte{[]byte("set"), []byte("k"), []byte("v")})
+	p.Sync(func(err error) { synced <- err })
+	if err := <-synced; err == nil {
+		t.Error("expected the sync to fail")
+	}
+	if p.LastWriteError() == nil {
+		t.Error("expected the write error to be recorded")
+	}
+	close(p.aofChan)
+	<-p.writerDone
+	p.cancel()
+}
+
+func TestDelayedFsync(t *testing.T) {
+	p, err := NewPersister(filepath.Join(t.TempDir(), "appendonly.aof"), FsyncEverysec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	write := func() {
+		synced := make(chan error, 1)
+		p.Append([][]byte{[]byte("set"), []byte("k"), []byte("v")})
+		p.Sync(func(err error) { synced <- err })
+		if err := <-synced; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	if got := p.DelayedFsync(); got != 0 {
+		t.Fatalf("expected no delayed fsync, got %d", got)
+	}
+	// an everysec fsync stuck for longer than aofFsyncDelayLimit delays the writes
+	p.fsyncStart.Store(time.Now().Add(-2 * aofFsyncDelayLimit).UnixNano())
+	write()
+	if got := p.DelayedFsync(); got != 1 {
+		t.Errorf("expected 1 delayed fsync, got %d", got)
+	}
+}
+
+func TestLoadAOF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	commands := [][][]byte{
+		{[]byte("set"), []byte("a"), []byte("1")},
+		{[]byte("set"), []byte("b"), []byte("")},
+	}
+	var data []byte
+	for _, cmdLine := range commands {
+		data = append(data, resp.MakeMultiBulkReply(cmdLine).ToBytes()...)
+	}
+	// a crash in the middle of a write leaves a truncated command
+	data = append(data, "*3\r\n$3\r\nset\r\n$1\r\nc"...)
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded [][][]byte
+	done := make(chan error, 1)
+	go func() {
+		done <- LoadAOF(filename, kvcache.NewKVCache(), resp.DefaultLimits, func(cmdLine [][]byte) {
+			loaded = append(loaded, cmdLine)
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LoadAOF did not return")
+	}
+	if !reflect.DeepEqual(loaded, commands) {
+		t.Errorf("expected %q, got %q", commands, loaded)
+	}
+}
+
+func TestLoadAOFLimits(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	command := [][]byte{[]byte("set"), []byte("a"), bytes.Repeat([]byte("x"), 2048)}
+	if err := os.WriteFile(filename, resp.MakeMultiBulkReply(command).ToBytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the AOF is read with the limits of the requests it replays, proto-max-bulk-len
+	limits := resp.DefaultLimits
+	limits.MaxBulkLen = 1024
+	err := LoadAOF(filename, kvcache.NewKVCache(), limits, func(cmdLine [][]byte) {})
+	var protoErr *resp.ProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Errorf("expected the value to exceed the limit, got %v", err)
+	}
+	limits.MaxBulkLen = 4096
+	if err := LoadAOF(filename, kvcache.NewKVCache(), limits, func(cmdLine [][]byte) {}); err != nil {
+		t.Errorf("expected the value within the limit to load, got %v", err)
+	}
+}
+
+func TestLoadAOFWithPreamble(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	var buf bytes.Buffer
+	if err := WriteRDB(&buf, makeTestCache()); err != nil {
+		t.Fatal(err)
+	}
+	command := [][]byte{[]byte("set"), []byte("after"), []byte("preamble")}
+	buf.Write(resp.MakeMultiBulkReply(command).ToBytes())
+	if err := os.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := kvcache.NewKVCache()
+	var loaded [][][]byte
+	err := LoadAOF(filename, cache, resp.DefaultLimits, func(cmdLine [][]byte) {
+		loaded = append(loaded, cmdLine)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entity, ok := cache.GetEntity("str"); !ok || string(entity.Data.([]byte)) != "hello" {
+		t.Error("expected the preamble to be loaded")
+	}
+	if !reflect.DeepEqual(loaded, [][][]byte{command}) {
+		t.Errorf("expected the command after the preamble, got %q", loaded)
+	}
+}
@@ -0,0 +1,11 @@
+package persister
+
+import "hash/crc64"
+
+// jonesTable is the reflected Jones polynomial used by Redis for RDB checksums
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// crc64Jones continues a Redis style crc64 (no initial or final inversion) over p
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, jonesTable, p)
+}
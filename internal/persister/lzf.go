@@ -0,0 +1,125 @@
+package persister
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// LZF is the compression algorithm used by Redis for RDB strings, see liblzf.
+//
+// A compressed stream is a sequence of chunks, the 3 high bits of the control byte decide the kind:
+//
+//	000LLLLL <L+1 literal bytes>              literal run of 1 to 32 bytes
+//	LLLooooo oooooooo                         back reference of L+2 bytes, L in [1, 6]
+//	111ooooo LLLLLLLL oooooooo                back reference of L+9 bytes
+//
+// A back reference copies from offset o+1 bytes before the current output position.
+const (
+	lzfMaxHashLog = 14
+	lzfMaxLit     = 1 << 5
+	lzfMaxOff     = 1 << 13
+	lzfMaxRef     = (1 << 8) + (1 << 3)
+)
+
+var errLZFCorrupted = errors.New("invalid LZF compressed string")
+
+// lzfCompress compresses in, returns nil if the result would be longer than maxLen
+func lzfCompress(in []byte, maxLen int) []byte {
+	if len(in) < 4 || maxLen <= 0 {
+		return nil
+	}
+	out := make([]byte, 0, maxLen)
+	// the hash table is sized after the input, short strings are the common case
+	hashLog := min(bits.Len(uint(len(in))), lzfMaxHashLog)
+	table := make([]int32, 1<<hashLog) // position + 1 of the last sequence with the same hash
+	litStart := 0
+	ip := 0
+	for ip+2 < len(in) {
+		h := (uint32(in[ip])<<16 | uint32(in[ip+1])<<8 | uint32(in[ip+2])) * 2654435761 >> (32 - hashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(ip + 1)
+		off := ip - ref - 1
+		if ref < 0 || off >= lzfMaxOff || in[ref] != in[ip] || in[ref+1] != in[ip+1] || in[ref+2] != in[ip+2] {
+			ip++
+			continue
+		}
+
+		length := 3
+		maxRef := min(lzfMaxRef, len(in)-ip)
+		for length < maxRef && in[ref+length] == in[ip+length] {
+			length++
+		}
+		out = lzfAppendLiterals(out, in[litStart:ip])
+		l := length - 2
+		if l < 7 {
+			out = append(out, byte(l<<5|off>>8))
+		} else {
+			out = append(out, byte(7<<5|off>>8), byte(l-7))
+		}
+		out = append(out, byte(off))
+		if len(out) > maxLen {
+			return nil
+		}
+		ip += length
+		litStart = ip
+	}
+	out = lzfAppendLiterals(out, in[litStart:])
+	if len(out) > maxLen {
+		return nil
+	}
+	return out
+}
+
+func lzfAppendLiterals(out []byte, literals []byte) []byte {
+	for len(literals) > 0 {
+		n := min(len(literals), lzfMaxLit)
+		out = append(out, byte(n-1))
+		out = append(out, literals[:n]...)
+		literals = literals[n:]
+	}
+	return out
+}
+
+// lzfDecompress decompresses in which must expand to exactly outLen bytes
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLit {
+			length := ctrl + 1
+			if i+length > len(in) || len(out)+length > outLen {
+				return nil, errLZFCorrupted
+			}
+			out = append(out, in[i:i+length]...)
+			i += length
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errLZFCorrupted
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZFCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, errLZFCorrupted
+		}
+		// byte by byte since the reference may overlap with the bytes being written
+		for j := 0; j < length; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errLZFCorrupted
+	}
+	return out, nil
+}
@@ -0,0 +1,45 @@
+package persister
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestLZFRoundTrip(t *testing.T) {
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		bytes.Repeat([]byte("abcdefgh"), 1000),
+		append(bytes.Repeat([]byte("0123456789"), 50), random...),
+	}
+	for _, in := range inputs {
+		compressed := lzfCompress(in, len(in)-4)
+		if compressed == nil {
+			t.Fatalf("failed to compress %d bytes", len(in))
+		}
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil {
+			t.Fatalf("decompress: %v", err)
+		}
+		if !bytes.Equal(in, out) {
+			t.Errorf("round trip mismatch")
+		}
+	}
+
+	if compressed := lzfCompress(random, len(random)-4); compressed != nil {
+		t.Errorf("random data should not be compressible")
+	}
+}
+
+func TestLZFDecompressCorrupted(t *testing.T) {
+	// back reference before the start of output
+	if _, err := lzfDecompress([]byte{0x20, 0x05}, 3); err == nil {
+		t.Errorf("expected error")
+	}
+	// literal run longer than input
+	if _, err := lzfDecompress([]byte{0x05, 'a'}, 6); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,206 @@
+package persister
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Compact encodings Redis uses for small collections. They are only decoded on
+// load, every entry is converted to its string form.
+
+var errPackedCorrupted = errors.New("invalid packed encoding")
+
+// parseZiplist decodes a ziplist:
+//
+//	[zlbytes uint32][zltail uint32][zllen uint16][entry]...[0xFF]
+//
+// entry is [prevlen][encoding][data], prevlen takes 1 byte or 0xFE followed by 4 bytes
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errPackedCorrupted
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(buf[8:10]))
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, errPackedCorrupted
+		}
+		if buf[pos] == 0xFF {
+			return entries, nil
+		}
+		if buf[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(buf) {
+			return nil, errPackedCorrupted
+		}
+
+		enc := buf[pos]
+		var entry []byte
+		switch enc >> 6 {
+		case 0: // 00pppppp, string up to 63 bytes
+			entry, pos = packedString(buf, pos+1, int(enc&0x3f))
+		case 1: // 01pppppp qqqqqqqq, string up to 16383 bytes
+			if pos+2 > len(buf) {
+				return nil, errPackedCorrupted
+			}
+			entry, pos = packedString(buf, pos+2, int(enc&0x3f)<<8|int(buf[pos+1]))
+		case 2: // 10000000 + 4 bytes big endian length
+			if pos+5 > len(buf) {
+				return nil, errPackedCorrupted
+			}
+			entry, pos = packedString(buf, pos+5, int(binary.BigEndian.Uint32(buf[pos+1:pos+5])))
+		default:
+			var v int64
+			var size int
+			switch enc {
+			case 0xC0:
+				v, size = packedInt(buf, pos+1, 2)
+			case 0xD0:
+				v, size = packedInt(buf, pos+1, 4)
+			case 0xE0:
+				v, size = packedInt(buf, pos+1, 8)
+			case 0xF0:
+				v, size = packedInt(buf, pos+1, 3)
+			case 0xFE:
+				v, size = packedInt(buf, pos+1, 1)
+			default: // 1111xxxx, immediate value xxxx-1 in [0, 12]
+				if enc < 0xF1 || enc > 0xFD {
+					return nil, errPackedCorrupted
+				}
+				v, size = int64(enc&0x0f)-1, 0
+			}
+			if size < 0 {
+				return nil, errPackedCorrupted
+			}
+			entry, pos = strconv.AppendInt(nil, v, 10), pos+1+size
+		}
+		if entry == nil {
+			return nil, errPackedCorrupted
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// parseListpack decodes a listpack:
+//
+//	[total bytes uint32][num elements uint16][entry]...[0xFF]
+//
+// entry is [encoding][data][backlen], backlen is the size of encoding and data in 1 to 5 bytes
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, errPackedCorrupted
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(buf[4:6]))
+	pos := 6
+	for {
+		if pos >= len(buf) {
+			return nil, errPackedCorrupted
+		}
+		enc := buf[pos]
+		if enc == 0xFF {
+			return entries, nil
+		}
+
+		start := pos
+		var entry []byte
+		switch {
+		case enc&0x80 == 0: // 0xxxxxxx, 7 bit unsigned integer
+			entry, pos = strconv.AppendInt(nil, int64(enc), 10), pos+1
+		case enc&0xC0 == 0x80: // 10xxxxxx, string up to 63 bytes
+			entry, pos = packedString(buf, pos+1, int(enc&0x3f))
+		case enc&0xE0 == 0xC0: // 110xxxxx yyyyyyyy, 13 bit signed integer
+			if pos+2 > len(buf) {
+				return nil, errPackedCorrupted
+			}
+			v := int64(enc&0x1f)<<8 | int64(buf[pos+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			entry, pos = strconv.AppendInt(nil, v, 10), pos+2
+		case enc&0xF0 == 0xE0: // 1110xxxx yyyyyyyy, string up to 4095 bytes
+			if pos+2 > len(buf) {
+				return nil, errPackedCorrupted
+			}
+			entry, pos = packedString(buf, pos+2, int(enc&0x0f)<<8|int(buf[pos+1]))
+		case enc == 0xF0: // 4 bytes little endian length
+			if pos+5 > len(buf) {
+				return nil, errPackedCorrupted
+			}
+			entry, pos = packedString(buf, pos+5, int(binary.LittleEndian.Uint32(buf[pos+1:pos+5])))
+		case enc >= 0xF1 && enc <= 0xF4:
+			size := [...]int{2, 3, 4, 8}[enc-0xF1]
+			v, n := packedInt(buf, pos+1, size)
+			if n < 0 {
+				return nil, errPackedCorrupted
+			}
+			entry, pos = strconv.AppendInt(nil, v, 10), pos+1+n
+		default:
+			return nil, errPackedCorrupted
+		}
+		if entry == nil {
+			return nil, errPackedCorrupted
+		}
+		entries = append(entries, entry)
+		pos += listpackBacklenSize(pos - start)
+	}
+}
+
+func listpackBacklenSize(entrySize int) int {
+	switch {
+	case entrySize <= 127:
+		return 1
+	case entrySize < 16383:
+		return 2
+	case entrySize < 2097151:
+		return 3
+	case entrySize < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseIntset decodes an intset: [encoding uint32][length uint32][contents],
+// encoding is the byte width of every element
+func parseIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, errPackedCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(buf[0:4]))
+	length := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if (width != 2 && width != 4 && width != 8) || len(buf) < 8+width*length {
+		return nil, errPackedCorrupted
+	}
+	entries := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		v, _ := packedInt(buf, 8+i*width, width)
+		entries = append(entries, strconv.AppendInt(nil, v, 10))
+	}
+	return entries, nil
+}
+
+// packedString returns a copy of buf[pos:pos+length] and the position after it, nil if out of range
+func packedString(buf []byte, pos int, length int) ([]byte, int) {
+	if pos+length > len(buf) {
+		return nil, pos
+	}
+	return append(make([]byte, 0, length), buf[pos:pos+length]...), pos + length
+}
+
+// packedInt reads a little endian signed integer of size bytes, returns size -1 if out of range
+func packedInt(buf []byte, pos int, size int) (int64, int) {
+	if pos+size > len(buf) {
+		return 0, -1
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[pos+i])
+	}
+	// sign extension
+	shift := 64 - 8*uint(size)
+	return int64(v<<shift) >> shift, size
+}
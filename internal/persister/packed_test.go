@@ -0,0 +1,82 @@
+package persister
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func toStrings(items [][]byte) []string {
+	result := make([]string, len(items))
+	for i, item := range items {
+		result[i] = string(item)
+	}
+	return result
+}
+
+func TestParseZiplist(t *testing.T) {
+	body := []byte{
+		0x00, 0x02, 'h', 'i', // string "hi"
+		0x04, 0xFD, // immediate 12
+		0x02, 0xFE, 0x9C, // int8 -100
+		0x03, 0xC0, 0xE8, 0x03, // int16 1000
+		0x04, 0xF0, 0x00, 0x00, 0x80, // int24 -8388608
+		0x05, 0xE0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F, // int64 max
+	}
+	buf := make([]byte, 10, 11+len(body))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(11+len(body)))
+	binary.LittleEndian.PutUint16(buf[8:10], 6)
+	buf = append(append(buf, body...), 0xFF)
+
+	items, err := parseZiplist(buf)
+	if err != nil {
+		t.Fatalf("parse ziplist: %v", err)
+	}
+	expected := []string{"hi", "12", "-100", "1000", "-8388608", "9223372036854775807"}
+	if !reflect.DeepEqual(toStrings(items), expected) {
+		t.Errorf("expected %v, got %v", expected, toStrings(items))
+	}
+
+	if _, err = parseZiplist(buf[:len(buf)-1]); err == nil {
+		t.Errorf("expected error for truncated ziplist")
+	}
+}
+
+func TestParseListpack(t *testing.T) {
+	body := []byte{
+		0x05, 0x01, // uint7 5
+		0x82, 'h', 'i', 0x03, // string "hi"
+		0xDF, 0xFF, 0x02, // int13 -1
+		0xF1, 0xE8, 0x03, 0x03, // int16 1000
+		0xF3, 0x00, 0x00, 0x00, 0x80, 0x05, // int32 min
+	}
+	buf := make([]byte, 6, 7+len(body))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(7+len(body)))
+	binary.LittleEndian.PutUint16(buf[4:6], 5)
+	buf = append(append(buf, body...), 0xFF)
+
+	items, err := parseListpack(buf)
+	if err != nil {
+		t.Fatalf("parse listpack: %v", err)
+	}
+	expected := []string{"5", "hi", "-1", "1000", "-2147483648"}
+	if !reflect.DeepEqual(toStrings(items), expected) {
+		t.Errorf("expected %v, got %v", expected, toStrings(items))
+	}
+}
+
+func TestParseIntset(t *testing.T) {
+	buf := []byte{
+		0x02, 0x00, 0x00, 0x00, // encoding int16
+		0x02, 0x00, 0x00, 0x00, // length
+		0xFF, 0xFF, 0x03, 0x00,
+	}
+	items, err := parseIntset(buf)
+	if err != nil {
+		t.Fatalf("parse intset: %v", err)
+	}
+	expected := []string{"-1", "3"}
+	if !reflect.DeepEqual(toStrings(items), expected) {
+		t.Errorf("expected %v, got %v", expected, toStrings(items))
+	}
+}
@@ -3,27 +3,43 @@ package persister
 import (
 	"context"
 	"os"
+	"sync"
+	"sync/atomic"
 
-	"github.com/mirage208/redis-go/internal/database"
 	"github.com/mirage208/redis-go/pkg/logger"
 )
 
 type Persister struct {
 	ctx         context.Context
 	cancel      context.CancelFunc
-	db          database.DB
 	aofFileName string
 	aofFsync    string
 	aofFile     *os.File
 	aofChan     chan *payload
+	writerDone  chan struct{}
+
+	// owned by the writer goroutine
+	aofBuf []byte // commands not written yet
+
+	aofSize      atomic.Int64
+	fsyncStart   atomic.Int64 // unix nanoseconds of the everysec fsync in progress, 0 if none
+	delayedFsync atomic.Int64
+
+	statusMu     sync.Mutex
+	lastWriteErr error
 }
 
-func NewPersister(db database.DB, aofFileName string, aofFync string) (*Persister, error) {
+func NewPersister(aofFileName string, aofFync string) (*Persister, error) {
+	switch aofFync {
+	case FsyncAlways, FsyncEverysec, FsyncNo:
+	default:
+		aofFync = FsyncEverysec
+	}
 	persister := &Persister{
-		db:          db,
 		aofFileName: aofFileName,
 		aofFsync:    aofFync,
 		aofChan:     make(chan *payload, aofChanSize),
+		writerDone:  make(chan struct{}),
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -31,28 +47,70 @@ func NewPersister(db database.DB, aofFileName string, aofFync string) (*Persiste
 
 	aofFile, err := os.OpenFile(persister.aofFileName, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	persister.aofFile = aofFile
+	if info, err := aofFile.Stat(); err == nil {
+		persister.aofSize.Store(info.Size())
+	}
 
-	persister.listenAof()
+	go persister.listenAof()
 	if persister.aofFsync == FsyncEverysec {
 		persister.fsyncEverySec()
 	}
 	return persister, nil
 }
 
+// FsyncPolicy returns one of FsyncAlways, FsyncEverysec and FsyncNo
+func (p *Persister) FsyncPolicy() string {
+	return p.aofFsync
+}
+
+// Append queues a write command, it never waits for the disk
+func (p *Persister) Append(cmdLine [][]byte) {
+	p.aofChan <- &payload{cmdLine: cmdLine}
+}
+
+// Sync calls done on the writer goroutine once every command appended before
+// is written and fsynced, with the error if that failed. Commands appended
+// together are made durable by a single Sync.
+func (p *Persister) Sync(done func(err error)) {
+	p.aofChan <- &payload{done: done}
+}
+
+// LastWriteError returns the error of the last write to the AOF file, nil once a write succeeded again
+func (p *Persister) LastWriteError() error {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	return p.lastWriteErr
+}
+
+// DelayedFsync returns how many writes went on while an everysec fsync was lagging behind
+func (p *Persister) DelayedFsync() int64 {
+	return p.delayedFsync.Load()
+}
+
+// CurrentSize returns the size of the AOF file in bytes
+func (p *Persister) CurrentSize() int64 {
+	return p.aofSize.Load()
+}
+
+// Close writes the commands still queued, fsyncs and closes the file. Nothing may be appended afterwards.
 func (p *Persister) Close() error {
 	if p == nil {
 		return nil
 	}
+	close(p.aofChan)
+	<-p.writerDone
+	p.cancel()
 	if p.aofFile != nil {
+		p.Fsync()
 		err := p.aofFile.Close()
 		if err != nil {
 			logger.Warnf("failed to close AOF file: %v", err)
 			return err
 		}
 	}
-	p.cancel()
 	return nil
 }
@@ -1,21 +1,121 @@
 package persister
 
 import (
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
 
-	"github.com/mirage208/redis-go/internal/config"
-	"github.com/mirage208/redis-go/pkg/logger"
+	"github.com/mirage208/redis-go/internal/kvcache"
 )
 
-func (p *Persister) GenerateRDB(rdbFilename string) error {
-	file, err := os.CreateTemp(config.GetTmpDir(), "rdb-*.rdb")
+// Snapshots use the Redis RDB format so dumps can be exchanged with real Redis:
+//
+//	"REDIS" [VERSION 4 ascii digits]
+//	[AUX key value]...
+//	[SELECTDB db][RESIZEDB size expires-size]
+//	[EXPIRETIME_MS ms]? [TYPE][KEY][VALUE]...
+//	[EOF][CRC64 (8 bytes, little endian)]
+//
+// Dumps are written with RDB_VERSION 9 and plain (non packed) value types so that
+// any Redis since 5.0 can load them. Loading accepts every version up to 11,
+// including the ziplist, listpack, intset and quicklist encodings.
+const (
+	rdbMagic          = "REDIS"
+	rdbVersion        = 9
+	rdbMaxLoadVersion = 11
+
+	// maxRDBLength guards allocations against corrupted length prefixes
+	maxRDBLength = 512 << 20
+)
+
+// value types
+const (
+	rdbTypeString         byte = 0
+	rdbTypeList           byte = 1
+	rdbTypeSet            byte = 2
+	rdbTypeZSet           byte = 3
+	rdbTypeHash           byte = 4
+	rdbTypeZSet2          byte = 5
+	rdbTypeHashZipmap     byte = 9
+	rdbTypeListZiplist    byte = 10
+	rdbTypeSetIntset      byte = 11
+	rdbTypeZSetZiplist    byte = 12
+	rdbTypeHashZiplist    byte = 13
+	rdbTypeListQuicklist  byte = 14
+	rdbTypeHashListpack   byte = 16
+	rdbTypeZSetListpack   byte = 17
+	rdbTypeListQuicklist2 byte = 18
+	rdbTypeSetListpack    byte = 20
+)
+
+// opcodes
+const (
+	rdbOpcodeFunction2    byte = 0xF5
+	rdbOpcodeModuleAux    byte = 0xF7
+	rdbOpcodeIdle         byte = 0xF8
+	rdbOpcodeFreq         byte = 0xF9
+	rdbOpcodeAux          byte = 0xFA
+	rdbOpcodeResizeDB     byte = 0xFB
+	rdbOpcodeExpireTimeMs byte = 0xFC
+	rdbOpcodeExpireTime   byte = 0xFD
+	rdbOpcodeSelectDB     byte = 0xFE
+	rdbOpcodeEOF          byte = 0xFF
+)
+
+// length encoding, the 2 high bits of the first byte
+const (
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// quicklist node containers
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+var (
+	ErrInvalidRDBFormat   = errors.New("invalid RDB format")
+	ErrUnsupportedVersion = errors.New("unsupported RDB version")
+	ErrRDBChecksum        = errors.New("RDB checksum mismatch")
+)
+
+// SaveRDBFile writes a snapshot through a temp file in the same directory and
+// renames it to filename, so a crash never leaves a truncated snapshot behind
+func SaveRDBFile(filename string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
 	if err != nil {
-		logger.Warnf("failed to create RDB file: %v", err)
 		return err
 	}
-	defer file.Close()
-
-	// todo: implement RDB generation logic
+	tmpName := file.Name()
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
 
-	return nil
+// LoadRDBFile loads the snapshot stored in filename into cache
+func LoadRDBFile(filename string, cache *kvcache.KVCache) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ReadRDB(file, cache)
 }
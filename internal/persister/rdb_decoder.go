@@ -0,0 +1,505 @@
+package persister
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/mirage208/redis-go/common/datastruct/dict"
+	"github.com/mirage208/redis-go/common/datastruct/list"
+	"github.com/mirage208/redis-go/common/datastruct/set"
+	"github.com/mirage208/redis-go/common/datastruct/sortedset"
+	"github.com/mirage208/redis-go/internal/kvcache"
+	"github.com/mirage208/redis-go/pkg/logger"
+)
+
+// ReadRDB decodes a Redis RDB snapshot and puts every unexpired entity into cache.
+// Keys of databases other than 0 are skipped since only a single keyspace exists.
+func ReadRDB(r io.Reader, cache *kvcache.KVCache) error {
+	dec := &rdbDecoder{r: bufio.NewReader(r)}
+	header := make([]byte, len(rdbMagic)+4)
+	if err := dec.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:len(rdbMagic)]) != rdbMagic {
+		return ErrInvalidRDBFormat
+	}
+	version, err := strconv.Atoi(string(header[len(rdbMagic):]))
+	if err != nil {
+		return ErrInvalidRDBFormat
+	}
+	if version < 1 || version > rdbMaxLoadVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	now := time.Now()
+	dbIndex := uint64(0)
+	skipped := 0
+	var expiration *time.Time
+	for {
+		opcode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case rdbOpcodeExpireTime:
+			var buf [4]byte
+			if err = dec.readFull(buf[:]); err != nil {
+				return err
+			}
+			t := time.Unix(int64(int32(binary.LittleEndian.Uint32(buf[:]))), 0)
+			expiration = &t
+			continue
+		case rdbOpcodeExpireTimeMs:
+			var buf [8]byte
+			if err = dec.readFull(buf[:]); err != nil {
+				return err
+			}
+			t := time.UnixMilli(int64(binary.LittleEndian.Uint64(buf[:])))
+			expiration = &t
+			continue
+		case rdbOpcodeFreq:
+			if _, err = dec.readByte(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeIdle:
+			if _, err = dec.readLength(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeSelectDB:
+			if dbIndex, err = dec.readLength(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeResizeDB:
+			if _, err = dec.readLength(); err != nil {
+				return err
+			}
+			if _, err = dec.readLength(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeAux:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			val, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			if string(key) == "redis-ver" {
+				logger.Infof("loading RDB produced by version %s", val)
+			}
+			continue
+		case rdbOpcodeFunction2:
+			// functions are not supported, the library code is dropped
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			logger.Warn("RDB contains a function library, skipped")
+			continue
+		case rdbOpcodeModuleAux:
+			return fmt.Errorf("%w: module aux data is not supported", ErrInvalidRDBFormat)
+		case rdbOpcodeEOF:
+			if skipped > 0 {
+				logger.Warnf("skipped %d keys of databases other than 0", skipped)
+			}
+			return dec.verifyChecksum(version)
+		}
+
+		key, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		entity, err := dec.readValue(opcode)
+		if err != nil {
+			return fmt.Errorf("load key %s: %w", key, err)
+		}
+		switch {
+		case dbIndex != 0:
+			skipped++
+		case expiration != nil && expiration.Before(now):
+		default:
+			cache.PutEntity(string(key), entity)
+			if expiration != nil {
+				cache.Expire(string(key), *expiration)
+			}
+		}
+		expiration = nil
+	}
+}
+
+type rdbDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (d *rdbDecoder) readFull(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		return err
+	}
+	d.crc = crc64Jones(d.crc, p)
+	return nil
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	var buf [1]byte
+	if err := d.readFull(buf[:]); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// verifyChecksum checks the trailing crc64, which exists since version 5 and is 0 when disabled
+func (d *rdbDecoder) verifyChecksum(version int) error {
+	if version < 5 {
+		return nil
+	}
+	expected := d.crc
+	var sum [8]byte
+	if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+		return err
+	}
+	actual := binary.LittleEndian.Uint64(sum[:])
+	if actual != 0 && actual != expected {
+		return ErrRDBChecksum
+	}
+	return nil
+}
+
+// readRawLength decodes a length, encoded reports whether it is actually a special string encoding
+func (d *rdbDecoder) readRawLength() (n uint64, encoded bool, err error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case rdb6BitLen:
+		return uint64(first & 0x3f), false, nil
+	case rdb14BitLen:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case rdbEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case rdb32BitLen:
+		var buf [4]byte
+		if err = d.readFull(buf[:]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf[:])), false, nil
+	case rdb64BitLen:
+		var buf [8]byte
+		if err = d.readFull(buf[:]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf[:]), false, nil
+	default:
+		return 0, false, fmt.Errorf("%w: unknown length encoding %x", ErrInvalidRDBFormat, first)
+	}
+}
+
+func (d *rdbDecoder) readLength() (uint64, error) {
+	n, encoded, err := d.readRawLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, fmt.Errorf("%w: unexpected string encoding", ErrInvalidRDBFormat)
+	}
+	return n, nil
+}
+
+func (d *rdbDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > maxRDBLength {
+		return nil, fmt.Errorf("%w: length %d too large", ErrInvalidRDBFormat, n)
+	}
+	buf := make([]byte, n)
+	if err := d.readFull(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readString decodes a raw, integer or LZF encoded string
+func (d *rdbDecoder) readString() ([]byte, error) {
+	n, encoded, err := d.readRawLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return d.readBytes(n)
+	}
+	switch n {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		buf, err := d.readBytes(1 << n)
+		if err != nil {
+			return nil, err
+		}
+		v, _ := packedInt(buf, 0, len(buf))
+		return strconv.AppendInt(nil, v, 10), nil
+	case rdbEncLZF:
+		compressedLen, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		length, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if length > maxRDBLength {
+			return nil, fmt.Errorf("%w: length %d too large", ErrInvalidRDBFormat, length)
+		}
+		compressed, err := d.readBytes(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(length))
+	default:
+		return nil, fmt.Errorf("%w: unknown string encoding %d", ErrInvalidRDBFormat, n)
+	}
+}
+
+// readDouble decodes the string form of a double used by the legacy zset type
+func (d *rdbDecoder) readDouble() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.readBytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (d *rdbDecoder) readBinaryDouble() (float64, error) {
+	var buf [8]byte
+	if err := d.readFull(buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// readStrings decodes a length followed by that many strings
+func (d *rdbDecoder) readStrings(multiplier uint64) ([][]byte, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRDBLength/multiplier {
+		return nil, fmt.Errorf("%w: length %d too large", ErrInvalidRDBFormat, n)
+	}
+	n *= multiplier
+	// the length is not trusted for the allocation, items grow as strings are read
+	items := make([][]byte, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		item, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readPacked reads a string holding a packed encoding and decodes it with parse
+func (d *rdbDecoder) readPacked(parse func([]byte) ([][]byte, error)) ([][]byte, error) {
+	buf, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(buf)
+}
+
+func (d *rdbDecoder) readValue(valueType byte) (*kvcache.DataEntity, error) {
+	switch valueType {
+	case rdbTypeString:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &kvcache.DataEntity{Data: s}, nil
+	case rdbTypeList:
+		items, err := d.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return makeListEntity(items), nil
+	case rdbTypeListZiplist:
+		items, err := d.readPacked(parseZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return makeListEntity(items), nil
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		return d.readQuicklist(valueType)
+	case rdbTypeSet:
+		items, err := d.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return makeSetEntity(items), nil
+	case rdbTypeSetIntset:
+		items, err := d.readPacked(parseIntset)
+		if err != nil {
+			return nil, err
+		}
+		return makeSetEntity(items), nil
+	case rdbTypeSetListpack:
+		items, err := d.readPacked(parseListpack)
+		if err != nil {
+			return nil, err
+		}
+		return makeSetEntity(items), nil
+	case rdbTypeZSet, rdbTypeZSet2:
+		n, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		zset := sortedset.Make()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == rdbTypeZSet2 {
+				score, err = d.readBinaryDouble()
+			} else {
+				score, err = d.readDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(member), score)
+		}
+		return &kvcache.DataEntity{Data: zset}, nil
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		parse := parseZiplist
+		if valueType == rdbTypeZSetListpack {
+			parse = parseListpack
+		}
+		items, err := d.readPacked(parse)
+		if err != nil {
+			return nil, err
+		}
+		if len(items)%2 != 0 {
+			return nil, errPackedCorrupted
+		}
+		zset := sortedset.Make()
+		for i := 0; i < len(items); i += 2 {
+			score, err := strconv.ParseFloat(string(items[i+1]), 64)
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(items[i]), score)
+		}
+		return &kvcache.DataEntity{Data: zset}, nil
+	case rdbTypeHash:
+		items, err := d.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return makeHashEntity(items)
+	case rdbTypeHashZiplist, rdbTypeHashListpack:
+		parse := parseZiplist
+		if valueType == rdbTypeHashListpack {
+			parse = parseListpack
+		}
+		items, err := d.readPacked(parse)
+		if err != nil {
+			return nil, err
+		}
+		return makeHashEntity(items)
+	case rdbTypeHashZipmap:
+		return nil, fmt.Errorf("%w: zipmap encoding is not supported", ErrInvalidRDBFormat)
+	default:
+		return nil, fmt.Errorf("%w: unsupported value type %d", ErrInvalidRDBFormat, valueType)
+	}
+}
+
+// readQuicklist decodes a list stored as nodes of ziplists (version 1) or
+// of plain and listpack containers (version 2)
+func (d *rdbDecoder) readQuicklist(valueType byte) (*kvcache.DataEntity, error) {
+	nodes, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	l := list.NewQuickList()
+	for i := uint64(0); i < nodes; i++ {
+		container := uint64(quicklistNodePacked)
+		if valueType == rdbTypeListQuicklist2 {
+			if container, err = d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quicklistNodePlain {
+			l.Add(buf)
+			continue
+		}
+		var items [][]byte
+		if valueType == rdbTypeListQuicklist2 {
+			items, err = parseListpack(buf)
+		} else {
+			items, err = parseZiplist(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			l.Add(item)
+		}
+	}
+	return &kvcache.DataEntity{Data: l}, nil
+}
+
+func makeListEntity(items [][]byte) *kvcache.DataEntity {
+	l := list.NewQuickList()
+	for _, item := range items {
+		l.Add(item)
+	}
+	return &kvcache.DataEntity{Data: l}
+}
+
+func makeSetEntity(items [][]byte) *kvcache.DataEntity {
+	s := set.NewSequentialSet()
+	for _, item := range items {
+		s.Add(string(item))
+	}
+	return &kvcache.DataEntity{Data: s}
+}
+
+func makeHashEntity(items [][]byte) (*kvcache.DataEntity, error) {
+	if len(items)%2 != 0 {
+		return nil, errPackedCorrupted
+	}
+	hash := dict.NewSequentialDict()
+	for i := 0; i < len(items); i += 2 {
+		hash.Put(string(items[i]), items[i+1])
+	}
+	return &kvcache.DataEntity{Data: hash}, nil
+}
@@ -0,0 +1,295 @@
+package persister
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"time"
+
+	"github.com/mirage208/redis-go/common/datastruct/dict"
+	"github.com/mirage208/redis-go/common/datastruct/list"
+	"github.com/mirage208/redis-go/common/datastruct/set"
+	"github.com/mirage208/redis-go/common/datastruct/sortedset"
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/kvcache"
+)
+
+// WriteRDB encodes every entity of cache, including expiration, into w
+func WriteRDB(w io.Writer, cache *kvcache.KVCache) error {
+	enc := NewRDBEncoder(w)
+	if err := enc.WriteHeader(cache.Len(), cache.ExpiresLen()); err != nil {
+		return err
+	}
+	var err error
+	cache.ForEach(func(key string, entity *kvcache.DataEntity, expiration *time.Time) bool {
+		err = enc.WriteEntry(key, entity, expiration)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return enc.WriteFooter()
+}
+
+// RDBEncoder writes a snapshot piece by piece: WriteHeader once, WriteEntry for
+// every key in any order and finally WriteFooter.
+type RDBEncoder struct {
+	w   *bufio.Writer
+	crc uint64
+	buf [9]byte
+}
+
+func NewRDBEncoder(w io.Writer) *RDBEncoder {
+	return &RDBEncoder{w: bufio.NewWriter(w)}
+}
+
+// WriteHeader writes the magic, aux fields and the header of database 0, sizes are only hints for the loader
+func (e *RDBEncoder) WriteHeader(size int, expiresSize int) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.writeDBHeader(0, size, expiresSize)
+}
+
+// Flush writes buffered data to the underlying writer
+func (e *RDBEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *RDBEncoder) write(p []byte) error {
+	e.crc = crc64Jones(e.crc, p)
+	_, err := e.w.Write(p)
+	return err
+}
+
+func (e *RDBEncoder) writeByte(b byte) error {
+	e.buf[0] = b
+	return e.write(e.buf[:1])
+}
+
+func (e *RDBEncoder) writeHeader() error {
+	if err := e.write([]byte(fmt.Sprintf("%s%04d", rdbMagic, rdbVersion))); err != nil {
+		return err
+	}
+	aux := [][2]string{
+		{"redis-ver", config.RedisVersion},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"used-mem", strconv.FormatUint(usedMemory(), 10)},
+	}
+	for _, kv := range aux {
+		if err := e.writeByte(rdbOpcodeAux); err != nil {
+			return err
+		}
+		if err := e.writeString([]byte(kv[0])); err != nil {
+			return err
+		}
+		if err := e.writeString([]byte(kv[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *RDBEncoder) writeDBHeader(index int, size int, expiresSize int) error {
+	if err := e.writeByte(rdbOpcodeSelectDB); err != nil {
+		return err
+	}
+	if err := e.writeLength(uint64(index)); err != nil {
+		return err
+	}
+	if err := e.writeByte(rdbOpcodeResizeDB); err != nil {
+		return err
+	}
+	if err := e.writeLength(uint64(size)); err != nil {
+		return err
+	}
+	return e.writeLength(uint64(expiresSize))
+}
+
+// WriteFooter writes the EOF opcode and checksum then flushes
+func (e *RDBEncoder) WriteFooter() error {
+	if err := e.writeByte(rdbOpcodeEOF); err != nil {
+		return err
+	}
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc)
+	if _, err := e.w.Write(sum[:]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// writeLength uses the smallest of the 6, 14, 32 and 64 bit length encodings
+func (e *RDBEncoder) writeLength(n uint64) error {
+	switch {
+	case n < 1<<6:
+		return e.writeByte(byte(n) | rdb6BitLen<<6)
+	case n < 1<<14:
+		e.buf[0] = byte(n>>8) | rdb14BitLen<<6
+		e.buf[1] = byte(n)
+		return e.write(e.buf[:2])
+	case n <= math.MaxUint32:
+		e.buf[0] = rdb32BitLen
+		binary.BigEndian.PutUint32(e.buf[1:5], uint32(n))
+		return e.write(e.buf[:5])
+	default:
+		e.buf[0] = rdb64BitLen
+		binary.BigEndian.PutUint64(e.buf[1:9], n)
+		return e.write(e.buf[:9])
+	}
+}
+
+// writeString stores small integers in integer encoding, long compressible strings with LZF
+// and everything else as length prefixed raw bytes
+func (e *RDBEncoder) writeString(s []byte) error {
+	if len(s) <= 11 {
+		if v, err := strconv.ParseInt(string(s), 10, 64); err == nil && strconv.FormatInt(v, 10) == string(s) {
+			if ok, err := e.writeInteger(v); ok || err != nil {
+				return err
+			}
+		}
+	}
+	if len(s) > 20 {
+		if compressed := lzfCompress(s, len(s)-4); compressed != nil {
+			if err := e.writeByte(rdbEncVal<<6 | rdbEncLZF); err != nil {
+				return err
+			}
+			if err := e.writeLength(uint64(len(compressed))); err != nil {
+				return err
+			}
+			if err := e.writeLength(uint64(len(s))); err != nil {
+				return err
+			}
+			return e.write(compressed)
+		}
+	}
+	if err := e.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	return e.write(s)
+}
+
+// writeInteger returns false if v does not fit in 32 bits
+func (e *RDBEncoder) writeInteger(v int64) (bool, error) {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		e.buf[0] = rdbEncVal<<6 | rdbEncInt8
+		e.buf[1] = byte(v)
+		return true, e.write(e.buf[:2])
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		e.buf[0] = rdbEncVal<<6 | rdbEncInt16
+		binary.LittleEndian.PutUint16(e.buf[1:3], uint16(v))
+		return true, e.write(e.buf[:3])
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		e.buf[0] = rdbEncVal<<6 | rdbEncInt32
+		binary.LittleEndian.PutUint32(e.buf[1:5], uint32(v))
+		return true, e.write(e.buf[:5])
+	default:
+		return false, nil
+	}
+}
+
+// WriteEntry writes a key with its value and optional expiration
+func (e *RDBEncoder) WriteEntry(key string, entity *kvcache.DataEntity, expiration *time.Time) error {
+	if expiration != nil {
+		if err := e.writeByte(rdbOpcodeExpireTimeMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(e.buf[:8], uint64(expiration.UnixMilli()))
+		if err := e.write(e.buf[:8]); err != nil {
+			return err
+		}
+	}
+
+	switch data := entity.Data.(type) {
+	case []byte:
+		if err := e.writeHead(rdbTypeString, key); err != nil {
+			return err
+		}
+		return e.writeString(data)
+	case list.List:
+		if err := e.writeHead(rdbTypeList, key); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(data.Len())); err != nil {
+			return err
+		}
+		var err error
+		data.ForEach(func(i int, v any) bool {
+			err = e.writeString(v.([]byte))
+			return err == nil
+		})
+		return err
+	case set.Set:
+		if err := e.writeHead(rdbTypeSet, key); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(data.Len())); err != nil {
+			return err
+		}
+		var err error
+		data.ForEach(func(member string) bool {
+			err = e.writeString([]byte(member))
+			return err == nil
+		})
+		return err
+	case *sortedset.SortedSet:
+		if err := e.writeHead(rdbTypeZSet2, key); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(data.Len())); err != nil {
+			return err
+		}
+		var err error
+		data.ForEachByRank(0, data.Len(), false, func(element *sortedset.Element) bool {
+			if err = e.writeString([]byte(element.Member)); err != nil {
+				return false
+			}
+			binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(element.Score))
+			err = e.write(e.buf[:8])
+			return err == nil
+		})
+		return err
+	case dict.Dict:
+		if err := e.writeHead(rdbTypeHash, key); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(data.Len())); err != nil {
+			return err
+		}
+		var err error
+		data.ForEach(func(field string, val any) bool {
+			if err = e.writeString([]byte(field)); err != nil {
+				return false
+			}
+			err = e.writeString(val.([]byte))
+			return err == nil
+		})
+		return err
+	default:
+		return fmt.Errorf("unsupported data type %T of key %s", entity.Data, key)
+	}
+}
+
+func (e *RDBEncoder) writeHead(valueType byte, key string) error {
+	if err := e.writeByte(valueType); err != nil {
+		return err
+	}
+	return e.writeString([]byte(key))
+}
+
+// usedMemory returns the size of the heap objects, read from runtime/metrics which
+// unlike runtime.ReadMemStats does not stop the world
+func usedMemory() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,234 @@
+#!/usr/bin/env python3
+"""Synthesizes redis-6.2.rdb and redis-7.2.rdb: it lays out the dataset of
+redis-dump.sh byte for byte the way those Redis versions save it with default
+settings, as read from the Redis sources. The dumps checked in were written by
+this script, not saved by a redis-server, so they only prove the decoder agrees
+with this reading of the format.
+
+It shares no code with the Go encoder, so at least the two readings are
+independent. Run redis-dump.sh with a real redis-server to replace them with
+dumps saved by Redis itself.
+"""
+import os
+import struct
+
+EXPIRE_AT_MS = 4102444800000  # 2100-01-01
+EXPIRED_AT_MS = 946684800000  # 2000-01-01
+LONG = b"redis-go " * 12
+# under hash-max-listpack-value, the hash keeps its compact encoding
+MEDIUM = b"redis-go " * 6
+
+
+# crc64 Jones, reflected, no initial value or final xor, see Redis crc64.c
+def crc64(data, crc=0):
+    for b in data:
+        crc ^= b
+        for _ in range(8):
+            crc = (crc >> 1) ^ (0x95AC9329AC4BC9B5 if crc & 1 else 0)
+    return crc
+
+
+assert crc64(b"123456789") == 0xE9C6D914C4B8D9CA
+
+
+def lzf_compress(data):
+    """Greedy LZF compressor, the output format of liblzf lzf_compress"""
+    out, lit, ip, table = bytearray(), bytearray(), 0, {}
+
+    def flush_lit():
+        if lit:
+            out.append(len(lit) - 1)
+            out.extend(lit)
+            lit.clear()
+
+    while ip < len(data):
+        ref = table.get(data[ip:ip + 3]) if ip + 2 < len(data) else None
+        if ip + 2 < len(data):
+            table[data[ip:ip + 3]] = ip
+        if ref is not None and ip - ref - 1 < 1 << 13:
+            length = 3
+            while ip + length < len(data) and length < 264 and data[ref + length] == data[ip + length]:
+                length += 1
+            flush_lit()
+            off = ip - ref - 1
+            if length - 2 < 7:
+                out.append(((length - 2) << 5) | (off >> 8))
+            else:
+                out.append((7 << 5) | (off >> 8))
+                out.append(length - 2 - 7)
+            out.append(off & 0xFF)
+            ip += length
+            continue
+        lit.append(data[ip])
+        ip += 1
+        if len(lit) == 32:
+            flush_lit()
+    flush_lit()
+    return bytes(out)
+
+
+def length(n):
+    if n < 1 << 6:
+        return bytes([n])
+    if n < 1 << 14:
+        return bytes([0x40 | n >> 8, n & 0xFF])
+    return b"\x80" + struct.pack(">I", n)
+
+
+def string(s):
+    """rdbSaveRawString: small integers are int encoded, strings over 20 bytes LZF compressed"""
+    if len(s) <= 11:
+        try:
+            v = int(s)
+        except ValueError:
+            v = None
+        if v is not None and str(v).encode() == s:
+            if -(1 << 7) <= v < 1 << 7:
+                return b"\xc0" + struct.pack("<b", v)
+            if -(1 << 15) <= v < 1 << 15:
+                return b"\xc1" + struct.pack("<h", v)
+            if -(1 << 31) <= v < 1 << 31:
+                return b"\xc2" + struct.pack("<i", v)
+    if len(s) > 20:
+        c = lzf_compress(s)
+        if len(c) < len(s) - 4:
+            return b"\xc3" + length(len(c)) + length(len(s)) + c
+    return length(len(s)) + s
+
+
+def as_int(s):
+    try:
+        v = int(s)
+    except ValueError:
+        return None
+    return v if str(v).encode() == s else None
+
+
+def ziplist(entries):
+    body, prev = bytearray(), 0
+    for e in entries:
+        entry = bytearray(bytes([prev]) if prev < 254 else b"\xfe" + struct.pack("<I", prev))
+        v = as_int(e)
+        if v is None and len(e) < 1 << 6:
+            entry += bytes([len(e)]) + e
+        elif v is None:
+            entry += bytes([0x40 | len(e) >> 8, len(e) & 0xFF]) + e
+        elif 0 <= v <= 12:
+            entry.append(0xF1 + v)
+        elif -(1 << 7) <= v < 1 << 7:
+            entry += b"\xfe" + struct.pack("<b", v)
+        elif -(1 << 15) <= v < 1 << 15:
+            entry += b"\xc0" + struct.pack("<h", v)
+        elif -(1 << 23) <= v < 1 << 23:
+            entry += b"\xf0" + struct.pack("<i", v)[:3]
+        else:
+            entry += b"\xd0" + struct.pack("<i", v)
+        tail = 10 + len(body)
+        body += entry
+        prev = len(entry)
+    return struct.pack("<IIH", 10 + len(body) + 1, tail, len(entries)) + body + b"\xff"
+
+
+def listpack(entries):
+    body = bytearray()
+    for e in entries:
+        v = as_int(e)
+        if v is not None and 0 <= v <= 127:
+            enc = bytes([v])
+        elif v is not None and -4096 <= v <= 4095:
+            u = v & 0x1FFF
+            enc = bytes([0xC0 | u >> 8, u & 0xFF])
+        elif v is not None and -(1 << 15) <= v < 1 << 15:
+            enc = b"\xf1" + struct.pack("<h", v)
+        elif v is not None and -(1 << 23) <= v < 1 << 23:
+            enc = b"\xf2" + struct.pack("<i", v)[:3]
+        elif v is not None and -(1 << 31) <= v < 1 << 31:
+            enc = b"\xf3" + struct.pack("<i", v)
+        elif len(e) < 1 << 6:
+            enc = bytes([0x80 | len(e)]) + e
+        else:
+            enc = bytes([0xE0 | len(e) >> 8, len(e) & 0xFF]) + e
+        assert len(enc) < 128
+        body += enc + bytes([len(enc)])
+    return struct.pack("<IH", 6 + len(body) + 1, len(entries)) + body + b"\xff"
+
+
+def intset(values):
+    values = sorted(values)
+    return struct.pack("<II", 2, len(values)) + b"".join(struct.pack("<h", v) for v in values)
+
+
+def score(f, fmt):
+    """zset scores are stored as strings, %.17g before Redis 7, the shortest form since"""
+    return b"%d" % f if f == int(f) else (fmt % f).encode()
+
+
+def aux(key, val):
+    return b"\xfa" + string(key) + string(val)
+
+
+def dump(version, aux_fields, keys):
+    out = b"REDIS%04d" % version
+    out += b"".join(aux(k, v) for k, v in aux_fields)
+    expires = sum(1 for k in keys if k[0] is not None)
+    out += b"\xfe\x00\xfb" + length(len(keys)) + length(expires)
+    for expire, typ, key, value in keys:
+        if expire is not None:
+            out += b"\xfc" + struct.pack("<Q", expire)
+        out += bytes([typ]) + string(key) + value
+    out += b"\xff"
+    return out + struct.pack("<Q", crc64(out))
+
+
+LIST = [b"a", b"b", b"300", b"-5", LONG]
+HASH = [(b"name", b"redis"), (b"port", b"6379"), (b"medium", MEDIUM)]
+ZSET = [(b"one", 1.0), (b"pi", 3.14), (b"big", 70000.0)]
+INTS = [1, 2, 3, -4, 1000]
+
+
+def common_keys():
+    return [
+        (None, 0, b"str", string(b"hello")),
+        (None, 0, b"int8", string(b"100")),
+        (None, 0, b"int16", string(b"-3000")),
+        (None, 0, b"int32", string(b"-70000")),
+        (None, 0, b"long", string(LONG)),
+        (EXPIRE_AT_MS, 0, b"ttl", string(b"volatile")),
+        (EXPIRED_AT_MS, 0, b"expired", string(b"gone")),
+        (None, 11, b"intset", string(intset(INTS))),
+    ]
+
+
+def redis62():
+    keys = common_keys() + [
+        (None, 14, b"list", length(1) + string(ziplist(LIST))),
+        (None, 13, b"hash", string(ziplist([x for kv in HASH for x in kv]))),
+        (None, 12, b"zset", string(ziplist([x for m, s in ZSET for x in (m, score(s, "%.17g"))]))),
+        (None, 2, b"set", length(3) + b"".join(string(m) for m in (b"x", b"y", b"z"))),
+    ]
+    fields = [(b"redis-ver", b"6.2.14"), (b"redis-bits", b"64"), (b"ctime", b"1700000000"),
+              (b"used-mem", b"875008"), (b"repl-stream-db", b"0"),
+              (b"repl-id", b"8c4b7ae2d2ba0e9aa3c4c9d5bf1eac0d16c2a3f1"), (b"repl-offset", b"0"),
+              (b"aof-preamble", b"0")]
+    return dump(9, fields, keys)
+
+
+def redis72():
+    keys = common_keys() + [
+        (None, 18, b"list", length(1) + length(2) + string(listpack(LIST))),
+        (None, 16, b"hash", string(listpack([x for kv in HASH for x in kv]))),
+        (None, 17, b"zset", string(listpack([x for m, s in ZSET for x in (m, score(s, "%r"))]))),
+        (None, 20, b"set", string(listpack([b"x", b"y", b"z"]))),
+    ]
+    fields = [(b"redis-ver", b"7.2.4"), (b"redis-bits", b"64"), (b"ctime", b"1700000000"),
+              (b"used-mem", b"1010296"), (b"repl-stream-db", b"0"),
+              (b"repl-id", b"8c4b7ae2d2ba0e9aa3c4c9d5bf1eac0d16c2a3f1"), (b"repl-offset", b"0"),
+              (b"aof-base", b"0")]
+    return dump(11, fields, keys)
+
+
+if __name__ == "__main__":
+    here = os.path.dirname(os.path.abspath(__file__))
+    for name, data in (("redis-6.2.rdb", redis62()), ("redis-7.2.rdb", redis72())):
+        with open(os.path.join(here, name), "wb") as f:
+            f.write(data)
@@ -0,0 +1,39 @@
+#!/bin/sh
+# Saves the dataset of the fixtures with the redis-server found in PATH, default
+# settings, and copies the dump to testdata/redis-<major.minor>.rdb:
+#
+#	PATH=/opt/redis-7.2/bin:$PATH ./redis-dump.sh
+#
+# The dumps checked in were synthesized by mkfixtures.py, running this script
+# replaces them with dumps saved by Redis. TestLoadRedisDumps expects the same
+# content whichever way the fixture was made.
+set -e
+cd "$(dirname "$0")"
+dir=$(mktemp -d)
+port=${PORT:-6399}
+redis-server --port "$port" --dir "$dir" --save "" --daemonize yes --pidfile "$dir/redis.pid"
+trap 'redis-cli -p "$port" shutdown nosave >/dev/null 2>&1; rm -rf "$dir"' EXIT
+until redis-cli -p "$port" ping >/dev/null 2>&1; do sleep 0.1; done
+
+long=$(printf 'redis-go %.0s' 1 2 3 4 5 6 7 8 9 10 11 12)
+medium=$(printf 'redis-go %.0s' 1 2 3 4 5 6)
+cli() { redis-cli -p "$port" "$@" >/dev/null; }
+cli set str hello
+cli set int8 100
+cli set int16 -3000
+cli set int32 -70000
+cli set long "$long"
+cli set ttl volatile pxat 4102444800000
+cli debug set-active-expire 0
+cli set expired gone px 1
+sleep 0.1
+cli sadd intset 1 2 3 -4 1000
+cli rpush list a b 300 -5 "$long"
+cli hset hash name redis port 6379 medium "$medium"
+cli zadd zset 1 one 3.14 pi 70000 big
+cli sadd set x y z
+cli save
+
+version=$(redis-cli -p "$port" info server | sed -n 's/^redis_version:\([0-9]*\.[0-9]*\).*/\1/p')
+cp "$dir/dump.rdb" "redis-$version.rdb"
+echo "wrote redis-$version.rdb"
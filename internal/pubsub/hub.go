@@ -0,0 +1,255 @@
+// Package pubsub implements the Pub/Sub hub, which delivers published messages to subscribers
+package pubsub
+
+import (
+	"slices"
+
+	"github.com/mirage208/redis-go/common/utils"
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// Hub delivers published messages to the connections subscribed to a channel or
+// to a matching pattern. Shard channels are a separate namespace of channels, which
+// SPUBLISH delivers to. It is not safe for concurrent use, the command goroutine owns it.
+type Hub struct {
+	channels registry
+	patterns registry
+	shards   registry
+}
+
+// registry maps the names of a namespace to their subscribers
+type registry map[string]map[*connection.Connection]struct{}
+
+func (r registry) add(name string, c *connection.Connection) {
+	subscribers, ok := r[name]
+	if !ok {
+		subscribers = make(map[*connection.Connection]struct{})
+		r[name] = subscribers
+	}
+	subscribers[c] = struct{}{}
+}
+
+func (r registry) remove(name string, c *connection.Connection) {
+	subscribers := r[name]
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(r, name)
+	}
+}
+
+// names returns the sorted names matching pattern, all of them if pattern is empty
+func (r registry) names(pattern string) []string {
+	var names []string
+	for name := range r {
+		if pattern == "" || utils.GlobMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		channels: make(registry),
+		patterns: make(registry),
+		shards:   make(registry),
+	}
+}
+
+// Subscribe subscribes c to channels and returns one confirmation per channel
+func (h *Hub) Subscribe(c *connection.Connection, channels []string) resp.Reply {
+	return subscribe(c, h.channels, connection.SubChannel, channels, "subscribe")
+}
+
+// Unsubscribe unsubscribes c from channels, from all of them if channels is empty
+func (h *Hub) Unsubscribe(c *connection.Connection, channels []string) resp.Reply {
+	return unsubscribe(c, h.channels, connection.SubChannel, channels, "unsubscribe")
+}
+
+// PSubscribe subscribes c to glob-style patterns
+func (h *Hub) PSubscribe(c *connection.Connection, patterns []string) resp.Reply {
+	return subscribe(c, h.patterns, connection.SubPattern, patterns, "psubscribe")
+}
+
+// PUnsubscribe unsubscribes c from patterns, from all of them if patterns is empty
+func (h *Hub) PUnsubscribe(c *connection.Connection, patterns []string) resp.Reply {
+	return unsubscribe(c, h.patterns, connection.SubPattern, patterns, "punsubscribe")
+}
+
+// SSubscribe subscribes c to shard channels
+func (h *Hub) SSubscribe(c *connection.Connection, channels []string) resp.Reply {
+	return subscribe(c, h.shards, connection.SubShard, channels, "ssubscribe")
+}
+
+// SUnsubscribe unsubscribes c from shard channels, from all of them if channels is empty
+func (h *Hub) SUnsubscribe(c *connection.Connection, channels []string) resp.Reply {
+	return unsubscribe(c, h.shards, connection.SubShard, channels, "sunsubscribe")
+}
+
+// Publish sends message to the subscribers of channel and of the patterns matching
+// it, and returns the number of deliveries
+func (h *Hub) Publish(channel string, message []byte) int {
+	receivers := 0
+	if subscribers := h.channels[channel]; len(subscribers) > 0 {
+		msg := newMessage(
+			resp.MakeBulkReply([]byte("message")),
+			resp.MakeBulkReply([]byte(channel)),
+			resp.MakeBulkReply(message),
+		)
+		for c := range subscribers {
+			msg.pushTo(c)
+		}
+		receivers += len(subscribers)
+	}
+	for pattern, subscribers := range h.patterns {
+		if !utils.GlobMatch(pattern, channel) {
+			continue
+		}
+		msg := newMessage(
+			resp.MakeBulkReply([]byte("pmessage")),
+			resp.MakeBulkReply([]byte(pattern)),
+			resp.MakeBulkReply([]byte(channel)),
+			resp.MakeBulkReply(message),
+		)
+		for c := range subscribers {
+			msg.pushTo(c)
+		}
+		receivers += len(subscribers)
+	}
+	return receivers
+}
+
+// SPublish sends message to the subscribers of the shard channel, patterns do not apply
+func (h *Hub) SPublish(channel string, message []byte) int {
+	subscribers := h.shards[channel]
+	if len(subscribers) == 0 {
+		return 0
+	}
+	msg := newMessage(
+		resp.MakeBulkReply([]byte("smessage")),
+		resp.MakeBulkReply([]byte(channel)),
+		resp.MakeBulkReply(message),
+	)
+	for c := range subscribers {
+		msg.pushTo(c)
+	}
+	return len(subscribers)
+}
+
+// message is a message delivered to subscribers, it is converted and measured once per
+// protocol rather than once per subscriber
+type message struct {
+	reply     resp.Reply
+	converted [2]resp.Reply // to RESP2 and RESP3, nil until needed
+	sizes     [2]int64
+}
+
+func newMessage(elements ...resp.Reply) *message {
+	return &message{reply: resp.MakePushReply(elements)}
+}
+
+func (m *message) pushTo(c *connection.Connection) {
+	protocol := c.Protocol()
+	i := 0
+	if protocol == resp.RESP3 {
+		i = 1
+	}
+	if m.converted[i] == nil {
+		m.converted[i] = resp.ForProtocol(m.reply, protocol)
+		m.sizes[i] = resp.EncodedSize(m.converted[i], protocol)
+	}
+	c.PushSized(m.converted[i], m.sizes[i])
+}
+
+// Forget removes every subscription of a closed connection
+func (h *Hub) Forget(c *connection.Connection) {
+	for _, channel := range c.Subscriptions(connection.SubChannel) {
+		c.Unsubscribe(connection.SubChannel, channel)
+		h.channels.remove(channel, c)
+	}
+	for _, pattern := range c.Subscriptions(connection.SubPattern) {
+		c.Unsubscribe(connection.SubPattern, pattern)
+		h.patterns.remove(pattern, c)
+	}
+	for _, channel := range c.Subscriptions(connection.SubShard) {
+		c.Unsubscribe(connection.SubShard, channel)
+		h.shards.remove(channel, c)
+	}
+}
+
+// Channels returns the channels with subscribers matching pattern, all of them if pattern is empty
+func (h *Hub) Channels(pattern string) []string {
+	return h.channels.names(pattern)
+}
+
+// ShardChannels returns the shard channels with subscribers matching pattern, all of them if pattern is empty
+func (h *Hub) ShardChannels(pattern string) []string {
+	return h.shards.names(pattern)
+}
+
+// NumSub returns the number of subscribers of channel, pattern subscriptions excluded
+func (h *Hub) NumSub(channel string) int {
+	return len(h.channels[channel])
+}
+
+// ShardNumSub returns the number of subscribers of the shard channel
+func (h *Hub) ShardNumSub(channel string) int {
+	return len(h.shards[channel])
+}
+
+// NumPat returns the number of patterns subscribed to
+func (h *Hub) NumPat() int {
+	return len(h.patterns)
+}
+
+// subscriptionCount is the count sent with confirmations, shard subscriptions are counted apart
+func subscriptionCount(c *connection.Connection, kind connection.SubKind) int {
+	if kind == connection.SubShard {
+		return c.SubscriptionCount(connection.SubShard)
+	}
+	return c.SubscriptionCount(connection.SubChannel, connection.SubPattern)
+}
+
+func subscribe(c *connection.Connection, r registry, kind connection.SubKind, names []string, action string) resp.Reply {
+	confirmations := make([]resp.Reply, len(names))
+	for i, name := range names {
+		if c.Subscribe(kind, name) {
+			r.add(name, c)
+		}
+		confirmations[i] = confirmation(action, []byte(name), subscriptionCount(c, kind))
+	}
+	return resp.MakeSequenceReply(confirmations)
+}
+
+func unsubscribe(c *connection.Connection, r registry, kind connection.SubKind, names []string, action string) resp.Reply {
+	if len(names) == 0 {
+		names = c.Subscriptions(kind)
+		slices.Sort(names)
+		if len(names) == 0 {
+			return confirmation(action, nil, subscriptionCount(c, kind))
+		}
+	}
+	confirmations := make([]resp.Reply, len(names))
+	for i, name := range names {
+		if c.Unsubscribe(kind, name) {
+			r.remove(name, c)
+		}
+		confirmations[i] = confirmation(action, []byte(name), subscriptionCount(c, kind))
+	}
+	return resp.MakeSequenceReply(confirmations)
+}
+
+// confirmation is the reply to a subscription change, a nil name is sent as null
+func confirmation(action string, name []byte, count int) resp.Reply {
+	var nameReply resp.Reply = resp.MakeBulkReply(name)
+	if name == nil {
+		nameReply = resp.MakeNullBulkReply()
+	}
+	return resp.MakePushReply([]resp.Reply{
+		resp.MakeBulkReply([]byte(action)),
+		nameReply,
+		resp.MakeIntegerReply(int64(count)),
+	})
+}
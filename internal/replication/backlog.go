@@ -0,0 +1,75 @@
+// Package replication holds the building blocks of master-replica replication: the
+// backlog a master keeps for partial resynchronization, and the client side of the
+// protocol a replica speaks to its master.
+package replication
+
+// DefaultBacklogSize is the size of the backlog unless repl-backlog-size is set
+const DefaultBacklogSize = 1 << 20
+
+// Backlog is a circular buffer holding the latest bytes of the replication stream, a
+// replica which lost its link resumes from it as long as it did not miss more than
+// the backlog holds. Offsets count the bytes of the stream, the first byte being 1.
+type Backlog struct {
+	buf    []byte
+	next   int   // index the next byte is written at
+	length int   // bytes held, at most len(buf)
+	offset int64 // offset of the last byte written
+}
+
+// NewBacklog creates a backlog of size bytes for a stream whose last byte has the given offset
+func NewBacklog(size int, offset int64) *Backlog {
+	if size <= 0 {
+		size = DefaultBacklogSize
+	}
+	return &Backlog{buf: make([]byte, size), offset: offset}
+}
+
+// Write appends p to the stream, overwriting the oldest bytes once the backlog is full
+func (b *Backlog) Write(p []byte) {
+	b.offset += int64(len(p))
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+	for len(p) > 0 {
+		n := copy(b.buf[b.next:], p)
+		p = p[n:]
+		b.next = (b.next + n) % len(b.buf)
+		b.length = min(b.length+n, len(b.buf))
+	}
+}
+
+// Offset returns the offset of the last byte of the stream, master_repl_offset
+func (b *Backlog) Offset() int64 {
+	return b.offset
+}
+
+// FirstOffset returns the offset of the oldest byte held
+func (b *Backlog) FirstOffset() int64 {
+	return b.offset - int64(b.length) + 1
+}
+
+// Len returns the number of bytes held
+func (b *Backlog) Len() int {
+	return b.length
+}
+
+// Size returns the capacity of the backlog
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+// Since returns the bytes of the stream from offset on, ok is false once they are
+// no longer held. A replica continues from the offset after the last byte it got.
+func (b *Backlog) Since(offset int64) (data []byte, ok bool) {
+	if offset < b.FirstOffset() || offset > b.offset+1 {
+		return nil, false
+	}
+	n := int(b.offset - offset + 1)
+	data = make([]byte, 0, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		return append(data, b.buf[start:start+n]...), true
+	}
+	data = append(data, b.buf[start:]...)
+	return append(data, b.buf[:n-(len(b.buf)-start)]...), true
+}
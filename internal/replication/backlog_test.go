@@ -0,0 +1,40 @@
+package replication
+
+import "testing"
+
+func TestBacklog(t *testing.T) {
+	b := NewBacklog(8, 100)
+	if data, ok := b.Since(101); !ok || len(data) != 0 {
+		t.Fatalf("expected an empty continuation at the current offset, got %q %v", data, ok)
+	}
+	b.Write([]byte("abcde"))
+	if b.Offset() != 105 || b.FirstOffset() != 101 || b.Len() != 5 {
+		t.Fatalf("unexpected offsets %d %d %d", b.Offset(), b.FirstOffset(), b.Len())
+	}
+	if data, ok := b.Since(103); !ok || string(data) != "cde" {
+		t.Errorf("expected cde, got %q %v", data, ok)
+	}
+
+	// wraps around, the oldest bytes are dropped
+	b.Write([]byte("fghij"))
+	if b.Offset() != 110 || b.FirstOffset() != 103 || b.Len() != 8 {
+		t.Fatalf("unexpected offsets %d %d %d", b.Offset(), b.FirstOffset(), b.Len())
+	}
+	if data, ok := b.Since(103); !ok || string(data) != "cdefghij" {
+		t.Errorf("expected cdefghij, got %q %v", data, ok)
+	}
+	if data, ok := b.Since(109); !ok || string(data) != "ij" {
+		t.Errorf("expected ij, got %q %v", data, ok)
+	}
+	if _, ok := b.Since(102); ok {
+		t.Error("expected bytes no longer held to be refused")
+	}
+	if _, ok := b.Since(112); ok {
+		t.Error("expected bytes not written yet to be refused")
+	}
+
+	b.Write([]byte("0123456789"))
+	if data, ok := b.Since(b.FirstOffset()); !ok || string(data) != "23456789" {
+		t.Errorf("expected the tail of a write larger than the backlog, got %q %v", data, ok)
+	}
+}
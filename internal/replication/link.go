@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// Link is the connection of a replica to its master. Every read and write fails once
+// the master stayed silent for longer than the timeout, repl-timeout.
+type Link struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	limits  resp.Limits // of the command stream, the limits of client requests
+}
+
+// Sync is how the master answered PSYNC
+type Sync struct {
+	Full   bool   // FULLRESYNC, a snapshot follows, otherwise the stream continues from the requested offset
+	ReplID string // replication ID of the master
+	Offset int64  // offset of the snapshot for a full resync
+}
+
+// Dial connects to the master at addr, limits apply to the command stream
+func Dial(addr string, timeout time.Duration, limits resp.Limits) (*Link, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	l := &Link{conn: conn, timeout: timeout, limits: limits}
+	l.reader = bufio.NewReader(readerFunc(func(p []byte) (int, error) {
+		_ = conn.SetReadDeadline(time.Now().Add(l.timeout))
+		return conn.Read(p)
+	}))
+	return l, nil
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// Handshake introduces the replica: it authenticates when password is set, checks the
+// master replies and announces the port the replica listens on
+func (l *Link) Handshake(user, password string, port int) error {
+	if password != "" {
+		args := []string{"AUTH", password}
+		if user != "" {
+			args = []string{"AUTH", user, password}
+		}
+		if _, err := l.Command(args...); err != nil {
+			return fmt.Errorf("unable to AUTH to MASTER: %w", err)
+		}
+	}
+	if _, err := l.Command("PING"); err != nil {
+		return fmt.Errorf("error reply to PING from master: %w", err)
+	}
+	if _, err := l.Command("REPLCONF", "listening-port", strconv.Itoa(port)); err != nil {
+		return fmt.Errorf("master rejected REPLCONF listening-port: %w", err)
+	}
+	// like Redis, a master which does not know the capability is not an error
+	_, _ = l.Command("REPLCONF", "capa", "psync2")
+	return nil
+}
+
+// Psync asks the master to continue the stream of replID from offset, or to send a
+// snapshot when replID is empty or the master cannot continue
+func (l *Link) Psync(replID string, offset int64) (*Sync, error) {
+	if replID == "" {
+		replID, offset = "?", -1
+	}
+	line, err := l.Command("PSYNC", replID, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid FULLRESYNC reply: " + line)
+		}
+		return &Sync{Full: true, ReplID: fields[1], Offset: offset}, nil
+	case len(fields) == 2 && fields[0] == "CONTINUE":
+		return &Sync{ReplID: fields[1]}, nil
+	case len(fields) == 1 && fields[0] == "CONTINUE":
+		// the replication ID did not change
+		return &Sync{ReplID: replID}, nil
+	}
+	return nil, errors.New("unexpected reply to PSYNC: " + line)
+}
+
+// Command sends a command and returns its status reply, an error reply is returned as an error
+func (l *Link) Command(args ...string) (string, error) {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	if err := l.write(cmdLine); err != nil {
+		return "", err
+	}
+	line, err := l.readLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "-") {
+		return "", errors.New(line[1:])
+	}
+	return strings.TrimPrefix(line, "+"), nil
+}
+
+// Snapshot returns the snapshot sent after a full resync, which must be read entirely
+// before the command stream
+func (l *Link) Snapshot() (io.Reader, error) {
+	line, err := l.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return nil, errors.New("bad protocol from MASTER, the first byte is not '$': " + line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return nil, errors.New("invalid snapshot size from MASTER: " + line)
+	}
+	return io.LimitReader(l.reader, size), nil
+}
+
+// Commands parses the command stream, it ends once the link fails
+func (l *Link) Commands() <-chan *resp.Payload {
+	return resp.ParseStreamWithLimits(l.reader, l.limits)
+}
+
+// Ack reports the offset the replica processed, it must not run concurrently with Command
+func (l *Link) Ack(offset int64) error {
+	return l.write([][]byte{[]byte("REPLCONF"), []byte("ACK"), []byte(strconv.FormatInt(offset, 10))})
+}
+
+// Close closes the connection, reads in progress fail
+func (l *Link) Close() error {
+	return l.conn.Close()
+}
+
+func (l *Link) write(cmdLine [][]byte) error {
+	_ = l.conn.SetWriteDeadline(time.Now().Add(l.timeout))
+	_, err := resp.MakeMultiBulkReply(cmdLine).WriteTo(l.conn)
+	return err
+}
+
+// readLine returns the next line without CRLF, the newlines a master sends to keep the
+// link alive while it prepares a snapshot are skipped
+func (l *Link) readLine() (string, error) {
+	for {
+		line, err := l.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package replication
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+func TestSnapshotKeepalive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	const timeout = 100 * time.Millisecond
+
+	// a master preparing the snapshot for longer than the timeout, sending newlines meanwhile
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		payload := <-resp.ParseStream(bufio.NewReader(conn))
+		if payload.Err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "+FULLRESYNC 0123 0\r\n")
+		for i := 0; i < 5; i++ {
+			time.Sleep(timeout / 2)
+			_, _ = io.WriteString(conn, "\n")
+		}
+		_, _ = io.WriteString(conn, "$3\r\nabc")
+		time.Sleep(timeout)
+	}()
+
+	link, err := Dial(listener.Addr().String(), timeout, resp.DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer link.Close()
+	sync, err := link.Psync("", 0)
+	if err != nil || !sync.Full {
+		t.Fatalf("unexpected full resync %v %v", sync, err)
+	}
+	snapshot, err := link.Snapshot()
+	if err != nil {
+		t.Fatalf("expected the newlines to keep the link alive: %v", err)
+	}
+	if data, err := io.ReadAll(snapshot); err != nil || string(data) != "abc" {
+		t.Errorf("unexpected snapshot %q %v", data, err)
+	}
+}
@@ -3,8 +3,9 @@ package resp
 import (
 	"bufio"
 	"bytes"
-	"errors"
 	"io"
+	"math"
+	"math/big"
 	"runtime/debug"
 	"strconv"
 
@@ -16,147 +17,351 @@ type Payload struct {
 	Err  error
 }
 
+// ProtocolError reports malformed input, the stream cannot be parsed any further
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol error: " + e.Msg
+}
+
+// Limits bound the memory a peer can make the parser allocate
+type Limits struct {
+	MaxBulkLen      int64 // longest bulk string, proto-max-bulk-len
+	MaxMultiBulkLen int64 // most elements of an array or other aggregate
+	MaxInlineLen    int   // longest line, including inline commands and type headers
+}
+
+// DefaultLimits are the limits of Redis
+var DefaultLimits = Limits{
+	MaxBulkLen:      512 << 20,
+	MaxMultiBulkLen: 1024 * 1024,
+	MaxInlineLen:    64 << 10,
+}
+
+// ReplyLimits are for the replies of a server, which may be as large as its dataset: they
+// only keep lengths within what the parser can represent
+var ReplyLimits = Limits{
+	MaxBulkLen:      math.MaxInt64 - 2, // the body is followed by CRLF
+	MaxMultiBulkLen: math.MaxInt64 / 2, // a map counts two elements per entry
+	MaxInlineLen:    math.MaxInt,
+}
+
+const (
+	// bodies above streamThreshold grow with the data actually received instead of
+	// being allocated from the announced length
+	streamThreshold = 64 << 10
+	// maxDepth bounds the nesting of aggregates
+	maxDepth = 128
+)
+
 // ParseStream reads data from io.Reader and send payloads through channel
 func ParseStream(reader io.Reader) <-chan *Payload {
+	return ParseStreamWithLimits(reader, DefaultLimits)
+}
+
+// ParseStreamWithLimits is ParseStream with custom limits. A payload exceeding a limit,
+// like any malformed payload, is reported as a ProtocolError and ends the stream.
+func ParseStreamWithLimits(reader io.Reader, limits Limits) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parse(reader, ch)
+	p := &parser{
+		reader: bufio.NewReader(reader),
+		limits: limits,
+	}
+	go p.parse(ch, p.nextReply)
 	return ch
 }
 
-func parse(rawReader io.Reader, ch chan<- *Payload) {
+type parser struct {
+	reader *bufio.Reader
+	limits Limits
+	depth  int
+}
+
+// parse sends the values returned by next until the stream ends or fails
+func (p *parser) parse(ch chan<- *Payload, next func() (Reply, error)) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(err, string(debug.Stack()))
 		}
 	}()
 
-	reader := bufio.NewReader(rawReader)
 	for {
-		line, err := reader.ReadBytes('\n')
+		reply, err := next()
 		if err != nil {
 			ch <- &Payload{Err: err}
 			close(ch)
 			return
 		}
-		length := len(line)
-		if length <= 2 || line[length-2] != '\r' {
-			// there are some empty lines within replication traffic, ignore this error
-			//protocolError(ch, "empty line")
-			continue
+		if reply != nil {
+			ch <- &Payload{Data: reply}
 		}
+	}
+}
 
-		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
-		switch line[0] {
-		case '+': // Simple strings
-			content := string(line[1:])
-			ch <- &Payload{
-				Data: MakeStatusReply(content),
-			}
-		case '-': // Simple error
-			ch <- &Payload{
-				Data: MakeErrorReply(string(line[1:])),
-			}
-		case ':': // Integers
-			value, parseErr := strconv.ParseInt(string(line[1:]), 10, 64)
-			if parseErr != nil {
-				err := errors.New("protocol error: " + "illegal number " + string(line[1:]))
-				ch <- &Payload{Err: err}
-				continue
-			}
-			ch <- &Payload{
-				Data: MakeIntegerReply(value),
-			}
-		case '$': // Bulk strings
-			err = parseBulkString(line, reader, ch)
-			if err != nil {
-				ch <- &Payload{Err: err}
-				close(ch)
-				return
-			}
-		case '*': // Arrays
-			err = parseArray(line, reader, ch)
-			if err != nil {
-				ch <- &Payload{Err: err}
-				close(ch)
-				return
-			}
-		default: // Multi bulk strings, split by ' '
-			args := bytes.Split(line, []byte{' '})
-			ch <- &Payload{
-				Data: MakeMultiBulkReply(args),
-			}
-		}
-	}
-}
-
-func parseBulkString(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
-	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || strLen < -1 {
-		protocolError(ch, "illegal bulk string header: "+string(header))
-		return nil
-	} else if strLen == -1 {
-		ch <- &Payload{
-			Data: MakeNullBulkReply(),
-		}
-		return nil
+// nextReply parses the next value of any type, nil for an ignored line
+func (p *parser) nextReply() (Reply, error) {
+	line, err := p.readLine()
+	if err != nil || line == nil {
+		return nil, err
 	}
-	body := make([]byte, strLen+2)
-	_, err = io.ReadFull(reader, body)
+	if !isTypePrefix(line[0]) {
+		// Multi bulk strings, split by ' '
+		return MakeMultiBulkReply(bytes.Split(line, []byte{' '})), nil
+	}
+	reply, err := p.parseValue(line)
+	if err == io.EOF {
+		// the stream ended in the middle of a value
+		err = io.ErrUnexpectedEOF
+	}
+	return reply, err
+}
+
+// readLine returns a line without CRLF, or nil for a line which is not CRLF terminated or empty
+func (p *parser) readLine() ([]byte, error) {
+	line, err := p.readRawLine()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ch <- &Payload{
-		Data: MakeBulkReply(body[:len(body)-2]),
+	length := len(line)
+	if length <= 2 || line[length-2] != '\r' {
+		return nil, nil
 	}
-	return nil
+	return line[:length-2], nil
 }
 
-func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
-	strNums, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || strNums < 0 {
-		protocolError(ch, "illegal array header "+string(header[1:]))
-		return nil
-	} else if strNums == 0 {
-		ch <- &Payload{
-			Data: MakeEmptyMultiBulkReply(),
+// readRawLine returns a line including its line feed. Lines longer than MaxInlineLen
+// are rejected before they are buffered entirely.
+func (p *parser) readRawLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := p.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > p.limits.MaxInlineLen {
+			return nil, &ProtocolError{Msg: "too big inline request"}
 		}
-		return nil
+		if err == bufio.ErrBufferFull {
+			line = append(line, chunk...)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			// ReadSlice returns a view of the reader's buffer, which the next read overwrites
+			return bytes.Clone(chunk), nil
+		}
+		return append(line, chunk...), nil
+	}
+}
+
+func isTypePrefix(b byte) bool {
+	switch b {
+	case '+', '-', ':', '$', '*', '_', '#', ',', '(', '=', '!', '%', '~', '>', '|':
+		return true
 	}
+	return false
+}
 
-	lines := make([][]byte, 0, strNums)
-	for i := int64(0); i < strNums; i++ {
-		var line []byte
-		line, err = reader.ReadBytes('\n')
+// parseValue parses the value introduced by header, reading the body and nested values from the stream
+func (p *parser) parseValue(header []byte) (Reply, error) {
+	body := header[1:]
+	switch header[0] {
+	case '+': // Simple strings
+		return MakeStatusReply(string(body)), nil
+	case '-': // Simple error
+		return MakeErrorReply(string(body)), nil
+	case ':': // Integers
+		value, err := strconv.ParseInt(string(body), 10, 64)
 		if err != nil {
-			return err
+			return nil, &ProtocolError{Msg: "illegal number " + string(body)}
 		}
-		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			protocolError(ch, "illegal bulk string header "+string(line))
-			break
+		return MakeIntegerReply(value), nil
+	case '$': // Bulk strings
+		data, err := p.parseBlob(header)
+		if err != nil || data == nil {
+			return MakeNullBulkReply(), err
 		}
-		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			protocolError(ch, "illegal bulk string length "+string(line))
-			break
-		} else if strLen == -1 {
-			lines = append(lines, []byte{})
-		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
-			if err != nil {
-				return err
-			}
-			lines = append(lines, body[:len(body)-2])
+		return MakeBulkReply(data), nil
+	case '!': // Blob error
+		data, err := p.parseBlob(header)
+		if err != nil {
+			return nil, err
+		}
+		return MakeErrorReply(string(data)), nil
+	case '=': // Verbatim strings
+		data, err := p.parseBlob(header)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < 4 || data[3] != ':' {
+			return nil, &ProtocolError{Msg: "illegal verbatim string " + string(data)}
+		}
+		return MakeVerbatimReply(string(data[:3]), data[4:]), nil
+	case '_': // Null
+		if len(body) != 0 {
+			return nil, &ProtocolError{Msg: "illegal null " + string(header)}
+		}
+		return MakeNullReply(), nil
+	case '#': // Booleans
+		switch string(body) {
+		case "t":
+			return MakeBooleanReply(true), nil
+		case "f":
+			return MakeBooleanReply(false), nil
+		}
+		return nil, &ProtocolError{Msg: "illegal boolean " + string(body)}
+	case ',': // Doubles
+		value, err := parseDouble(string(body))
+		if err != nil {
+			return nil, &ProtocolError{Msg: "illegal double " + string(body)}
 		}
+		return MakeDoubleReply(value), nil
+	case '(': // Big numbers
+		value, ok := new(big.Int).SetString(string(body), 10)
+		if !ok {
+			return nil, &ProtocolError{Msg: "illegal big number " + string(body)}
+		}
+		return MakeBigNumberReply(value), nil
+	case '*': // Arrays
+		return p.parseArray(header)
+	case '%': // Maps
+		pairs, err := p.parseAggregate(header, 2)
+		if err != nil {
+			return nil, err
+		}
+		return MakeMapReply(pairs), nil
+	case '~': // Sets
+		members, err := p.parseAggregate(header, 1)
+		if err != nil {
+			return nil, err
+		}
+		return MakeSetReply(members), nil
+	case '>': // Pushes
+		replies, err := p.parseAggregate(header, 1)
+		if err != nil {
+			return nil, err
+		}
+		return MakePushReply(replies), nil
+	case '|': // Attributes, followed by the value they describe
+		pairs, err := p.parseAggregate(header, 2)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := p.parseNext()
+		if err != nil {
+			return nil, err
+		}
+		return MakeAttributeReply(pairs, reply), nil
+	}
+	return nil, &ProtocolError{Msg: "unknown type " + string(header[:1])}
+}
+
+// parseBlob reads a length prefixed string, it returns nil for the null length -1
+func (p *parser) parseBlob(header []byte) ([]byte, error) {
+	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || strLen < -1 || strLen > p.limits.MaxBulkLen {
+		return nil, &ProtocolError{Msg: "invalid bulk length"}
+	} else if strLen == -1 {
+		return nil, nil
+	}
+	body, err := p.readFull(strLen + 2)
+	if err != nil {
+		return nil, err
+	}
+	return body[:len(body)-2], nil
+}
+
+// readFull reads exactly n bytes, large bodies are buffered as they arrive so
+// that announcing a length alone cannot exhaust memory
+func (p *parser) readFull(n int64) ([]byte, error) {
+	if n <= streamThreshold {
+		body := make([]byte, n)
+		_, err := io.ReadFull(p.reader, body)
+		return body, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(streamThreshold)
+	if _, err := io.CopyN(&buf, p.reader, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseDouble(s string) (float64, error) {
+	switch s {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseArray returns a MultiBulkReply when every element is a bulk string, as commands
+// are sent, otherwise an ArrayReply
+func (p *parser) parseArray(header []byte) (Reply, error) {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err == nil && n == -1 {
+		return MakeNullBulkReply(), nil
+	} else if err == nil && n == 0 {
+		return MakeEmptyMultiBulkReply(), nil
+	}
+	replies, err := p.parseAggregate(header, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([][]byte, 0, len(replies))
+	for _, reply := range replies {
+		switch reply := reply.(type) {
+		case *BulkReply:
+			args = append(args, reply.Arg)
+		case *NullBulkReply:
+			args = append(args, []byte{})
+		default:
+			return MakeArrayReply(replies), nil
+		}
+	}
+	return MakeMultiBulkReply(args), nil
+}
+
+// parseAggregate reads the elements of an aggregate type, a map of n entries has 2n elements
+func (p *parser) parseAggregate(header []byte, elementsPerEntry int64) ([]Reply, error) {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || n < 0 || n > p.limits.MaxMultiBulkLen {
+		return nil, &ProtocolError{Msg: "invalid multibulk length"}
 	}
-	ch <- &Payload{
-		Data: MakeMultiBulkReply(lines),
+	if p.depth >= maxDepth {
+		return nil, &ProtocolError{Msg: "too deeply nested aggregate"}
 	}
-	return nil
+	p.depth++
+	defer func() { p.depth-- }()
+
+	n *= elementsPerEntry
+	replies := make([]Reply, 0, min(n, 1024))
+	for i := int64(0); i < n; i++ {
+		reply, err := p.parseNext()
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
 }
 
-func protocolError(ch chan<- *Payload, msg string) {
-	err := errors.New("protocol error: " + msg)
-	ch <- &Payload{Err: err}
+func (p *parser) parseNext() (Reply, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == nil || !isTypePrefix(line[0]) {
+		return nil, &ProtocolError{Msg: "illegal element " + string(line)}
+	}
+	return p.parseValue(line)
 }
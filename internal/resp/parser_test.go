@@ -3,6 +3,8 @@ package resp
 import (
 	"bytes"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -82,3 +84,67 @@ func BenchmarkParseStream(b *testing.B) {
 		}
 	}
 }
+
+func TestParseStreamLimits(t *testing.T) {
+	limits := Limits{MaxBulkLen: 16, MaxMultiBulkLen: 4, MaxInlineLen: 32}
+	tests := []struct {
+		name  string
+		input string
+		msg   string
+	}{
+		{"Bulk too long", "*1\r\n$17\r\n", "invalid bulk length"},
+		{"Huge bulk", "$999999999999\r\n", "invalid bulk length"},
+		{"Too many elements", "*5\r\n", "invalid multibulk length"},
+		{"Inline too long", strings.Repeat("a", 40) + "\r\n", "too big inline request"},
+		{"Header without newline", "*" + strings.Repeat("1", 40), "too big inline request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := ParseStreamWithLimits(strings.NewReader(tt.input), limits)
+			payload := <-payloads
+			protoErr, ok := payload.Err.(*ProtocolError)
+			if !ok || protoErr.Msg != tt.msg {
+				t.Fatalf("expected protocol error %q, got %v", tt.msg, payload.Err)
+			}
+			if _, open := <-payloads; open {
+				t.Error("expected the stream to end after a protocol error")
+			}
+		})
+	}
+}
+
+func TestParseReplyLimits(t *testing.T) {
+	// replies may be larger than requests, such as LRANGE of a long list
+	n := int(DefaultLimits.MaxMultiBulkLen) + 1
+	input := "*" + strconv.Itoa(n) + "\r\n" + strings.Repeat(":1\r\n", n)
+	payload := <-ParseStreamWithLimits(strings.NewReader(input), ReplyLimits)
+	if payload.Err != nil {
+		t.Fatal(payload.Err)
+	}
+	if r, ok := payload.Data.(*ArrayReply); !ok || len(r.Replies) != n {
+		t.Errorf("expected an array of %d elements, got %T", n, payload.Data)
+	}
+
+	// lengths beyond what the parser can represent are still rejected
+	payload = <-ParseStreamWithLimits(strings.NewReader("$9223372036854775807\r\n"), ReplyLimits)
+	if protoErr, ok := payload.Err.(*ProtocolError); !ok || protoErr.Msg != "invalid bulk length" {
+		t.Errorf("expected an invalid bulk length, got %v", payload.Err)
+	}
+}
+
+func TestParseLargeBulk(t *testing.T) {
+	body := strings.Repeat("x", 1<<20)
+	payload := <-ParseStream(strings.NewReader("$" + strconv.Itoa(len(body)) + "\r\n" + body + "\r\n"))
+	if payload.Err != nil {
+		t.Fatal(payload.Err)
+	}
+	if string(payload.Data.(*BulkReply).Arg) != body {
+		t.Error("large bulk string corrupted")
+	}
+
+	// a length announced but never sent is not allocated up front
+	payload = <-ParseStream(strings.NewReader("$400000000\r\nshort"))
+	if payload.Err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, got %v", payload.Err)
+	}
+}
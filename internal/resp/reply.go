@@ -1,15 +1,17 @@
 package resp
 
 import (
-	"bytes"
+	"io"
 	"strconv"
 )
 
-// Reply is the interface that wraps the ToBytes method.
+// Reply is the interface that wraps the ToBytes and WriteTo methods.
 //
 // ToBytes returns the reply in RESP bytes.
+// WriteTo writes the same bytes to w piece by piece, without building them in memory first.
 type Reply interface {
 	ToBytes() []byte
+	io.WriterTo
 }
 
 const CRLF = "\r\n"
@@ -19,6 +21,7 @@ var (
 	pongBytes           = []byte("+PONG" + CRLF)
 	okBytes             = []byte("+OK" + CRLF)
 	nullBulkBytes       = []byte("$-1" + CRLF)
+	nullArrayBytes      = []byte("*-1" + CRLF)
 	emptyMultiBulkBytes = []byte("*0" + CRLF)
 	noBytes             = []byte("" + CRLF)
 )
@@ -27,6 +30,7 @@ type (
 	PongReply           struct{}
 	OkReply             struct{}
 	NullBulkReply       struct{}
+	NullArrayReply      struct{}
 	EmptyMultiBulkReply struct{}
 	NoReply             struct{}
 )
@@ -35,6 +39,7 @@ var (
 	pongReply           = new(PongReply)
 	okReply             = new(OkReply)
 	nullBulkReply       = new(NullBulkReply)
+	nullArrayReply      = new(NullArrayReply)
 	emptyMultiBulkReply = new(EmptyMultiBulkReply)
 	noReply             = new(NoReply)
 )
@@ -43,22 +48,56 @@ func (r *PongReply) ToBytes() []byte {
 	return pongBytes
 }
 
+func (r *PongReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(pongBytes)
+	return int64(n), err
+}
+
 func (r *OkReply) ToBytes() []byte {
 	return okBytes
 }
 
+func (r *OkReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(okBytes)
+	return int64(n), err
+}
+
 func (r *NullBulkReply) ToBytes() []byte {
 	return nullBulkBytes
 }
 
+func (r *NullBulkReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(nullBulkBytes)
+	return int64(n), err
+}
+
+func (r *NullArrayReply) ToBytes() []byte {
+	return nullArrayBytes
+}
+
+func (r *NullArrayReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(nullArrayBytes)
+	return int64(n), err
+}
+
 func (r *EmptyMultiBulkReply) ToBytes() []byte {
 	return emptyMultiBulkBytes
 }
 
+func (r *EmptyMultiBulkReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(emptyMultiBulkBytes)
+	return int64(n), err
+}
+
 func (r *NoReply) ToBytes() []byte {
 	return noBytes
 }
 
+func (r *NoReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(noBytes)
+	return int64(n), err
+}
+
 func MakePongReply() *PongReply {
 	return pongReply
 }
@@ -71,6 +110,10 @@ func MakeNullBulkReply() *NullBulkReply {
 	return nullBulkReply
 }
 
+func MakeNullArrayReply() *NullArrayReply {
+	return nullArrayReply
+}
+
 func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return emptyMultiBulkReply
 }
@@ -79,13 +122,39 @@ func MakeNoReply() *NoReply {
 	return noReply
 }
 
+/* ---- Raw Reply ---- */
+
+// RawReply is data already encoded in RESP, such as the replication stream, sent as is
+type RawReply struct {
+	Data []byte
+}
+
+func (r *RawReply) ToBytes() []byte {
+	return r.Data
+}
+
+func (r *RawReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(r.Data)
+	return int64(n), err
+}
+
+func MakeRawReply(data []byte) *RawReply {
+	return &RawReply{Data: data}
+}
+
 /* ---- Status Reply ---- */
 type StatusReply struct {
 	Status string
 }
 
 func (r *StatusReply) ToBytes() []byte {
-	return []byte("+" + r.Status + CRLF)
+	return bytesOf(r, len(r.Status)+3)
+}
+
+func (r *StatusReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.line('+', r.Status)
+	return rw.result()
 }
 
 func MakeStatusReply(status string) *StatusReply {
@@ -98,7 +167,18 @@ type BulkReply struct {
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
+	buf := make([]byte, 0, len(r.Arg)+16)
+	buf = append(buf, '$')
+	buf = strconv.AppendInt(buf, int64(len(r.Arg)), 10)
+	buf = append(buf, CRLF...)
+	buf = append(buf, r.Arg...)
+	return append(buf, CRLF...)
+}
+
+func (r *BulkReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.blob('$', r.Arg)
+	return rw.result()
 }
 
 func MakeBulkReply(arg []byte) *BulkReply {
@@ -111,11 +191,8 @@ type MultiBulkReply struct {
 }
 
 func (r *MultiBulkReply) ToBytes() []byte {
-	var buf bytes.Buffer
 	//Calculate the length of buffer
-	argLen := len(r.Args)
-	argLenStr := strconv.Itoa(argLen)
-	bufLen := 1 + len(argLenStr) + 2
+	bufLen := 1 + len(strconv.Itoa(len(r.Args))) + 2
 	for _, arg := range r.Args {
 		if arg == nil {
 			bufLen += 3 + 2
@@ -123,26 +200,20 @@ func (r *MultiBulkReply) ToBytes() []byte {
 			bufLen += 1 + len(strconv.Itoa(len(arg))) + 2 + len(arg) + 2
 		}
 	}
-	//Allocate memory
-	buf.Grow(bufLen)
-	//Write string step by step,avoid concat strings
-	_, _ = buf.WriteString("*")
-	_, _ = buf.WriteString(argLenStr)
-	_, _ = buf.WriteString(CRLF)
+	return bytesOf(r, bufLen)
+}
+
+func (r *MultiBulkReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.header('*', int64(len(r.Args)))
 	for _, arg := range r.Args {
 		if arg == nil {
-			_, _ = buf.WriteString("$-1")
-			_, _ = buf.WriteString(CRLF)
+			rw.write(nullBulkBytes)
 		} else {
-			_, _ = buf.WriteString("$")
-			_, _ = buf.WriteString(strconv.Itoa(len(arg)))
-			_, _ = buf.WriteString(CRLF)
-			//Write bytes,avoid slice of byte to string(slicebytetostring)
-			_, _ = buf.Write(arg)
-			_, _ = buf.WriteString(CRLF)
+			rw.blob('$', arg)
 		}
 	}
-	return buf.Bytes()
+	return rw.result()
 }
 
 func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
@@ -155,7 +226,13 @@ type IntegerReply struct {
 }
 
 func (r *IntegerReply) ToBytes() []byte {
-	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
+	return bytesOf(r, 24)
+}
+
+func (r *IntegerReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.header(':', r.Code)
+	return rw.result()
 }
 
 func MakeIntegerReply(code int64) *IntegerReply {
@@ -168,7 +245,18 @@ type ErrorReply struct {
 }
 
 func (r *ErrorReply) ToBytes() []byte {
-	return []byte("-" + r.Msg + CRLF)
+	return bytesOf(r, len(r.Msg)+3)
+}
+
+func (r *ErrorReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.line('-', r.Msg)
+	return rw.result()
+}
+
+// Error makes an error reply usable as an error, as clients receive it
+func (r *ErrorReply) Error() string {
+	return r.Msg
 }
 
 func MakeErrorReply(msg string) *ErrorReply {
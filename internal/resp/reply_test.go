@@ -1,6 +1,9 @@
 package resp
 
 import (
+	"bufio"
+	"bytes"
+	"io"
 	"reflect"
 	"strconv"
 	"testing"
@@ -117,3 +120,44 @@ func TestErrorReply_ToBytes(t *testing.T) {
 		t.Errorf("expecte d %v, got %v", expected, result)
 	}
 }
+
+func TestWriteToMatchesToBytes(t *testing.T) {
+	replies := []Reply{
+		MakePongReply(), MakeOkReply(), MakeNullBulkReply(), MakeEmptyMultiBulkReply(),
+		MakeStatusReply("QUEUED"), MakeErrorReply("ERR oops"), MakeIntegerReply(-42),
+		MakeBulkReply([]byte("value")), MakeMultiBulkReply([][]byte{[]byte("a"), nil}),
+		MakeNullReply(), MakeBooleanReply(true), MakeDoubleReply(3.25),
+		MakeVerbatimReply("txt", []byte("text")),
+		MakeMapReply([]Reply{MakeBulkReply([]byte("k")), MakeArrayReply([]Reply{MakeIntegerReply(1)})}),
+	}
+	for _, r := range replies {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		n, err := r.WriteTo(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = w.Flush()
+		if !bytes.Equal(buf.Bytes(), r.ToBytes()) || n != int64(buf.Len()) {
+			t.Errorf("WriteTo wrote %q (%d bytes), ToBytes returns %q", buf.Bytes(), n, r.ToBytes())
+		}
+	}
+}
+
+func BenchmarkBulkReplyWriteTo(b *testing.B) {
+	r := MakeBulkReply(bytes.Repeat([]byte("x"), 64))
+	w := bufio.NewWriter(io.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = r.WriteTo(w)
+	}
+}
+
+func BenchmarkBulkReplyToBytes(b *testing.B) {
+	r := MakeBulkReply(bytes.Repeat([]byte("x"), 64))
+	w := bufio.NewWriter(io.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = w.Write(r.ToBytes())
+	}
+}
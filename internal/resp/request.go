@@ -0,0 +1,198 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// RequestBacklog is the number of parsed requests ParseRequests buffers
+const RequestBacklog = 128
+
+// ParseRequests parses client requests the way Redis does: a line starting with '*'
+// is a multi bulk request made of bulk strings only, any other line is an inline
+// command whose arguments are separated by spaces and may be quoted. Every payload
+// is a MultiBulkReply with at least one argument, errors end the stream.
+// Up to RequestBacklog requests are parsed ahead, so that pipelined requests
+// can be taken from the channel in batches.
+func ParseRequests(reader io.Reader, limits Limits) <-chan *Payload {
+	ch := make(chan *Payload, RequestBacklog)
+	p := &parser{
+		reader: bufio.NewReader(reader),
+		limits: limits,
+	}
+	go p.parse(ch, p.nextRequest)
+	return ch
+}
+
+// nextRequest parses the next request, nil for an empty one
+func (p *parser) nextRequest() (Reply, error) {
+	line, err := p.readRawLine()
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if len(line) == 0 || line[0] != '*' {
+		args, err := splitArgs(line)
+		if err != nil || len(args) == 0 {
+			return nil, err
+		}
+		return MakeMultiBulkReply(args), nil
+	}
+
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n > p.limits.MaxMultiBulkLen {
+		return nil, &ProtocolError{Msg: "invalid multibulk length"}
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([][]byte, 0, min(n, 1024))
+	for i := int64(0); i < n; i++ {
+		header, err := p.readLine()
+		if err == nil && header == nil {
+			err = &ProtocolError{Msg: "expected '$', got nothing"}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if header[0] != '$' {
+			return nil, &ProtocolError{Msg: "expected '$', got '" + string(header[0]) + "'"}
+		}
+		arg, err := p.parseBlob(header)
+		if err == nil && arg == nil {
+			err = &ProtocolError{Msg: "invalid bulk length"}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return MakeMultiBulkReply(args), nil
+}
+
+// splitArgs splits an inline command like redis-cli does: arguments are separated
+// by white space, "double quotes" support escapes such as \n and \x41, 'single quotes'
+// only support \'. A closing quote must be followed by white space or the end of line.
+func splitArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		switch line[i] {
+		case '"':
+			i++
+			for {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					arg = append(arg, unhex(line[i+2])<<4|unhex(line[i+3]))
+					i += 4
+					continue
+				}
+				if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					default:
+						c = line[i]
+					}
+					arg = append(arg, c)
+					i++
+					continue
+				}
+				i++
+				if c == '"' {
+					break
+				}
+				arg = append(arg, c)
+			}
+			if i < len(line) && !isSpace(line[i]) {
+				return nil, errUnbalancedQuotes
+			}
+		case '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg = append(arg, '\'')
+					i += 2
+					continue
+				}
+				i++
+				if c == '\'' {
+					break
+				}
+				arg = append(arg, c)
+			}
+			if i < len(line) && !isSpace(line[i]) {
+				return nil, errUnbalancedQuotes
+			}
+		default:
+			start := i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			arg = line[start:i]
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
+
+var errUnbalancedQuotes = &ProtocolError{Msg: "unbalanced quotes in request"}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,54 @@
+package resp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequests(t *testing.T) {
+	input := "*2\r\n$3\r\nget\r\n$1\r\nk\r\n" +
+		"\r\n" +
+		"*0\r\n" +
+		"set k \"hello\\x20world\\n\" 'it\\'s'\n" +
+		"  ping  \r\n"
+	expected := [][]string{
+		{"get", "k"},
+		{"set", "k", "hello world\n", "it's"},
+		{"ping"},
+	}
+	var got [][]string
+	for payload := range ParseRequests(strings.NewReader(input), DefaultLimits) {
+		if payload.Err != nil {
+			break
+		}
+		var args []string
+		for _, arg := range payload.Data.(*MultiBulkReply).Args {
+			args = append(args, string(arg))
+		}
+		got = append(got, args)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestParseRequestsErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		msg   string
+	}{
+		{"set k \"unterminated\r\n", "unbalanced quotes in request"},
+		{"set k \"a\"b\r\n", "unbalanced quotes in request"},
+		{"*1\r\n:1\r\n", "expected '$', got ':'"},
+		{"*1\r\n$-1\r\n", "invalid bulk length"},
+		{"*x\r\n", "invalid multibulk length"},
+	}
+	for _, tt := range tests {
+		payload := <-ParseRequests(strings.NewReader(tt.input), DefaultLimits)
+		protoErr, ok := payload.Err.(*ProtocolError)
+		if !ok || protoErr.Msg != tt.msg {
+			t.Errorf("%q: expected protocol error %q, got %v", tt.input, tt.msg, payload.Err)
+		}
+	}
+}
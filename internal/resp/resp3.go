@@ -0,0 +1,357 @@
+package resp
+
+import (
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Protocol versions negotiated with HELLO
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+var nullBytes = []byte("_" + CRLF)
+
+/* ---- Null Reply ---- */
+
+// NullReply is the single null type of RESP3, it replaces both null bulk strings and null arrays
+type NullReply struct{}
+
+var nullReply = new(NullReply)
+
+func (r *NullReply) ToBytes() []byte {
+	return nullBytes
+}
+
+func (r *NullReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(nullBytes)
+	return int64(n), err
+}
+
+func MakeNullReply() *NullReply {
+	return nullReply
+}
+
+/* ---- Boolean Reply ---- */
+type BooleanReply struct {
+	Value bool
+}
+
+var (
+	trueBytes  = []byte("#t" + CRLF)
+	falseBytes = []byte("#f" + CRLF)
+)
+
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return trueBytes
+	}
+	return falseBytes
+}
+
+func (r *BooleanReply) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(r.ToBytes())
+	return int64(n), err
+}
+
+func MakeBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{Value: value}
+}
+
+/* ---- Double Reply ---- */
+type DoubleReply struct {
+	Value float64
+}
+
+func (r *DoubleReply) ToBytes() []byte {
+	return bytesOf(r, 32)
+}
+
+func (r *DoubleReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.line(',', FormatDouble(r.Value))
+	return rw.result()
+}
+
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+// FormatDouble formats a float the way Redis does, with the shortest exact representation and inf, -inf and nan
+func FormatDouble(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case math.IsNaN(v):
+		return "nan"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+/* ---- Big Number Reply ---- */
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+func (r *BigNumberReply) ToBytes() []byte {
+	return bytesOf(r, 32)
+}
+
+func (r *BigNumberReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.line('(', r.Value.String())
+	return rw.result()
+}
+
+func MakeBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{Value: value}
+}
+
+/* ---- Verbatim String Reply ---- */
+
+// VerbatimReply is a string meant to be displayed as is, Format is a 3 letter type such as "txt" or "mkd"
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func (r *VerbatimReply) ToBytes() []byte {
+	return bytesOf(r, len(r.Text)+16)
+}
+
+func (r *VerbatimReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.header('=', int64(len(r.Format)+1+len(r.Text)))
+	rw.writeString(r.Format)
+	rw.writeByte(':')
+	rw.write(r.Text)
+	rw.write(crlfBytes)
+	return rw.result()
+}
+
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
+
+/* ---- Aggregate Replies ---- */
+
+// ArrayReply is an array of arbitrary replies, unlike MultiBulkReply which only holds bulk strings
+type ArrayReply struct {
+	Replies []Reply
+}
+
+func (r *ArrayReply) ToBytes() []byte {
+	return bytesOf(r, 64)
+}
+
+func (r *ArrayReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '*', len(r.Replies), r.Replies)
+}
+
+func MakeArrayReply(replies []Reply) *ArrayReply {
+	return &ArrayReply{Replies: replies}
+}
+
+// MapReply holds alternating keys and values in their original order
+type MapReply struct {
+	Pairs []Reply
+}
+
+func (r *MapReply) ToBytes() []byte {
+	return bytesOf(r, 64)
+}
+
+func (r *MapReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '%', len(r.Pairs)/2, r.Pairs)
+}
+
+func MakeMapReply(pairs []Reply) *MapReply {
+	return &MapReply{Pairs: pairs}
+}
+
+type SetReply struct {
+	Members []Reply
+}
+
+func (r *SetReply) ToBytes() []byte {
+	return bytesOf(r, 64)
+}
+
+func (r *SetReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '~', len(r.Members), r.Members)
+}
+
+func MakeSetReply(members []Reply) *SetReply {
+	return &SetReply{Members: members}
+}
+
+// PushReply is out of band data sent by the server, such as Pub/Sub messages
+type PushReply struct {
+	Replies []Reply
+}
+
+func (r *PushReply) ToBytes() []byte {
+	return bytesOf(r, 64)
+}
+
+func (r *PushReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '>', len(r.Replies), r.Replies)
+}
+
+func MakePushReply(replies []Reply) *PushReply {
+	return &PushReply{Replies: replies}
+}
+
+// AttributeReply carries auxiliary key value pairs ahead of the actual Reply
+type AttributeReply struct {
+	Pairs []Reply
+	Reply Reply
+}
+
+func (r *AttributeReply) ToBytes() []byte {
+	return bytesOf(r, 64)
+}
+
+func (r *AttributeReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.header('|', int64(len(r.Pairs)/2))
+	for _, item := range r.Pairs {
+		rw.reply(item)
+	}
+	rw.reply(r.Reply)
+	return rw.result()
+}
+
+func MakeAttributeReply(pairs []Reply, reply Reply) *AttributeReply {
+	return &AttributeReply{Pairs: pairs, Reply: reply}
+}
+
+// SequenceReply is several replies to a single command, such as the confirmations of a
+// SUBSCRIBE to several channels. They are written one after another, not as an aggregate.
+type SequenceReply struct {
+	Replies []Reply
+}
+
+func (r *SequenceReply) ToBytes() []byte {
+	return bytesOf(r, 64)
+}
+
+func (r *SequenceReply) WriteTo(w io.Writer) (int64, error) {
+	rw := replyWriter{w: w}
+	for _, item := range r.Replies {
+		rw.reply(item)
+	}
+	return rw.result()
+}
+
+func MakeSequenceReply(replies []Reply) *SequenceReply {
+	return &SequenceReply{Replies: replies}
+}
+
+func writeAggregate(w io.Writer, prefix byte, n int, items []Reply) (int64, error) {
+	rw := replyWriter{w: w}
+	rw.header(prefix, int64(n))
+	for _, item := range items {
+		rw.reply(item)
+	}
+	return rw.result()
+}
+
+// ForProtocol converts a reply to the types of the given protocol version. Commands
+// reply with RESP3 types, which RESP2 clients receive in their traditional form:
+// maps and sets as flat arrays, doubles and big numbers as bulk strings, booleans
+// as integers. RESP3 clients receive null bulk strings as the RESP3 null.
+func ForProtocol(r Reply, protocol int) Reply {
+	if protocol == RESP3 {
+		return upgrade(r)
+	}
+	return downgrade(r)
+}
+
+// EncodedSize returns the number of bytes r takes once encoded in protocol
+func EncodedSize(r Reply, protocol int) int64 {
+	n, _ := ForProtocol(r, protocol).WriteTo(io.Discard)
+	return n
+}
+
+func downgrade(r Reply) Reply {
+	switch r := r.(type) {
+	case *NullReply:
+		return MakeNullBulkReply()
+	case *BooleanReply:
+		if r.Value {
+			return MakeIntegerReply(1)
+		}
+		return MakeIntegerReply(0)
+	case *DoubleReply:
+		return MakeBulkReply([]byte(FormatDouble(r.Value)))
+	case *BigNumberReply:
+		return MakeBulkReply([]byte(r.Value.String()))
+	case *VerbatimReply:
+		return MakeBulkReply(r.Text)
+	case *ArrayReply:
+		return MakeArrayReply(convertAll(r.Replies, downgrade))
+	case *MapReply:
+		return MakeArrayReply(convertAll(r.Pairs, downgrade))
+	case *SetReply:
+		return MakeArrayReply(convertAll(r.Members, downgrade))
+	case *PushReply:
+		return MakeArrayReply(convertAll(r.Replies, downgrade))
+	case *SequenceReply:
+		return MakeSequenceReply(convertAll(r.Replies, downgrade))
+	case *AttributeReply:
+		// RESP2 has no attributes, they are dropped
+		return downgrade(r.Reply)
+	default:
+		return r
+	}
+}
+
+func upgrade(r Reply) Reply {
+	switch r := r.(type) {
+	case *NullBulkReply, *NullArrayReply:
+		return MakeNullReply()
+	case *MultiBulkReply:
+		for _, arg := range r.Args {
+			if arg == nil {
+				replies := make([]Reply, len(r.Args))
+				for i, arg := range r.Args {
+					if arg == nil {
+						replies[i] = MakeNullReply()
+					} else {
+						replies[i] = MakeBulkReply(arg)
+					}
+				}
+				return MakeArrayReply(replies)
+			}
+		}
+		return r
+	case *ArrayReply:
+		return MakeArrayReply(convertAll(r.Replies, upgrade))
+	case *MapReply:
+		return MakeMapReply(convertAll(r.Pairs, upgrade))
+	case *SetReply:
+		return MakeSetReply(convertAll(r.Members, upgrade))
+	case *PushReply:
+		return MakePushReply(convertAll(r.Replies, upgrade))
+	case *SequenceReply:
+		return MakeSequenceReply(convertAll(r.Replies, upgrade))
+	case *AttributeReply:
+		return MakeAttributeReply(convertAll(r.Pairs, upgrade), upgrade(r.Reply))
+	default:
+		return r
+	}
+}
+
+func convertAll(replies []Reply, convert func(Reply) Reply) []Reply {
+	converted := make([]Reply, len(replies))
+	for i, r := range replies {
+		converted[i] = convert(r)
+	}
+	return converted
+}
@@ -0,0 +1,71 @@
+package resp
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestRESP3RoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply Reply
+		wire  string
+	}{
+		{"Null", MakeNullReply(), "_\r\n"},
+		{"Boolean", MakeBooleanReply(true), "#t\r\n"},
+		{"Double", MakeDoubleReply(1.5), ",1.5\r\n"},
+		{"Infinity", MakeDoubleReply(math.Inf(-1)), ",-inf\r\n"},
+		{"Big number", MakeBigNumberReply(new(big.Int).Lsh(big.NewInt(1), 70)), "(1180591620717411303424\r\n"},
+		{"Verbatim", MakeVerbatimReply("txt", []byte("hi")), "=6\r\ntxt:hi\r\n"},
+		{"Map", MakeMapReply([]Reply{MakeBulkReply([]byte("a")), MakeIntegerReply(1)}), "%1\r\n$1\r\na\r\n:1\r\n"},
+		{"Set", MakeSetReply([]Reply{MakeBulkReply([]byte("a"))}), "~1\r\n$1\r\na\r\n"},
+		{"Push", MakePushReply([]Reply{MakeBulkReply([]byte("message")), MakeDoubleReply(2)}), ">2\r\n$7\r\nmessage\r\n,2\r\n"},
+		{"Nested array", MakeArrayReply([]Reply{MakeIntegerReply(1), MakeArrayReply([]Reply{MakeNullReply()})}), "*2\r\n:1\r\n*1\r\n_\r\n"},
+		{
+			"Attribute",
+			MakeAttributeReply([]Reply{MakeStatusReply("ttl"), MakeIntegerReply(3)}, MakeBulkReply([]byte("v"))),
+			"|1\r\n+ttl\r\n:3\r\n$1\r\nv\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.reply.ToBytes()); got != tt.wire {
+				t.Fatalf("expected %q, got %q", tt.wire, got)
+			}
+			payload := <-ParseStream(bytes.NewReader([]byte(tt.wire)))
+			if payload.Err != nil {
+				t.Fatalf("unexpected error: %v", payload.Err)
+			}
+			if got := string(payload.Data.ToBytes()); got != tt.wire {
+				t.Errorf("expected %q after parsing, got %q", tt.wire, got)
+			}
+		})
+	}
+}
+
+func TestForProtocol(t *testing.T) {
+	hash := MakeMapReply([]Reply{MakeBulkReply([]byte("f")), MakeDoubleReply(0.5)})
+	if got := string(ForProtocol(hash, RESP2).ToBytes()); got != "*2\r\n$1\r\nf\r\n$3\r\n0.5\r\n" {
+		t.Errorf("unexpected RESP2 map %q", got)
+	}
+	if got := string(ForProtocol(MakeBooleanReply(false), RESP2).ToBytes()); got != ":0\r\n" {
+		t.Errorf("unexpected RESP2 boolean %q", got)
+	}
+	if got := string(ForProtocol(MakeNullBulkReply(), RESP3).ToBytes()); got != "_\r\n" {
+		t.Errorf("unexpected RESP3 null %q", got)
+	}
+	values := MakeMultiBulkReply([][]byte{[]byte("a"), nil})
+	if got := string(ForProtocol(values, RESP3).ToBytes()); got != "*2\r\n$1\r\na\r\n_\r\n" {
+		t.Errorf("unexpected RESP3 array %q", got)
+	}
+	confirmations := MakeSequenceReply([]Reply{
+		MakePushReply([]Reply{MakeBulkReply([]byte("subscribe")), MakeIntegerReply(1)}),
+		MakePushReply([]Reply{MakeBulkReply([]byte("subscribe")), MakeIntegerReply(2)}),
+	})
+	if got := string(ForProtocol(confirmations, RESP2).ToBytes()); got != "*2\r\n$9\r\nsubscribe\r\n:1\r\n*2\r\n$9\r\nsubscribe\r\n:2\r\n" {
+		t.Errorf("unexpected RESP2 sequence %q", got)
+	}
+}
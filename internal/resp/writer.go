@@ -0,0 +1,100 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+var crlfBytes = []byte(CRLF)
+
+// replyWriter writes the parts of a reply and keeps the first error, so that
+// WriteTo implementations can write piece by piece and check once
+type replyWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (rw *replyWriter) write(p []byte) {
+	if rw.err != nil {
+		return
+	}
+	n, err := rw.w.Write(p)
+	rw.n += int64(n)
+	rw.err = err
+}
+
+func (rw *replyWriter) writeString(s string) {
+	if rw.err != nil {
+		return
+	}
+	n, err := io.WriteString(rw.w, s)
+	rw.n += int64(n)
+	rw.err = err
+}
+
+func (rw *replyWriter) writeByte(c byte) {
+	if rw.err != nil {
+		return
+	}
+	if bw, ok := rw.w.(io.ByteWriter); ok {
+		rw.err = bw.WriteByte(c)
+		if rw.err == nil {
+			rw.n++
+		}
+		return
+	}
+	rw.write([]byte{c})
+}
+
+// header writes prefix, n and CRLF. A bufio.Writer lends its free space, which avoids allocating.
+func (rw *replyWriter) header(prefix byte, n int64) {
+	if bw, ok := rw.w.(*bufio.Writer); ok && bw.Available() >= 24 {
+		b := bw.AvailableBuffer()
+		b = append(b, prefix)
+		b = strconv.AppendInt(b, n, 10)
+		rw.write(append(b, '\r', '\n'))
+		return
+	}
+	var buf [24]byte
+	b := append(buf[:0], prefix)
+	b = strconv.AppendInt(b, n, 10)
+	rw.write(append(b, '\r', '\n'))
+}
+
+// line writes a simple type: prefix, s and CRLF
+func (rw *replyWriter) line(prefix byte, s string) {
+	rw.writeByte(prefix)
+	rw.writeString(s)
+	rw.write(crlfBytes)
+}
+
+// blob writes a length prefixed string
+func (rw *replyWriter) blob(prefix byte, data []byte) {
+	rw.header(prefix, int64(len(data)))
+	rw.write(data)
+	rw.write(crlfBytes)
+}
+
+func (rw *replyWriter) reply(r Reply) {
+	if rw.err != nil {
+		return
+	}
+	n, err := r.WriteTo(rw.w)
+	rw.n += n
+	rw.err = err
+}
+
+func (rw *replyWriter) result() (int64, error) {
+	return rw.n, rw.err
+}
+
+// bytesOf renders a reply with its WriteTo method, sizeHint is the expected length
+func bytesOf(r Reply, sizeHint int) []byte {
+	var buf bytes.Buffer
+	buf.Grow(sizeHint)
+	_, _ = r.WriteTo(&buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,283 @@
+// Package tracking implements server-assisted client-side caching: clients are told
+// when keys they may hold in a local cache are modified
+package tracking
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mirage208/redis-go/internal/connection"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// InvalidateChannel is the channel RESP2 clients subscribe to on the redirect connection
+const InvalidateChannel = "__redis__:invalidate"
+
+// Options are the options of CLIENT TRACKING ON
+type Options struct {
+	Redirect uint64   // ID of the client receiving the invalidations, 0 for the tracking client itself
+	BCast    bool     // invalidate every key matching Prefixes instead of the keys read
+	Prefixes []string // prefixes of the keys invalidated in BCAST mode, every key if empty
+	OptIn    bool     // only track the keys read right after CLIENT CACHING yes
+	OptOut   bool     // track the keys read unless right after CLIENT CACHING no
+	NoLoop   bool     // do not invalidate the keys the client modifies itself
+}
+
+// client is the tracking state of a connection
+type client struct {
+	conn    *connection.Connection
+	options Options
+	caching bool // set by CLIENT CACHING for the next command
+}
+
+// Table remembers which clients may cache which keys. It is not safe for concurrent use,
+// the command goroutine owns it.
+type Table struct {
+	clients map[uint64]*client
+	// keys maps the keys read in default mode to the IDs of the clients which read them,
+	// they are forgotten once invalidated
+	keys map[string]map[uint64]struct{}
+	// prefixes maps the BCAST prefixes to the IDs of the clients which registered them
+	prefixes map[string]map[uint64]struct{}
+	// lookup returns a connected client by ID, for redirections
+	lookup func(id uint64) *connection.Connection
+}
+
+// NewTable creates an empty table, lookup finds the redirect targets
+func NewTable(lookup func(id uint64) *connection.Connection) *Table {
+	return &Table{
+		clients:  make(map[uint64]*client),
+		keys:     make(map[string]map[uint64]struct{}),
+		prefixes: make(map[string]map[uint64]struct{}),
+		lookup:   lookup,
+	}
+}
+
+// Enable turns tracking on for c, or adds prefixes when it is already on in BCAST mode.
+// It returns an error reply if the options are invalid.
+func (t *Table) Enable(c *connection.Connection, options Options) resp.Reply {
+	if options.OptIn && options.OptOut {
+		return resp.MakeErrorReply("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if options.BCast && (options.OptIn || options.OptOut) {
+		return resp.MakeErrorReply("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if !options.BCast && len(options.Prefixes) > 0 {
+		return resp.MakeErrorReply("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if options.Redirect != 0 && options.Redirect != c.ID() && t.lookup(options.Redirect) == nil {
+		return resp.MakeErrorReply("ERR The client ID you want redirect to does not exist")
+	}
+	tc, ok := t.clients[c.ID()]
+	if ok {
+		if tc.options.BCast != options.BCast {
+			return resp.MakeErrorReply("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+		if tc.options.OptIn != options.OptIn || tc.options.OptOut != options.OptOut {
+			return resp.MakeErrorReply("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+	}
+	var registered []string
+	if ok {
+		registered = tc.options.Prefixes
+	} else if options.BCast && len(options.Prefixes) == 0 {
+		options.Prefixes = []string{""}
+	}
+	if errReply := checkPrefixes(registered, options.Prefixes); errReply != nil {
+		return errReply
+	}
+
+	if !ok {
+		tc = &client{conn: c}
+		t.clients[c.ID()] = tc
+	}
+	tc.options.Redirect = options.Redirect
+	tc.options.BCast, tc.options.OptIn, tc.options.OptOut = options.BCast, options.OptIn, options.OptOut
+	tc.options.NoLoop = options.NoLoop
+	for _, prefix := range options.Prefixes {
+		if slices.Contains(tc.options.Prefixes, prefix) {
+			continue
+		}
+		tc.options.Prefixes = append(tc.options.Prefixes, prefix)
+		ids, ok := t.prefixes[prefix]
+		if !ok {
+			ids = make(map[uint64]struct{})
+			t.prefixes[prefix] = ids
+		}
+		ids[c.ID()] = struct{}{}
+	}
+	return nil
+}
+
+// checkPrefixes rejects prefixes of which one is a prefix of another, for a key would be
+// invalidated twice. Registering a prefix again is allowed.
+func checkPrefixes(registered, added []string) resp.Reply {
+	for i, prefix := range added {
+		for _, other := range registered {
+			if prefix != other && overlap(prefix, other) {
+				return overlapError(prefix, other)
+			}
+		}
+		for _, other := range added[i+1:] {
+			if overlap(prefix, other) {
+				return overlapError(prefix, other)
+			}
+		}
+	}
+	return nil
+}
+
+func overlap(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+func overlapError(prefix, other string) resp.Reply {
+	return resp.MakeErrorReply("ERR Prefix '" + prefix + "' overlaps with an existing prefix '" + other + "'. Prefixes for a single client must not overlap.")
+}
+
+// Disable turns tracking off for c, such as when it is closed. The keys it read are not
+// removed from the table right away, they are skipped when invalidated.
+func (t *Table) Disable(c *connection.Connection) {
+	tc, ok := t.clients[c.ID()]
+	if !ok {
+		return
+	}
+	for _, prefix := range tc.options.Prefixes {
+		delete(t.prefixes[prefix], c.ID())
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	delete(t.clients, c.ID())
+}
+
+// Enabled reports whether tracking is on for c
+func (t *Table) Enabled(c *connection.Connection) bool {
+	_, ok := t.clients[c.ID()]
+	return ok
+}
+
+// Empty reports whether no key or prefix is tracked, nothing is then invalidated
+func (t *Table) Empty() bool {
+	return len(t.keys) == 0 && len(t.prefixes) == 0
+}
+
+// Options returns the tracking options of c, ok is false if tracking is off
+func (t *Table) Options(c *connection.Connection) (options Options, ok bool) {
+	tc, ok := t.clients[c.ID()]
+	if !ok {
+		return Options{}, false
+	}
+	options = tc.options
+	options.Prefixes = slices.Clone(options.Prefixes)
+	return options, true
+}
+
+// SetCaching implements CLIENT CACHING yes|no, which applies to the next command of c:
+// yes tracks its keys in OPTIN mode and no does not track them in OPTOUT mode
+func (t *Table) SetCaching(c *connection.Connection, yes bool) resp.Reply {
+	tc, ok := t.clients[c.ID()]
+	switch {
+	case !ok || !(tc.options.OptIn || tc.options.OptOut):
+		return resp.MakeErrorReply("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	case yes && !tc.options.OptIn:
+		return resp.MakeErrorReply("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	case !yes && !tc.options.OptOut:
+		return resp.MakeErrorReply("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	}
+	tc.caching = true
+	return nil
+}
+
+// Caching reports whether CLIENT CACHING was called for the next command of c
+func (t *Table) Caching(c *connection.Connection) bool {
+	tc, ok := t.clients[c.ID()]
+	return ok && tc.caching
+}
+
+// RedirectBroken reports whether the redirect target of c disconnected
+func (t *Table) RedirectBroken(c *connection.Connection) bool {
+	tc, ok := t.clients[c.ID()]
+	return ok && tc.options.Redirect != 0 && tc.options.Redirect != c.ID() && t.lookup(tc.options.Redirect) == nil
+}
+
+// AfterCommand remembers the keys read by a command of c, if c tracks the keys it reads.
+// keepCaching is set for the CLIENT commands, which do not consume CLIENT CACHING.
+func (t *Table) AfterCommand(c *connection.Connection, readKeys []string, keepCaching bool) {
+	tc, ok := t.clients[c.ID()]
+	if !ok {
+		return
+	}
+	track := !tc.options.BCast
+	switch {
+	case tc.options.OptIn:
+		track = tc.caching
+	case tc.options.OptOut:
+		track = !tc.caching
+	}
+	if track {
+		for _, key := range readKeys {
+			ids, ok := t.keys[key]
+			if !ok {
+				ids = make(map[uint64]struct{})
+				t.keys[key] = ids
+			}
+			ids[c.ID()] = struct{}{}
+		}
+	}
+	if !keepCaching {
+		tc.caching = false
+	}
+}
+
+// Invalidate tells the clients which may cache key that it was modified. origin is
+// the client which modified it, nil when it expired.
+func (t *Table) Invalidate(key string, origin *connection.Connection) {
+	for id := range t.keys[key] {
+		if tc, ok := t.clients[id]; ok && !tc.options.BCast {
+			t.send(tc, key, origin)
+		}
+	}
+	delete(t.keys, key)
+	for prefix, ids := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for id := range ids {
+			t.send(t.clients[id], key, origin)
+		}
+	}
+}
+
+// send delivers an invalidation to tc or to its redirect target
+func (t *Table) send(tc *client, key string, origin *connection.Connection) {
+	if tc.options.NoLoop && tc.conn == origin {
+		return
+	}
+	target := tc.conn
+	if tc.options.Redirect != 0 && tc.options.Redirect != tc.conn.ID() {
+		target = t.lookup(tc.options.Redirect)
+		if target == nil {
+			if tc.conn.Protocol() == resp.RESP3 {
+				tc.conn.Push(resp.MakePushReply([]resp.Reply{
+					resp.MakeBulkReply([]byte("tracking-redir-broken")),
+					resp.MakeIntegerReply(int64(tc.options.Redirect)),
+				}))
+			}
+			return
+		}
+	}
+	keys := resp.MakeMultiBulkReply([][]byte{[]byte(key)})
+	switch {
+	case target.Protocol() == resp.RESP3:
+		target.Push(resp.MakePushReply([]resp.Reply{resp.MakeBulkReply([]byte("invalidate")), keys}))
+	case target != tc.conn && target.HasSubscription(connection.SubChannel, InvalidateChannel):
+		// RESP2 has no push type, the redirect connection receives a Pub/Sub message, once
+		// it subscribed to the invalidation channel
+		target.Push(resp.MakePushReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("message")),
+			resp.MakeBulkReply([]byte(InvalidateChannel)),
+			keys,
+		}))
+	}
+}
@@ -7,12 +7,14 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mirage208/redis-go/internal/config"
 	"github.com/mirage208/redis-go/internal/connection"
 	"github.com/mirage208/redis-go/internal/database"
 	"github.com/mirage208/redis-go/internal/resp"
 	"github.com/mirage208/redis-go/pkg/logger"
-	"github.com/mirage208/redis-go/pkg/sync/atomic"
 )
 
 // RespHandler implements transport.Handler and serves as a redis service
@@ -20,82 +22,274 @@ type RespHandler struct {
 	// TODO
 	activeConn sync.Map // *client -> placeholder
 	db         database.DB
-	closing    atomic.Boolean // refusing new client and new request
+	limits     resp.Limits
+	closing    atomic.Bool // refusing new client and new request
+
+	clients    atomic.Int32  // connections being served
+	maxClients int32         // unlimited if 0
+	timeout    time.Duration // idle clients are closed after it, never if 0
+	keepAlive  time.Duration // TCP keepalive period, the system default if 0
+
+	draining        chan struct{} // closed by Close, clients stop once their current requests are replied
+	shutdownTimeout time.Duration // bounds Close
 }
 
-func NewHandler() *RespHandler {
-	// TODO
-	db := database.NewSequentialDB()
+// defaultShutdownTimeout bounds Close unless shutdown-timeout is set
+const defaultShutdownTimeout = 10 * time.Second
+
+// refuseWriteTimeout bounds the write of the error to a client refused over maxclients
+const refuseWriteTimeout = time.Second
+
+// keepaliveReply is written when a connection asked for it with Keepalive
+var keepaliveReply = resp.MakeRawReply([]byte("\n"))
+
+// NewHandler creates the handler of a server configured by cfg, which must not change afterwards
+func NewHandler(cfg *config.ServerProperties) *RespHandler {
+	db := database.NewSequentialDB(cfg)
 	return &RespHandler{
-		db: db,
+		db:         db,
+		limits:     cfg.ProtoLimits(),
+		maxClients: int32(cfg.MaxClients),
+		timeout:    time.Duration(cfg.Timeout) * time.Second,
+		keepAlive:  time.Duration(cfg.TCPKeepalive) * time.Second,
+
+		draining:        make(chan struct{}),
+		shutdownTimeout: shutdownTimeout(cfg),
 	}
 }
 
+// shutdownTimeout returns how long Close may take, shutdown-timeout overrides the default
+func shutdownTimeout(cfg *config.ServerProperties) time.Duration {
+	if cfg.ShutdownTimeout > 0 {
+		return time.Duration(cfg.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
 func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
-	// TODO
-	if h.closing.Get() || ctx.Done() != nil {
+	if h.closing.Load() || ctx.Err() != nil {
 		// closing handler refuse new connection
 		_ = conn.Close()
 		return
 	}
+	defer h.clients.Add(-1)
+	if n := h.clients.Add(1); h.maxClients > 0 && n > h.maxClients {
+		// a client which does not read must not hold up the goroutine, nor the count of clients
+		_ = conn.SetWriteDeadline(time.Now().Add(refuseWriteTimeout))
+		_, _ = resp.MakeErrorReply("ERR max number of clients reached").WriteTo(conn)
+		_ = conn.Close()
+		return
+	}
 
 	client := connection.NewConn(conn)
+	if h.keepAlive > 0 {
+		if err := client.SetKeepAlive(h.keepAlive); err != nil {
+			logger.Warnf("failed to set keepalive of client %s: %v", client.RemoteAddr(), err)
+		}
+	}
+	// clients are logged in as the default user unless it needs a password or is disabled
+	client.SetAuthenticated(!h.db.AuthRequired())
 	h.activeConn.Store(client, struct{}{})
 
-	ch := resp.ParseStream(conn)
-	for payload := range ch {
-		if payload.Err != nil {
-			if errors.Is(payload.Err, io.EOF) || errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				// connection closed
-				h.closeClient(client)
-				logger.Info("connection closed: ", client.RemoteAddr())
+	ch := resp.ParseRequests(conn, h.limits)
+	h.serve(client, ch)
+	_ = client.Flush()
+	h.closeClient(client)
+	// the parser stops once it fails to read from the closed connection
+	for range ch {
+	}
+}
+
+// serve executes the requests of a client until the stream ends or a protocol error occurs.
+// The requests the parser has ready are executed as one batch, and replies are buffered
+// until no request is left, so a pipeline costs one round trip to the DB and one write
+// per batch rather than per request. Replies pushed to the client, such as Pub/Sub
+// messages, are written between batches. Like Redis, a client idle for longer than the
+// timeout is closed, unless it is subscribed to Pub/Sub.
+func (h *RespHandler) serve(client *connection.Connection, ch <-chan *resp.Payload) {
+	batch := make([][][]byte, 0, maxBatch)
+	var idle *time.Timer
+	if h.timeout > 0 {
+		idle = time.NewTimer(h.timeout)
+		defer idle.Stop()
+	}
+	var next *resp.Payload
+	for {
+		select {
+		case <-h.draining:
+			// the requests not executed yet are dropped along with the connection
+			return
+		default:
+		}
+		payload := next
+		next = nil
+		if payload == nil {
+			// written first so that it precedes a snapshot taken below, one requested
+			// just before the snapshot was sent is an empty line the replica skips
+			if client.TakeKeepalive() {
+				if err := client.WriteReply(keepaliveReply); err != nil {
+					return
+				}
+			}
+			for _, pushed := range client.TakePushed() {
+				if err := client.WriteReply(pushed); err != nil {
+					return
+				}
+			}
+			if len(ch) == 0 {
+				if err := client.Flush(); err != nil {
+					return
+				}
+			}
+			var idleC <-chan time.Time
+			if idle != nil && !client.Subscribed() {
+				idleC = idle.C
+			}
+			select {
+			case payload = <-ch:
+				if payload == nil {
+					return
+				}
+			case <-client.PushReady():
+				continue
+			case <-h.draining:
+				return
+			case <-idleC:
+				logger.Info("closing idle client: ", client.RemoteAddr())
 				return
 			}
-			continue
+		}
+		if idle != nil {
+			idle.Reset(h.timeout)
+		}
+		if payload.Err != nil {
+			h.readFailed(client, payload.Err)
+			return
+		}
+		cmdLine := commandLine(payload)
+		if cmdLine == nil {
+			_ = client.WriteReply(resp.MakeErrorReply("ERR Protocol error: expected a command"))
+			return
 		}
 
-		if payload.Data == nil {
-			logger.Error("empty payload")
+		authenticated := client.Authenticated()
+		if !authenticated && !database.AllowedBeforeAuth(cmdLine[0]) {
+			if err := client.WriteReply(resp.MakeErrorReply("NOAUTH Authentication required.")); err != nil {
+				return
+			}
 			continue
 		}
 
-		r, ok := payload.Data.(*resp.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk protocol")
-			continue
+		// the commands of an unauthenticated client are executed one at a time, for AUTH
+		// changes how the following ones are handled
+		batch = append(batch[:0], cmdLine)
+	collect:
+		for authenticated && len(batch) < maxBatch {
+			select {
+			case next = <-ch:
+				cmdLine := commandLine(next)
+				if cmdLine == nil {
+					// errors and the end of the stream are handled once the batch is executed
+					break collect
+				}
+				batch = append(batch, cmdLine)
+				next = nil
+			default:
+				break collect
+			}
 		}
 
-		//cmdLine := ""
-		//for _, arg := range r.Args {
-		//	cmdLine += string(arg) + " "
-		//}
-		//logger.Debug(cmdLine)
-
-		result := h.db.Exec(client, r.Args)
-		if result != nil {
-			_, _ = client.Write(result.ToBytes())
-		} else {
-			_, _ = client.Write(resp.MakeErrorReply("unknown").ToBytes())
+		for _, result := range h.db.ExecBatch(client, batch) {
+			if _, ok := result.(*resp.NoReply); ok {
+				// turned off by CLIENT REPLY
+				continue
+			}
+			if result == nil {
+				result = resp.MakeErrorReply("unknown")
+			}
+			if err := client.WriteReply(result); err != nil {
+				return
+			}
 		}
+		if client.ClosingAfterReply() {
+			return
+		}
+	}
+}
+
+// maxBatch bounds how many pipelined requests are executed in one round trip to the DB
+const maxBatch = resp.RequestBacklog
+
+// commandLine returns the arguments of a request, nil if payload is not a command
+func commandLine(payload *resp.Payload) [][]byte {
+	if payload == nil || payload.Err != nil {
+		return nil
 	}
+	r, ok := payload.Data.(*resp.MultiBulkReply)
+	if !ok || len(r.Args) == 0 {
+		return nil
+	}
+	return r.Args
+}
+
+// readFailed reports why the request stream of a client ended
+func (h *RespHandler) readFailed(client *connection.Connection, err error) {
+	var protoErr *resp.ProtocolError
+	if errors.As(err, &protoErr) {
+		// like Redis, tell the client what went wrong and close the connection
+		_ = client.WriteReply(resp.MakeErrorReply("ERR Protocol error: " + protoErr.Msg))
+		logger.Warnf("protocol error from client %s: %s", client.RemoteAddr(), protoErr.Msg)
+		return
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) &&
+		!strings.Contains(err.Error(), "use of closed network connection") {
+		logger.Warnf("failed to read from client %s: %v", client.RemoteAddr(), err)
+	}
+	logger.Info("connection closed: ", client.RemoteAddr())
+}
+
+// ShutdownRequested is closed once a client asked for the server to shut down with SHUTDOWN
+func (h *RespHandler) ShutdownRequested() <-chan struct{} {
+	return h.db.ShutdownRequested()
 }
 
+// Close drains the handler: new connections are refused, the clients are closed once
+// the replies to the requests being executed are sent, then the DB saves its data and
+// stops. Clients which take too long are disconnected, and Close gives up waiting for
+// the DB once the shutdown timeout expired.
 func (h *RespHandler) Close() error {
-	// TODO
 	logger.Info("handler shutting down...")
-	h.closing.Set(true)
-	h.activeConn.Range(func(key any, val any) bool {
-		client := key.(*connection.Connection)
-		_ = client.Close()
-		return true
-	})
-	h.db.Close()
-	return nil
+	deadline := time.Now().Add(h.shutdownTimeout)
+	h.closing.Store(true)
+	close(h.draining)
+	for h.clients.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := h.clients.Load(); n > 0 {
+		logger.Warnf("%d clients still busy after the shutdown timeout, disconnecting them", n)
+		h.activeConn.Range(func(key any, val any) bool {
+			client := key.(*connection.Connection)
+			_ = client.Disconnect()
+			return true
+		})
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		h.db.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-time.After(time.Until(deadline)):
+		return errors.New("timed out waiting for the DB to close")
+	}
 }
 
 func (h *RespHandler) closeClient(client *connection.Connection) {
-	_ = client.Close()
+	// the DB and the handler forget the client before the connection can be reused
 	h.db.AfterClientClose(client)
 	h.activeConn.Delete(client)
+	_ = client.Close()
 }
@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// countingConn counts the writes of the handler to a connection
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int32
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+// serveHandler serves a handler on a loopback TCP listener and returns a connection to it,
+// writes counts the writes of the handler to the connections it accepted
+func serveHandler(tb testing.TB) (conn net.Conn, writes *atomic.Int32) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	h := NewHandler(&config.ServerProperties{Dir: tb.TempDir()})
+	writes = new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go h.Handle(context.Background(), &countingConn{Conn: conn, writes: writes})
+		}
+	}()
+	conn, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = conn.Close()
+		_ = listener.Close()
+		_ = h.Close()
+	})
+	return conn, writes
+}
+
+// pipeline encodes commands as one buffer, the way a pipelining client sends them
+func pipeline(commands ...[]string) []byte {
+	var buf bytes.Buffer
+	for _, command := range commands {
+		args := make([][]byte, len(command))
+		for i, arg := range command {
+			args[i] = []byte(arg)
+		}
+		_, _ = resp.MakeMultiBulkReply(args).WriteTo(&buf)
+	}
+	return buf.Bytes()
+}
+
+func TestPipelineReplies(t *testing.T) {
+	conn, writes := serveHandler(t)
+	const n = 16
+	var commands [][]string
+	var expected []string
+	for i := 0; i < n; i++ {
+		key, val := "key:"+strconv.Itoa(i), strconv.Itoa(i)
+		commands = append(commands, []string{"set", key, val}, []string{"get", key})
+		expected = append(expected, "+OK\r\n", "$"+strconv.Itoa(len(val))+"\r\n"+val+"\r\n")
+	}
+	if _, err := conn.Write(pipeline(commands...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// every reply arrives without sending anything more, the last one included
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	replies := resp.ParseStream(conn)
+	for i, want := range expected {
+		payload := <-replies
+		if payload == nil || payload.Err != nil {
+			t.Fatalf("reply %d: %v", i, payload)
+		}
+		if got := string(payload.Data.ToBytes()); got != want {
+			t.Errorf("reply %d: expected %q, got %q", i, want, got)
+		}
+	}
+	// replies are flushed when the parser has no request left, not one by one
+	if w := writes.Load(); w >= int32(len(expected)) {
+		t.Errorf("expected the replies to be coalesced, got %d writes for %d replies", w, len(expected))
+	}
+}
+
+func TestPipelineProtocolSwitch(t *testing.T) {
+	conn, _ := serveHandler(t)
+	if _, err := conn.Write(pipeline([]string{"get", "x"}, []string{"hello", "3"}, []string{"get", "x"})); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replies before HELLO keep the protocol the client had when they were produced
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got []byte
+	buf := make([]byte, 4096)
+	for !bytes.HasSuffix(got, []byte("_\r\n")) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read %q: %v", got, err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.HasPrefix(got, []byte("$-1\r\n%")) {
+		t.Errorf("expected a RESP2 null then the RESP3 HELLO reply, got %q", got)
+	}
+}
+
+// BenchmarkPipeline sends SET commands in pipelines of P requests, like
+// redis-benchmark -t set -P 16
+func BenchmarkPipeline(b *testing.B) {
+	for _, size := range []int{1, 16} {
+		b.Run("P"+strconv.Itoa(size), func(b *testing.B) {
+			conn, _ := serveHandler(b)
+			commands := make([][]string, size)
+			for i := range commands {
+				commands[i] = []string{"set", "key:" + strconv.Itoa(i), "xxx"}
+			}
+			request := pipeline(commands...)
+			replies := resp.ParseStream(conn)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(request); err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < size; j++ {
+					if payload := <-replies; payload == nil || payload.Err != nil {
+						b.Fatalf("reply %d: %v", j, payload)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "requests/s")
+		})
+	}
+}
+
+func TestRefusedClientDoesNotBlock(t *testing.T) {
+	h := NewHandler(&config.ServerProperties{Dir: t.TempDir(), MaxClients: 1})
+	// a client is served already, Close does not wait for it
+	h.clients.Store(1)
+	defer func() {
+		h.clients.Store(0)
+		_ = h.Close()
+	}()
+
+	// the peer of a pipe never reads, the error cannot be written
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	done := make(chan struct{})
+	go func() {
+		h.Handle(context.Background(), conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(refuseWriteTimeout + 2*time.Second):
+		t.Fatal("expected the refused client to be closed despite the pending write")
+	}
+	if n := h.clients.Load(); n != 1 {
+		t.Errorf("expected the refused client not to be counted, got %d clients", n)
+	}
+}
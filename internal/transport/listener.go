@@ -2,6 +2,7 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -17,9 +18,11 @@ import (
 
 // Config stores service properties
 type Config struct {
-	Address    string        `yaml:"address"`
-	MaxConnect uint32        `yaml:"max-connect"`
-	Timeout    time.Duration `yaml:"timeout"`
+	Address        string      `yaml:"address"` // plain TCP address, none if empty
+	TLSAddress     string      `yaml:"tls-address"`
+	TLSConfig      *tls.Config `yaml:"-"` // required with TLSAddress
+	UnixSocket     string      `yaml:"unixsocket"`
+	UnixSocketPerm os.FileMode `yaml:"unixsocketperm"` // the umask applies if 0
 }
 
 // ClientCounter Record the number of clients in the current github.com/mirage208/redis-go service
@@ -31,6 +34,7 @@ func ListenAndServeWithSignal(cfg *Config, handler Handler) error {
 	defer close(closeChan)
 	sigCh := make(chan os.Signal, 1)
 	defer close(sigCh)
+	defer signal.Stop(sigCh)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		sig := <-sigCh
@@ -40,32 +44,112 @@ func ListenAndServeWithSignal(cfg *Config, handler Handler) error {
 		}
 	}()
 
-	listener, err := net.Listen("tcp", cfg.Address)
-	if err != nil {
-		return err
+	var listeners []net.Listener
+	closeListeners := func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+	}
+	if cfg.TLSAddress != "" {
+		listener, err := tls.Listen("tcp", cfg.TLSAddress, cfg.TLSConfig)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("bind: %s, start listening for TLS...", cfg.TLSAddress))
 	}
-	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
+	if cfg.UnixSocket != "" {
+		listener, err := ListenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("unix socket: %s, start listening...", cfg.UnixSocket))
+	}
+	if len(listeners) == 0 {
+		return errors.New("no address to listen on")
+	}
+	Serve(listeners, handler, closeChan)
 	return nil
 }
 
+// ListenUnix listens on a Unix socket, replacing the file of a previous run, and sets
+// its permissions unless perm is 0. The file is removed once the listener is closed.
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
 func ListenAndServe(listener net.Listener, handler Handler, closeChan <-chan struct{}) {
-	errCh := make(chan error, 1)
-	defer close(errCh)
+	Serve([]net.Listener{listener}, handler, closeChan)
+}
+
+// Serve accepts connections on every listener, such as a plain and a TLS one, until
+// closeChan is signalled, the handler asks for a shutdown or one of them fails. It
+// returns once the handler is closed.
+func Serve(listeners []net.Listener, handler Handler, closeChan <-chan struct{}) {
+	// each accept loop sends at most one error
+	errCh := make(chan error, len(listeners))
+	shutdown := make(chan struct{})
 	go func() {
+		defer close(shutdown)
 		select {
 		case <-closeChan:
 			logger.Info("get exit signal")
+		case <-handler.ShutdownRequested():
+			logger.Info("shutdown requested")
 		case er := <-errCh:
 			logger.Info(fmt.Sprintf("accept error: %s", er.Error()))
 		}
 		logger.Info("shutting down...")
-		_ = listener.Close() // listener.Accept() will return err immediately
-		_ = handler.Close()  // close connections
+		for _, listener := range listeners {
+			_ = listener.Close() // listener.Accept() will return err immediately
+		}
+		// drain the connections
+		if err := handler.Close(); err != nil {
+			logger.Errorf("failed to shut down gracefully: %v", err)
+		}
 	}()
 
 	ctx := context.Background()
-	var waitDone sync.WaitGroup
+	var waitDone, acceptDone sync.WaitGroup
+	for _, listener := range listeners {
+		acceptDone.Add(1)
+		go func() {
+			defer acceptDone.Done()
+			accept(ctx, listener, handler, errCh, &waitDone)
+		}()
+	}
+	acceptDone.Wait()
+	waitDone.Wait()
+	// return once the handler is closed, its data written to disk
+	<-shutdown
+}
+
+// accept serves the connections of listener until it fails, which is reported to errCh
+func accept(ctx context.Context, listener net.Listener, handler Handler, errCh chan<- error, waitDone *sync.WaitGroup) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -77,7 +161,7 @@ func ListenAndServe(listener net.Listener, handler Handler, closeChan <-chan str
 				continue
 			}
 			errCh <- err
-			break
+			return
 		}
 		// handle
 		logger.Info("accept link")
@@ -91,7 +175,6 @@ func ListenAndServe(listener net.Listener, handler Handler, closeChan <-chan str
 			handler.Handle(ctx, conn)
 		}()
 	}
-	waitDone.Wait()
 }
 
 // HandleFunc represents application handler function
@@ -100,5 +183,7 @@ type HandleFunc func(ctx context.Context, conn net.Conn)
 // Handler represents application server over tcp
 type Handler interface {
 	Handle(ctx context.Context, conn net.Conn)
+	// ShutdownRequested is closed when the server should stop, e.g. on a SHUTDOWN command
+	ShutdownRequested() <-chan struct{}
 	Close() error
 }
@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mirage208/redis-go/internal/config"
+)
+
+// NewTLSConfig creates the TLS configuration of the server from the tls-* directives.
+// Client certificates are verified against tls-ca-cert-file when it is set, and
+// tls-auth-clients tells whether they are required.
+func NewTLSConfig(cfg *config.ServerProperties) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required to serve TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	authClients := strings.ToLower(cfg.TLSAuthClients)
+	switch authClients {
+	case "", "yes", "no", "optional":
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients: %s", cfg.TLSAuthClients)
+	}
+	if cfg.TLSCACertFile == "" {
+		if authClients == "yes" || authClients == "optional" {
+			return nil, errors.New("tls-ca-cert-file is required to authenticate clients")
+		}
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(cfg.TLSCACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", cfg.TLSCACertFile)
+	}
+	tlsConfig.ClientCAs = pool
+	switch authClients {
+	case "", "yes":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
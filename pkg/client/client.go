@@ -0,0 +1,235 @@
+// Package client is a client for the RESP protocol, which talks to this server or to Redis.
+// A Client is safe for concurrent use: every command borrows a connection from a pool.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// Options configure a Client, only Addr is required
+type Options struct {
+	Network string // "tcp" by default
+	Addr    string
+	// TLSConfig enables TLS, it holds the CA verifying the server and the client certificate if any
+	TLSConfig *tls.Config
+
+	// Username and Password authenticate every connection, Username defaults to the default user
+	Username string
+	Password string
+
+	// Protocol is the RESP version, 2 by default. With 3 connections start with HELLO 3.
+	Protocol int
+
+	// PoolSize bounds the number of open connections, 10 by default
+	PoolSize    int
+	DialTimeout time.Duration // 5 seconds by default
+
+	// OnPush receives the out of band replies of RESP3, such as the invalidations of
+	// CLIENT TRACKING. They are read along with the replies to the next commands sent
+	// on the connection, and dropped if OnPush is nil.
+	OnPush func(push *PushReply)
+}
+
+// ErrClosed is returned once the Client is closed
+var ErrClosed = errors.New("client: closed")
+
+type Client struct {
+	opts Options
+
+	idle chan *conn    // connections ready for use
+	sem  chan struct{} // one token per connection in use
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// New returns a Client for opts, connections are opened on demand
+func New(opts Options) *Client {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	return &Client{
+		opts: opts,
+		idle: make(chan *conn, opts.PoolSize),
+		sem:  make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// Do sends a command and returns its reply. An error replied by the server is
+// returned as an *Error along with the reply.
+func (c *Client) Do(ctx context.Context, args ...any) (Reply, error) {
+	cmdLine, err := encodeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var reply Reply
+	err = c.withConn(ctx, func(cn *conn) error {
+		replies, err := cn.roundTrip(cmdLine)
+		if err != nil {
+			return err
+		}
+		reply = replies[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withError(reply)
+}
+
+// withError returns an error reply as the error as well
+func withError(reply Reply) (Reply, error) {
+	if errReply, ok := reply.(*resp.ErrorReply); ok {
+		return reply, errReply
+	}
+	return reply, nil
+}
+
+// Close closes the idle connections, connections in use are closed once released
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	var err error
+	for {
+		select {
+		case cn := <-c.idle:
+			if cerr := cn.close(); err == nil {
+				err = cerr
+			}
+		default:
+			return err
+		}
+	}
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// withConn runs fn with a connection of the pool. The connection is aborted when
+// ctx is done, and discarded when its stream could be out of sync.
+func (c *Client) withConn(ctx context.Context, fn func(cn *conn) error) error {
+	cn, err := c.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	stop := cn.watch(ctx)
+	err = fn(cn)
+	if !stop() {
+		cn.broken = true
+		if err != nil {
+			err = ctx.Err()
+		}
+	}
+	c.putConn(cn)
+	return err
+}
+
+func (c *Client) getConn(ctx context.Context) (*conn, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if c.isClosed() {
+		<-c.sem
+		return nil, ErrClosed
+	}
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	cn, err := c.dial(ctx)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+	return cn, nil
+}
+
+func (c *Client) putConn(cn *conn) {
+	c.mu.Lock()
+	if cn.broken || c.closed {
+		c.mu.Unlock()
+		_ = cn.close()
+	} else {
+		// never blocks: there are no more connections than tokens
+		c.idle <- cn
+		c.mu.Unlock()
+	}
+	<-c.sem
+}
+
+// dial opens a connection and authenticates it, or switches it to RESP3
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.opts.DialTimeout}
+	var netConn net.Conn
+	var err error
+	if c.opts.TLSConfig != nil {
+		tlsDialer := tls.Dialer{NetDialer: dialer, Config: c.opts.TLSConfig}
+		netConn, err = tlsDialer.DialContext(ctx, c.opts.Network, c.opts.Addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, c.opts.Network, c.opts.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cn := newConn(netConn)
+	cn.onPush = c.opts.OnPush
+
+	var handshake [][]byte
+	if c.opts.Protocol == resp.RESP3 {
+		handshake = [][]byte{[]byte("HELLO"), []byte("3")}
+		if c.opts.Password != "" {
+			handshake = append(handshake, []byte("AUTH"), []byte(c.username()), []byte(c.opts.Password))
+		}
+	} else if c.opts.Password != "" {
+		handshake = [][]byte{[]byte("AUTH"), []byte(c.username()), []byte(c.opts.Password)}
+	}
+	if handshake != nil {
+		stop := cn.watch(ctx)
+		replies, err := cn.roundTrip(handshake)
+		if !stop() {
+			err = ctx.Err()
+		}
+		if err == nil {
+			if errReply, ok := replies[0].(*resp.ErrorReply); ok {
+				err = errReply
+			}
+		}
+		if err != nil {
+			_ = cn.close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) username() string {
+	if c.opts.Username == "" {
+		return "default"
+	}
+	return c.opts.Username
+}
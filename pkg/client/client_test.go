@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/resp"
+	"github.com/mirage208/redis-go/pkg/server"
+)
+
+// startServer starts an empty server and returns its address
+func startServer(t *testing.T) string {
+	s, addr, err := server.Start(server.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return addr
+}
+
+func TestDo(t *testing.T) {
+	c := New(Options{Addr: startServer(t), PoolSize: 2})
+	defer c.Close()
+	ctx := context.Background()
+
+	id, err := Int64(c.Do(ctx, "CLIENT", "ID"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(ctx, "SET", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := String(c.Do(ctx, "GET", "a")); err != nil || v != "1" {
+		t.Fatalf("expected 1, got %q %v", v, err)
+	}
+	if _, err := String(c.Do(ctx, "GET", "missing")); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	var serverErr *Error
+	if _, err := c.Do(ctx, "NOPE"); !errors.As(err, &serverErr) {
+		t.Fatalf("expected a server error, got %v", err)
+	}
+	if again, err := Int64(c.Do(ctx, "CLIENT", "ID")); err != nil || again != id {
+		t.Errorf("expected the connection to be reused, got client %d after %d %v", again, id, err)
+	}
+}
+
+func TestPipelined(t *testing.T) {
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+
+	replies, err := c.Pipelined(context.Background(), func(p *Pipeline) {
+		p.Do("SET", "a", "x")
+		p.Do("GET", "a")
+		p.Do("NOPE")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 {
+		t.Fatalf("expected 3 replies, got %d", len(replies))
+	}
+	if v, err := Strings(resp.MakeArrayReply(replies[:2]), nil); err != nil || v[0] != "OK" || v[1] != "x" {
+		t.Errorf("unexpected replies %q %v", v, err)
+	}
+	if _, ok := replies[2].(*Error); !ok {
+		t.Errorf("expected an error reply, got %T", replies[2])
+	}
+}
+
+func TestTxPipelined(t *testing.T) {
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+	ctx := context.Background()
+
+	replies, err := c.TxPipelined(ctx, func(p *Pipeline) {
+		p.Do("SET", "a", "y")
+		p.Do("GET", "a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := Strings(resp.MakeArrayReply(replies), nil); err != nil || v[0] != "OK" || v[1] != "y" {
+		t.Errorf("unexpected replies %q %v", v, err)
+	}
+
+	// a command rejected while queueing is the error of the transaction
+	_, err = c.TxPipelined(ctx, func(p *Pipeline) {
+		p.Do("SET", "a", "z")
+		p.Do("NOPE")
+	})
+	var serverErr *Error
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("expected the error of NOPE, got %v", err)
+	}
+	if v, err := String(c.Do(ctx, "GET", "a")); err != nil || v != "y" {
+		t.Errorf("expected the transaction to be discarded, got %q %v", v, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+	ctx := context.Background()
+
+	// a key modified between WATCH and EXEC aborts the transaction, the caller then retries
+	tries := 0
+	incr := func(tx *Tx) error {
+		tries++
+		n, err := Int64(tx.Do("GET", "counter"))
+		if err != nil && err != ErrNil {
+			return err
+		}
+		if tries == 1 {
+			if _, err := c.Do(ctx, "SET", "counter", 10); err != nil {
+				return err
+			}
+		}
+		_, err = tx.TxPipelined(func(p *Pipeline) {
+			p.Do("SET", "counter", n+1)
+		})
+		return err
+	}
+	err := c.Watch(ctx, incr, "counter")
+	if !errors.Is(err, ErrTxAborted) {
+		t.Fatalf("expected the transaction to be aborted, got %v", err)
+	}
+	if err := c.Watch(ctx, incr, "counter"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Int64(c.Do(ctx, "GET", "counter")); err != nil || n != 11 {
+		t.Errorf("expected 11, got %d %v", n, err)
+	}
+}
+
+func TestLargeReply(t *testing.T) {
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+	ctx := context.Background()
+
+	// replies are not bound by the limits of requests
+	const n = 1<<20 + 1
+	members := make([]any, 0, 1024+2)
+	for i := 0; i < n; i += 1024 {
+		members = append(members[:0], "SADD", "s")
+		for j := i; j < min(i+1024, n); j++ {
+			members = append(members, j)
+		}
+		if _, err := c.Do(ctx, members...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := Strings(c.Do(ctx, "SMEMBERS", "s")); err != nil || len(v) != n {
+		t.Errorf("expected %d members, got %d %v", n, len(v), err)
+	}
+}
+
+func TestContextCancelsCommand(t *testing.T) {
+	ctx := context.Background()
+	addr := startServer(t)
+	c := New(Options{Addr: addr, PoolSize: 1})
+	defer c.Close()
+	admin := New(Options{Addr: addr})
+	defer admin.Close()
+
+	id, err := Int64(c.Do(ctx, "CLIENT", "ID"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Do(ctx, "CLIENT", "PAUSE", 10000, "WRITE"); err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(timeout, "SET", "a", "z"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err := admin.Do(ctx, "CLIENT", "UNPAUSE"); err != nil {
+		t.Fatal(err)
+	}
+	// the connection is out of sync and must not be reused
+	if again, err := Int64(c.Do(ctx, "CLIENT", "ID")); err != nil || again == id {
+		t.Errorf("expected a new connection, got client %d after %d %v", again, id, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+
+	ps, err := c.Subscribe(ctx, "news", "sport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Int64(c.Do(ctx, "PUBLISH", "news", "hello")); err != nil || n != 1 {
+		t.Fatalf("expected 1 receiver, got %d %v", n, err)
+	}
+	select {
+	case msg := <-ps.Channel():
+		if msg.Channel != "news" || string(msg.Payload) != "hello" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-ps.Channel(); ok {
+		t.Error("expected the channel to be closed")
+	}
+	if err := ps.Err(); err != nil {
+		t.Errorf("expected no error after Close, got %v", err)
+	}
+}
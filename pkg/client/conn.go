@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// conn is a connection to the server, used by one caller at a time
+type conn struct {
+	netConn net.Conn
+	writer  *bufio.Writer
+	replies <-chan *resp.Payload
+	broken  bool // the stream is out of sync or failed, the connection must not be reused
+	onPush  func(push *PushReply)
+}
+
+func newConn(netConn net.Conn) *conn {
+	return &conn{
+		netConn: netConn,
+		writer:  bufio.NewWriter(netConn),
+		replies: resp.ParseStreamWithLimits(netConn, resp.ReplyLimits),
+	}
+}
+
+// writeCommands sends commands in a single write
+func (cn *conn) writeCommands(cmds ...[][]byte) error {
+	for _, args := range cmds {
+		if _, err := resp.MakeMultiBulkReply(args).WriteTo(cn.writer); err != nil {
+			cn.broken = true
+			return err
+		}
+	}
+	if err := cn.writer.Flush(); err != nil {
+		cn.broken = true
+		return err
+	}
+	return nil
+}
+
+// readReply returns the next reply, errors replied by the server are replies like any other
+func (cn *conn) readReply() (Reply, error) {
+	payload, ok := <-cn.replies
+	if !ok {
+		cn.broken = true
+		return nil, io.ErrUnexpectedEOF
+	}
+	if payload.Err != nil {
+		cn.broken = true
+		return nil, payload.Err
+	}
+	return payload.Data, nil
+}
+
+// roundTrip sends commands and reads one reply for each
+func (cn *conn) roundTrip(cmds ...[][]byte) ([]Reply, error) {
+	if err := cn.writeCommands(cmds...); err != nil {
+		return nil, err
+	}
+	replies := make([]Reply, len(cmds))
+	for i := 0; i < len(cmds); {
+		reply, err := cn.readReply()
+		if err != nil {
+			return nil, err
+		}
+		if push, ok := reply.(*PushReply); ok {
+			if cn.onPush != nil {
+				cn.onPush(push)
+			}
+			continue
+		}
+		replies[i] = reply
+		i++
+	}
+	return replies, nil
+}
+
+// watch aborts blocked reads and writes once ctx is done, stop reports whether it did not
+func (cn *conn) watch(ctx context.Context) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		_ = cn.netConn.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+func (cn *conn) close() error {
+	err := cn.netConn.Close()
+	// the parser stops once it fails to read from the closed connection
+	for range cn.replies {
+	}
+	return err
+}
+
+// encodeArgs converts command arguments to their RESP form
+func encodeArgs(args []any) ([][]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("client: empty command")
+	}
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			cmdLine[i] = []byte(arg)
+		case []byte:
+			cmdLine[i] = arg
+		case int:
+			cmdLine[i] = strconv.AppendInt(nil, int64(arg), 10)
+		case int64:
+			cmdLine[i] = strconv.AppendInt(nil, arg, 10)
+		case uint64:
+			cmdLine[i] = strconv.AppendUint(nil, arg, 10)
+		case float64:
+			cmdLine[i] = []byte(resp.FormatDouble(arg))
+		case bool:
+			if arg {
+				cmdLine[i] = []byte("1")
+			} else {
+				cmdLine[i] = []byte("0")
+			}
+		default:
+			return nil, fmt.Errorf("client: unsupported argument type %T", arg)
+		}
+	}
+	return cmdLine, nil
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// ErrTxAborted is returned when EXEC did not run the transaction because a watched key changed
+var ErrTxAborted = errors.New("client: transaction aborted")
+
+// Pipeline queues commands which are sent together
+type Pipeline struct {
+	cmds [][][]byte
+	err  error
+}
+
+// Do queues a command, its reply is returned at the same position
+func (p *Pipeline) Do(args ...any) {
+	cmdLine, err := encodeArgs(args)
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+		}
+		return
+	}
+	p.cmds = append(p.cmds, cmdLine)
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Pipelined sends the commands queued by fn in a single write and returns their replies.
+// Errors replied by the server are returned as *Error replies, err only reports a
+// command which could not be encoded or a failure to talk to the server.
+func (c *Client) Pipelined(ctx context.Context, fn func(p *Pipeline)) ([]Reply, error) {
+	var p Pipeline
+	fn(&p)
+	if p.err != nil || p.Len() == 0 {
+		return nil, p.err
+	}
+	var replies []Reply
+	err := c.withConn(ctx, func(cn *conn) (err error) {
+		replies, err = cn.roundTrip(p.cmds...)
+		return err
+	})
+	return replies, err
+}
+
+// TxPipelined runs the commands queued by fn in a MULTI/EXEC transaction and returns
+// their replies. A command rejected while queueing discards the whole transaction and
+// is returned as err.
+func (c *Client) TxPipelined(ctx context.Context, fn func(p *Pipeline)) ([]Reply, error) {
+	var p Pipeline
+	fn(&p)
+	if p.err != nil {
+		return nil, p.err
+	}
+	var replies []Reply
+	err := c.withConn(ctx, func(cn *conn) (err error) {
+		replies, err = execTx(cn, p.cmds)
+		return err
+	})
+	return replies, err
+}
+
+// Tx is a connection reserved by Watch
+type Tx struct {
+	cn *conn
+}
+
+// Do runs a command on the watching connection, like Client.Do
+func (tx *Tx) Do(args ...any) (Reply, error) {
+	cmdLine, err := encodeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := tx.cn.roundTrip(cmdLine)
+	if err != nil {
+		return nil, err
+	}
+	return withError(replies[0])
+}
+
+// TxPipelined runs a transaction on the watching connection, like Client.TxPipelined.
+// It returns ErrTxAborted when a watched key changed.
+func (tx *Tx) TxPipelined(fn func(p *Pipeline)) ([]Reply, error) {
+	var p Pipeline
+	fn(&p)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return execTx(tx.cn, p.cmds)
+}
+
+// Watch watches keys and runs fn on the same connection: fn typically reads the keys
+// with tx.Do and writes with tx.TxPipelined, which fails with ErrTxAborted when one
+// of the keys changed in the meantime so that fn can be retried.
+func (c *Client) Watch(ctx context.Context, fn func(tx *Tx) error, keys ...string) error {
+	return c.withConn(ctx, func(cn *conn) error {
+		if len(keys) > 0 {
+			watch := make([][]byte, 0, len(keys)+1)
+			watch = append(watch, []byte("WATCH"))
+			for _, key := range keys {
+				watch = append(watch, []byte(key))
+			}
+			replies, err := cn.roundTrip(watch)
+			if err != nil {
+				return err
+			}
+			if _, err := withError(replies[0]); err != nil {
+				return err
+			}
+		}
+		err := fn(&Tx{cn: cn})
+		if !cn.broken {
+			// EXEC unwatches, but fn may have returned before it
+			if _, uerr := cn.roundTrip([][]byte{[]byte("UNWATCH")}); err == nil {
+				err = uerr
+			}
+		}
+		return err
+	})
+}
+
+// execTx sends MULTI, cmds and EXEC in a single write and returns the replies of EXEC
+func execTx(cn *conn, cmds [][][]byte) ([]Reply, error) {
+	all := make([][][]byte, 0, len(cmds)+2)
+	all = append(all, [][]byte{[]byte("MULTI")})
+	all = append(all, cmds...)
+	all = append(all, [][]byte{[]byte("EXEC")})
+	replies, err := cn.roundTrip(all...)
+	if err != nil {
+		return nil, err
+	}
+	queued, exec := replies[:len(replies)-1], replies[len(replies)-1]
+	switch r := exec.(type) {
+	case *resp.ErrorReply:
+		// EXECABORT, the cause is the command which failed to queue
+		for _, reply := range queued {
+			if errReply, ok := reply.(*resp.ErrorReply); ok {
+				return nil, errReply
+			}
+		}
+		return nil, r
+	case *resp.NullBulkReply, *resp.NullReply:
+		return nil, ErrTxAborted
+	}
+	results, ok := elementsOf(exec)
+	if !ok {
+		return nil, fmt.Errorf("client: unexpected reply %T to EXEC", exec)
+	}
+	return results, nil
+}
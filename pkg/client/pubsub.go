@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// messageBuffer is the number of messages buffered before reading from the server stops
+const messageBuffer = 100
+
+// Message is a message published to a subscribed channel
+type Message struct {
+	Channel string
+	Pattern string // the pattern which matched Channel, for pattern subscriptions
+	Payload []byte
+}
+
+// PubSub is a connection in subscribed mode. It has its own connection outside the pool.
+type PubSub struct {
+	cn       *conn
+	mu       sync.Mutex // serializes writes
+	messages chan *Message
+	closing  chan struct{}
+	done     chan struct{} // closed once the reader stopped
+	err      error         // why the reader stopped, set before done is closed
+
+	closeOnce sync.Once
+}
+
+// Subscribe subscribes to channels and returns once the server confirmed it
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (*PubSub, error) {
+	return c.newPubSub(ctx, "SUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes to glob-style patterns and returns once the server confirmed it
+func (c *Client) PSubscribe(ctx context.Context, patterns ...string) (*PubSub, error) {
+	return c.newPubSub(ctx, "PSUBSCRIBE", patterns)
+}
+
+// SSubscribe subscribes to shard channels, which must hash to the same slot, and returns
+// once the server confirmed it
+func (c *Client) SSubscribe(ctx context.Context, channels ...string) (*PubSub, error) {
+	return c.newPubSub(ctx, "SSUBSCRIBE", channels)
+}
+
+func (c *Client) newPubSub(ctx context.Context, command string, names []string) (*PubSub, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("client: %s needs at least one name", command)
+	}
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ps := &PubSub{
+		cn:       cn,
+		messages: make(chan *Message, messageBuffer),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	stop := cn.watch(ctx)
+	err = ps.confirm(command, names)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = cn.close()
+		return nil, err
+	}
+	go ps.receive()
+	return ps, nil
+}
+
+// confirm sends the first subscription and waits for one confirmation per name,
+// messages received in the meantime are kept
+func (ps *PubSub) confirm(command string, names []string) error {
+	if err := ps.cn.writeCommands(subscription(command, names)); err != nil {
+		return err
+	}
+	kind := strings.ToLower(command)
+	for confirmed := 0; confirmed < len(names); {
+		reply, err := ps.cn.readReply()
+		if err != nil {
+			return err
+		}
+		if errReply, ok := reply.(*resp.ErrorReply); ok {
+			return errReply
+		}
+		if msg := parseMessage(reply); msg != nil {
+			select {
+			case ps.messages <- msg:
+			default:
+				// messages beyond the buffer are dropped until the reader runs
+			}
+			continue
+		}
+		if elements, ok := elementsOf(reply); ok && len(elements) > 0 {
+			if s, _ := String(elements[0], nil); strings.ToLower(s) == kind {
+				confirmed++
+				continue
+			}
+		}
+		return fmt.Errorf("client: unexpected reply %T to %s", reply, command)
+	}
+	return nil
+}
+
+// receive reads messages until the connection is closed
+func (ps *PubSub) receive() {
+	defer close(ps.done)
+	defer close(ps.messages)
+	for {
+		reply, err := ps.cn.readReply()
+		if err != nil {
+			select {
+			case <-ps.closing:
+			default:
+				ps.err = err
+			}
+			return
+		}
+		// confirmations of later subscriptions are dropped
+		msg := parseMessage(reply)
+		if msg == nil {
+			continue
+		}
+		select {
+		case ps.messages <- msg:
+		case <-ps.closing:
+		}
+	}
+}
+
+// Channel returns the received messages. It is closed when the PubSub is closed or its connection fails.
+func (ps *PubSub) Channel() <-chan *Message {
+	return ps.messages
+}
+
+// Err returns why the connection failed once Channel is closed, nil after Close
+func (ps *PubSub) Err() error {
+	<-ps.done
+	return ps.err
+}
+
+// Subscribe subscribes to more channels, it does not wait for the confirmation
+func (ps *PubSub) Subscribe(channels ...string) error {
+	return ps.send("SUBSCRIBE", channels)
+}
+
+// Unsubscribe unsubscribes from channels, from all of them without arguments
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+	return ps.send("UNSUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes to more patterns, it does not wait for the confirmation
+func (ps *PubSub) PSubscribe(patterns ...string) error {
+	return ps.send("PSUBSCRIBE", patterns)
+}
+
+// PUnsubscribe unsubscribes from patterns, from all of them without arguments
+func (ps *PubSub) PUnsubscribe(patterns ...string) error {
+	return ps.send("PUNSUBSCRIBE", patterns)
+}
+
+// SSubscribe subscribes to more shard channels, it does not wait for the confirmation
+func (ps *PubSub) SSubscribe(channels ...string) error {
+	return ps.send("SSUBSCRIBE", channels)
+}
+
+// SUnsubscribe unsubscribes from shard channels, from all of them without arguments
+func (ps *PubSub) SUnsubscribe(channels ...string) error {
+	return ps.send("SUNSUBSCRIBE", channels)
+}
+
+func (ps *PubSub) send(command string, names []string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.cn.writeCommands(subscription(command, names))
+}
+
+// Close closes the connection and waits for the reader to stop
+func (ps *PubSub) Close() error {
+	var err error
+	ps.closeOnce.Do(func() {
+		close(ps.closing)
+		err = ps.cn.netConn.Close()
+		<-ps.done
+	})
+	return err
+}
+
+func subscription(command string, names []string) [][]byte {
+	cmdLine := make([][]byte, 0, len(names)+1)
+	cmdLine = append(cmdLine, []byte(command))
+	for _, name := range names {
+		cmdLine = append(cmdLine, []byte(name))
+	}
+	return cmdLine
+}
+
+// parseMessage returns the message carried by reply, nil for other replies such as confirmations
+func parseMessage(reply Reply) *Message {
+	elements, ok := elementsOf(reply)
+	if !ok || len(elements) < 3 {
+		return nil
+	}
+	kind, _ := String(elements[0], nil)
+	switch {
+	case (kind == "message" || kind == "smessage") && len(elements) == 3:
+		channel, _ := String(elements[1], nil)
+		return &Message{Channel: channel, Payload: payloadOf(elements[2])}
+	case kind == "pmessage" && len(elements) == 4:
+		pattern, _ := String(elements[1], nil)
+		channel, _ := String(elements[2], nil)
+		return &Message{Channel: channel, Pattern: pattern, Payload: payloadOf(elements[3])}
+	}
+	return nil
+}
+
+func payloadOf(reply Reply) []byte {
+	if bulk, ok := reply.(*resp.BulkReply); ok {
+		return bulk.Arg
+	}
+	s, _ := String(reply, nil)
+	return []byte(s)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mirage208/redis-go/internal/resp"
+)
+
+// The reply types are those of the server, exported here so that callers can inspect replies
+type (
+	Reply          = resp.Reply
+	StatusReply    = resp.StatusReply
+	IntegerReply   = resp.IntegerReply
+	BulkReply      = resp.BulkReply
+	MultiBulkReply = resp.MultiBulkReply
+	ArrayReply     = resp.ArrayReply
+	MapReply       = resp.MapReply
+	SetReply       = resp.SetReply
+	PushReply      = resp.PushReply
+	DoubleReply    = resp.DoubleReply
+	BooleanReply   = resp.BooleanReply
+	NullReply      = resp.NullReply
+	NullBulkReply  = resp.NullBulkReply
+	// Error is an error replied by the server, Do returns it as its error
+	Error = resp.ErrorReply
+)
+
+// ErrNil is returned by the conversion helpers for a null reply
+var ErrNil = errors.New("client: nil reply")
+
+// String converts a status, bulk string or number reply. It takes the results of Do
+// directly: s, err := client.String(c.Do(ctx, "GET", "key")).
+func String(reply Reply, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch r := reply.(type) {
+	case *resp.StatusReply:
+		return r.Status, nil
+	case *resp.BulkReply:
+		return string(r.Arg), nil
+	case *resp.IntegerReply:
+		return strconv.FormatInt(r.Code, 10), nil
+	case *resp.DoubleReply:
+		return resp.FormatDouble(r.Value), nil
+	case *resp.VerbatimReply:
+		return string(r.Text), nil
+	case *resp.NullBulkReply, *resp.NullReply:
+		return "", ErrNil
+	}
+	return "", fmt.Errorf("client: unexpected reply %T for a string", reply)
+}
+
+// Int64 converts an integer reply, or a string holding an integer
+func Int64(reply Reply, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	if r, ok := reply.(*resp.IntegerReply); ok {
+		return r.Code, nil
+	}
+	s, err := String(reply, nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// Strings converts an array reply whose elements are strings, null elements become ""
+func Strings(reply Reply, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	elements, ok := elementsOf(reply)
+	if !ok {
+		if _, null := reply.(*resp.NullBulkReply); null {
+			return nil, ErrNil
+		}
+		return nil, fmt.Errorf("client: unexpected reply %T for an array", reply)
+	}
+	values := make([]string, len(elements))
+	for i, element := range elements {
+		value, err := String(element, nil)
+		if err != nil && err != ErrNil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// elementsOf returns the elements of any aggregate reply, maps as alternating keys and values
+func elementsOf(reply Reply) ([]Reply, bool) {
+	switch r := reply.(type) {
+	case *resp.MultiBulkReply:
+		elements := make([]Reply, len(r.Args))
+		for i, arg := range r.Args {
+			if arg == nil {
+				elements[i] = resp.MakeNullBulkReply()
+			} else {
+				elements[i] = resp.MakeBulkReply(arg)
+			}
+		}
+		return elements, true
+	case *resp.EmptyMultiBulkReply:
+		return nil, true
+	case *resp.ArrayReply:
+		return r.Replies, true
+	case *resp.PushReply:
+		return r.Replies, true
+	case *resp.SetReply:
+		return r.Members, true
+	case *resp.MapReply:
+		return r.Pairs, true
+	}
+	return nil, false
+}
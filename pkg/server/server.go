@@ -0,0 +1,198 @@
+// Package server runs the server in process, typically for tests. Every Server has
+// its own configuration, data and listener, so several can run side by side.
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/mirage208/redis-go/common/utils"
+	"github.com/mirage208/redis-go/internal/config"
+	"github.com/mirage208/redis-go/internal/transport"
+)
+
+// Options configure a Server, the zero value starts an empty server on a free port
+type Options struct {
+	// Addr is the address to listen on, 127.0.0.1:0 by default which picks a free port
+	Addr string
+	// Dir is where snapshots and the append only file are written. By default a
+	// temporary directory is created, and removed by Close.
+	Dir string
+
+	AppendOnly  bool
+	AppendFsync string // always, everysec or no, everysec by default
+	// Save holds automatic snapshot rules like the save directive, e.g. "3600 1 300 100".
+	// By default there are none: snapshots are only taken by SAVE and BGSAVE.
+	Save string
+	// Password is required from clients before they run commands, like require-pass
+	Password string
+	// MaxClients bounds the connections served at once, unlimited if 0. Timeout is the
+	// number of seconds after which idle clients are closed, never if 0.
+	MaxClients int
+	Timeout    int
+
+	// TLSAddr is where TLS is served, alongside Addr, 127.0.0.1:0 by default. TLS is
+	// enabled by TLSCertFile and TLSKeyFile, the other fields are the tls-* directives.
+	TLSAddr        string
+	TLSCertFile    string
+	TLSKeyFile     string
+	TLSCACertFile  string
+	TLSAuthClients string
+
+	// ReplicaOf is the address of a master to replicate, host:port. MasterAuth is the
+	// password the replica authenticates with, like masterauth.
+	ReplicaOf  string
+	MasterAuth string
+	// ReplBacklogSize is the size of the replication backlog in bytes, 1mb if 0
+	ReplBacklogSize int
+
+	// UnixSocket is the path of a Unix socket to serve on as well, with the permissions of UnixSocketPerm
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
+}
+
+type Server struct {
+	listener    net.Listener
+	tlsListener net.Listener // nil unless TLS is enabled
+	closeCh     chan struct{}
+	done        chan struct{} // closed once the server stopped
+	tempDir     string
+
+	closeOnce sync.Once
+}
+
+// Start listens on opts.Addr and serves in the background. It returns the address
+// clients connect to, which holds the actual port when a free port was picked.
+func Start(opts Options) (*Server, string, error) {
+	s := &Server{
+		closeCh: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	cfg := &config.ServerProperties{
+		RunID:       utils.RandString(40),
+		Dir:         opts.Dir,
+		AppendOnly:  opts.AppendOnly,
+		AppendFsync: opts.AppendFsync,
+		Save:        opts.Save,
+		RequirePass: opts.Password,
+		MaxClients:  opts.MaxClients,
+		Timeout:     opts.Timeout,
+
+		MasterAuth:      opts.MasterAuth,
+		ReplBacklogSize: opts.ReplBacklogSize,
+	}
+	if opts.ReplicaOf != "" {
+		host, port, err := net.SplitHostPort(opts.ReplicaOf)
+		if err != nil {
+			return nil, "", err
+		}
+		cfg.ReplicaOf = host + " " + port
+	}
+	if cfg.Dir == "" {
+		dir, err := os.MkdirTemp("", "redis-go-")
+		if err != nil {
+			return nil, "", err
+		}
+		cfg.Dir, s.tempDir = dir, dir
+	}
+
+	addr := opts.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.removeTempDir()
+		return nil, "", err
+	}
+	s.listener = listener
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	cfg.Bind, cfg.Port = tcpAddr.IP.String(), tcpAddr.Port
+	listeners := []net.Listener{listener}
+
+	if opts.TLSCertFile != "" {
+		cfg.TLSCertFile, cfg.TLSKeyFile = opts.TLSCertFile, opts.TLSKeyFile
+		cfg.TLSCACertFile, cfg.TLSAuthClients = opts.TLSCACertFile, opts.TLSAuthClients
+		if err := s.listenTLS(cfg, opts.TLSAddr); err != nil {
+			_ = listener.Close()
+			s.removeTempDir()
+			return nil, "", err
+		}
+		listeners = append(listeners, s.tlsListener)
+	}
+
+	if opts.UnixSocket != "" {
+		unixListener, err := transport.ListenUnix(opts.UnixSocket, opts.UnixSocketPerm)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			s.removeTempDir()
+			return nil, "", err
+		}
+		cfg.UnixSocket = opts.UnixSocket
+		listeners = append(listeners, unixListener)
+	}
+
+	handler := transport.NewHandler(cfg)
+	go func() {
+		defer close(s.done)
+		transport.Serve(listeners, handler, s.closeCh)
+	}()
+	return s, listener.Addr().String(), nil
+}
+
+func (s *Server) listenTLS(cfg *config.ServerProperties, addr string) error {
+	tlsConfig, err := transport.NewTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	s.tlsListener = listener
+	cfg.TLSPort = listener.Addr().(*net.TCPAddr).Port
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// TLSAddr returns the address TLS is served on, empty unless TLS is enabled
+func (s *Server) TLSAddr() string {
+	if s.tlsListener == nil {
+		return ""
+	}
+	return s.tlsListener.Addr().String()
+}
+
+// Done is closed once the server stopped, after Close or a SHUTDOWN command
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close drains the clients, flushes the append only file and waits until the server stopped
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+		<-s.done
+		err = s.removeTempDir()
+	})
+	return err
+}
+
+func (s *Server) removeTempDir() error {
+	if s.tempDir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.tempDir)
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/pkg/client"
+)
+
+// startServer starts a server with opts which is closed with the test, and returns it
+// with the address it listens on
+func startServer(t *testing.T, opts Options) (*Server, string) {
+	t.Helper()
+	s, addr, err := Start(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, addr
+}
+
+// newClient returns a client which is closed with the test
+func newClient(t *testing.T, opts client.Options) *client.Client {
+	c := client.New(opts)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestIsolatedServers(t *testing.T) {
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Parallel()
+			_, addr := startServer(t, Options{})
+			c := newClient(t, client.Options{Addr: addr})
+
+			if _, err := client.String(c.Do(ctx, "GET", "key")); err != client.ErrNil {
+				t.Fatalf("expected an empty server, got %v", err)
+			}
+			if _, err := c.Do(ctx, "SET", "key", i); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := client.Int64(c.Do(ctx, "GET", "key")); err != nil || v != int64(i) {
+				t.Fatalf("expected %d, got %d %v", i, v, err)
+			}
+		})
+	}
+}
+
+func TestRestartWithAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{Dir: t.TempDir(), AppendOnly: true}
+	s, addr := startServer(t, opts)
+	c := newClient(t, client.Options{Addr: addr})
+	if _, err := c.Do(ctx, "SET", "key", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, addr = startServer(t, opts)
+	c = newClient(t, client.Options{Addr: addr})
+	if v, err := client.String(c.Do(ctx, "GET", "key")); err != nil || v != "kept" {
+		t.Fatalf("expected the key to survive a restart, got %q %v", v, err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	ctx := context.Background()
+	_, addr := startServer(t, Options{})
+	c := newClient(t, client.Options{Addr: addr})
+
+	ps, err := c.PSubscribe(ctx, "news.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if n, err := client.Int64(c.Do(ctx, "PUBLISH", "news.sport", "goal")); err != nil || n != 1 {
+		t.Fatalf("expected 1 receiver, got %d %v", n, err)
+	}
+	select {
+	case msg := <-ps.Channel():
+		if msg.Pattern != "news.*" || msg.Channel != "news.sport" || string(msg.Payload) != "goal" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestClientTracking(t *testing.T) {
+	ctx := context.Background()
+	_, addr := startServer(t, Options{})
+	invalidated := make(chan string, 10)
+	tracker := newClient(t, client.Options{Addr: addr, Protocol: 3, PoolSize: 1, OnPush: func(push *client.PushReply) {
+		if kind, _ := client.String(push.Replies[0], nil); kind == "invalidate" {
+			keys, _ := client.Strings(push.Replies[1], nil)
+			invalidated <- keys[0]
+		}
+	}})
+	writer := newClient(t, client.Options{Addr: addr})
+
+	if _, err := tracker.Do(ctx, "CLIENT", "TRACKING", "ON"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.String(tracker.Do(ctx, "GET", "k")); err != client.ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if _, err := writer.Do(ctx, "SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	// pushes are read along with replies
+	deadline := time.Now().Add(5 * time.Second)
+	for len(invalidated) == 0 && time.Now().Before(deadline) {
+		if _, err := tracker.Do(ctx, "PING"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case key := <-invalidated:
+		if key != "k" {
+			t.Errorf("expected k to be invalidated, got %q", key)
+		}
+	default:
+		t.Fatal("no invalidation received")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	ctx := context.Background()
+	_, addr := startServer(t, Options{Password: "secret"})
+
+	anonymous := newClient(t, client.Options{Addr: addr})
+	var serverErr *client.Error
+	if _, err := anonymous.Do(ctx, "GET", "k"); !errors.As(err, &serverErr) || !strings.HasPrefix(serverErr.Error(), "NOAUTH") {
+		t.Errorf("expected NOAUTH, got %v", err)
+	}
+	// AUTH applies to the commands pipelined after it
+	replies, err := anonymous.Pipelined(ctx, func(p *client.Pipeline) {
+		p.Do("AUTH", "secret")
+		p.Do("SET", "k", "v")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := client.String(replies[1], nil); err != nil || v != "OK" {
+		t.Errorf("expected SET to run after AUTH, got %q %v", v, err)
+	}
+
+	c := newClient(t, client.Options{Addr: addr, Password: "secret", Protocol: 3})
+	if v, err := client.String(c.Do(ctx, "GET", "k")); err != nil || v != "v" {
+		t.Errorf("expected v, got %q %v", v, err)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	s, addr := startServer(t, Options{UnixSocket: path, UnixSocketPerm: 0o700})
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected the socket to have mode 0700, got %v %v", info, err)
+	}
+
+	unixClient := newClient(t, client.Options{Network: "unix", Addr: path})
+	if _, err := unixClient.Do(ctx, "SET", "key", "shared"); err != nil {
+		t.Fatal(err)
+	}
+	tcpClient := newClient(t, client.Options{Addr: addr})
+	if v, err := client.String(tcpClient.Do(ctx, "GET", "key")); err != nil || v != "shared" {
+		t.Fatalf("expected the TCP port to serve the same data, got %q %v", v, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the socket file to be removed on close, got %v", err)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	ctx := context.Background()
+	_, addr := startServer(t, Options{MaxClients: 1})
+	c := newClient(t, client.Options{Addr: addr, PoolSize: 1})
+	if _, err := c.Do(ctx, "PING"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	if err != nil || string(reply) != "-ERR max number of clients reached\r\n" {
+		t.Errorf("expected the connection to be refused, got %q %v", reply, err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, addr := startServer(t, Options{Timeout: 1})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the idle client to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("closed after %v, before the timeout", elapsed)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	ctx := context.Background()
+	_, addr := startServer(t, Options{})
+	victim, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer victim.Close()
+	if _, err := victim.Write([]byte("CLIENT SETNAME victim\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(victim, reply); err != nil || string(reply) != "+OK\r\n" {
+		t.Fatalf("unexpected reply to CLIENT SETNAME %q %v", reply, err)
+	}
+
+	c := newClient(t, client.Options{Addr: addr})
+	if n, err := client.Int64(c.Do(ctx, "CLIENT", "KILL", "ADDR", victim.LocalAddr().String())); err != nil || n != 1 {
+		t.Fatalf("expected one client killed, got %d %v", n, err)
+	}
+	_ = victim.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := victim.Read(reply); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the killed client to be disconnected, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{Dir: t.TempDir(), AppendOnly: true}
+	s, addr := startServer(t, opts)
+	c := newClient(t, client.Options{Addr: addr})
+	if _, err := c.Do(ctx, "SET", "key", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(ctx, "SHUTDOWN"); err == nil {
+		t.Fatal("expected the connection to be closed by SHUTDOWN")
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop")
+	}
+
+	_, addr = startServer(t, opts)
+	c = newClient(t, client.Options{Addr: addr})
+	if v, err := client.String(c.Do(ctx, "GET", "key")); err != nil || v != "kept" {
+		t.Errorf("expected the data to survive the shutdown, got %q %v", v, err)
+	}
+}
+
+// waitFor polls cond until it holds, or fails the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	_, masterAddr := startServer(t, Options{})
+	m := newClient(t, client.Options{Addr: masterAddr})
+	if _, err := m.Do(ctx, "SET", "before", "sync"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Do(ctx, "SADD", "set", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, replicaAddr := startServer(t, Options{ReplicaOf: masterAddr})
+	r := newClient(t, client.Options{Addr: replicaAddr})
+	get := func(key string) string {
+		v, _ := client.String(r.Do(ctx, "GET", key))
+		return v
+	}
+	waitFor(t, "the full sync", func() bool { return get("before") == "sync" })
+	if n, err := client.Int64(r.Do(ctx, "SCARD", "set")); err != nil || n != 2 {
+		t.Errorf("expected the set to be synced, got %d %v", n, err)
+	}
+
+	if _, err := m.Do(ctx, "SET", "after", "sync"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the stream", func() bool { return get("after") == "sync" })
+	if _, err := r.Do(ctx, "SET", "key", "value"); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Errorf("expected the replica to be read only, got %v", err)
+	}
+	info := func(c *client.Client) string {
+		v, _ := client.String(c.Do(ctx, "INFO", "replication", "stats"))
+		return v
+	}
+	if v := info(r); !strings.Contains(v, "role:slave") || !strings.Contains(v, "master_link_status:up") {
+		t.Errorf("unexpected replica INFO:\n%s", v)
+	}
+
+	// the replica continues from the backlog after losing its link
+	if _, err := m.Do(ctx, "CLIENT", "KILL", "TYPE", "replica"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Do(ctx, "SET", "after", "reconnect"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the partial resync", func() bool { return get("after") == "reconnect" })
+	v := info(m)
+	if !strings.Contains(v, "sync_full:1") || !strings.Contains(v, "sync_partial_ok:1") || !strings.Contains(v, "connected_slaves:1") {
+		t.Errorf("expected one full and one partial resync, got:\n%s", v)
+	}
+
+	if _, err := r.Do(ctx, "REPLICAOF", "NO", "ONE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Do(ctx, "SET", "key", "value"); err != nil {
+		t.Errorf("expected the promoted replica to accept writes, got %v", err)
+	}
+}
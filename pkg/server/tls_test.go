@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mirage208/redis-go/pkg/client"
+)
+
+// testCert is a certificate signed by a test CA, written to PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// makeCert creates a certificate for 127.0.0.1 signed by ca, a self-signed CA if ca is nil
+func makeCert(t *testing.T, dir, name string, ca *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePEM(t, c.certFile, "CERTIFICATE", der)
+	writePEM(t, c.keyFile, "EC PRIVATE KEY", keyDER)
+	return c
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ca := makeCert(t, dir, "ca", nil)
+	serverCert := makeCert(t, dir, "server", ca)
+	clientCert := makeCert(t, dir, "client", ca)
+
+	s, addr, err := Start(Options{
+		TLSCertFile:   serverCert.certFile,
+		TLSKeyFile:    serverCert.keyFile,
+		TLSCACertFile: ca.certFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	keyPair, err := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsClient := client.New(client.Options{
+		Addr:      s.TLSAddr(),
+		TLSConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{keyPair}},
+	})
+	defer tlsClient.Close()
+	if _, err := tlsClient.Do(ctx, "SET", "key", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the plain port serves the same data
+	plainClient := client.New(client.Options{Addr: addr})
+	defer plainClient.Close()
+	if v, err := client.String(plainClient.Do(ctx, "GET", "key")); err != nil || v != "secret" {
+		t.Fatalf("expected the plain port to serve the data, got %q %v", v, err)
+	}
+
+	// client certificates are required once a CA is configured
+	anonymous := client.New(client.Options{
+		Addr:      s.TLSAddr(),
+		TLSConfig: &tls.Config{RootCAs: roots},
+	})
+	defer anonymous.Close()
+	if _, err := anonymous.Do(ctx, "PING"); err == nil {
+		t.Error("expected a client without certificate to be refused")
+	}
+}
+
+func TestTLSOptionalClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeCert(t, dir, "ca", nil)
+	serverCert := makeCert(t, dir, "server", ca)
+
+	s, _, err := Start(Options{
+		TLSCertFile:    serverCert.certFile,
+		TLSKeyFile:     serverCert.keyFile,
+		TLSCACertFile:  ca.certFile,
+		TLSAuthClients: "optional",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := client.New(client.Options{Addr: s.TLSAddr(), TLSConfig: &tls.Config{RootCAs: roots}})
+	defer c.Close()
+	if v, err := client.String(c.Do(context.Background(), "PING")); err != nil || v != "PONG" {
+		t.Fatalf("expected PONG without client certificate, got %q %v", v, err)
+	}
+
+	if _, _, err := Start(Options{TLSCertFile: serverCert.certFile, TLSKeyFile: serverCert.keyFile, TLSAuthClients: "yes"}); err == nil {
+		t.Error("expected client authentication without CA to be refused")
+	}
+}